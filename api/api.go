@@ -0,0 +1,270 @@
+// Package api serves a REST API for listing and controlling Kasa devices.
+//
+//	GET  /devices               devices from the most recent poll
+//	GET  /devices/{id}          current state of a device, read from the device
+//	POST /devices/{id}/relay    {"on": true} switches the relay of a plug
+//	POST /devices/{id}/light    kasa.LightState, for example {"on_off": 1,
+//	                            "brightness": 40}, sets the state of a bulb
+//
+// Devices are identified by device ID, or by address if they do not report
+// one, as with inventory.Key. Both commands respond with the device's state
+// after the change. Errors are returned as JSON, for example:
+//
+//	{"error": {"code": "not_supported", "message": "...", "device_code": -1}}
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// DefaultTimeout of requests to devices.
+const DefaultTimeout = 5 * time.Second
+
+// maxBodySize of command requests.
+const maxBodySize = 1 << 16
+
+// Server for the REST API.
+type Server struct {
+	inv     *inventory.Inventory
+	laddr   *net.UDPAddr
+	timeout time.Duration
+}
+
+type Option func(*Server)
+
+// WithLocalAddr from which requests are sent to devices.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(s *Server) {
+		s.laddr = laddr
+	}
+}
+
+// WithTimeout of each request to a device. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.timeout = timeout
+	}
+}
+
+// New Server for the devices found using discover.
+func New(discover inventory.DiscoverFunc, opts ...Option) *Server {
+	s := &Server{
+		inv:     inventory.New(discover, inventory.DiffOptions{}),
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run polls for devices every interval until the context is canceled. Poll
+// errors are passed to handleError, if it is not nil, and do not stop polling.
+func (s *Server) Run(ctx context.Context, interval time.Duration, handleError func(error)) error {
+	return s.inv.Run(ctx, interval, func(_ []inventory.Event, err error) {
+		if err != nil && handleError != nil {
+			handleError(err)
+		}
+	})
+}
+
+// Device as returned by the API.
+type Device struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Alias   string `json:"alias"`
+	Model   string `json:"model"`
+	// Relay state of plugs and switches, or whether a bulb is on.
+	On bool `json:"on"`
+	// Light state of smart bulbs.
+	Light *kasa.LightState `json:"light,omitempty"`
+	// SysInfo as reported by the device.
+	SysInfo *kasa.SystemInformation `json:"sysinfo"`
+}
+
+// NewDevice from the device's sysinfo.
+func NewDevice(info *kasa.SystemInformation) Device {
+	d := Device{
+		ID:      inventory.Key(info),
+		Alias:   info.Alias,
+		Model:   info.Model,
+		On:      info.RelayState != 0,
+		Light:   info.LightState,
+		SysInfo: info,
+	}
+	if info.RemoteAddress != nil {
+		d.Address = info.RemoteAddress.String()
+	}
+	if ls := info.LightState; ls != nil {
+		d.On = ls.OnOff != nil && *ls.OnOff != 0
+	}
+	return d
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "devices" {
+		writeError(w, errNotFound)
+		return
+	}
+	switch len(parts) {
+	case 1:
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		s.listDevices(w, r)
+	case 2:
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		s.getDevice(w, r, parts[1])
+	case 3:
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		switch parts[2] {
+		case "relay":
+			s.command(w, r, parts[1], s.relayCommand)
+		case "light":
+			s.command(w, r, parts[1], s.lightCommand)
+		default:
+			writeError(w, errNotFound)
+		}
+	default:
+		writeError(w, errNotFound)
+	}
+}
+
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, errMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// listDevices from the most recent poll. With refresh=true, devices are polled
+// first.
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("refresh") == "true" {
+		if _, err := s.inv.Poll(r.Context()); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	devices := []Device{}
+	for _, info := range s.inv.Devices() {
+		devices = append(devices, NewDevice(info))
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// lookup the address of the device with the ID.
+func (s *Server) lookup(id string) (*net.UDPAddr, error) {
+	info, has := s.inv.Get(id)
+	if !has || info.RemoteAddress == nil {
+		return nil, fmt.Errorf("%w: %q", errUnknownDevice, id)
+	}
+	return info.RemoteAddress, nil
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request, id string) {
+	raddr, err := s.lookup(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	info, err := s.getSysinfo(r.Context(), raddr)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewDevice(info))
+}
+
+// command runs against the device, then responds with its new state.
+func (s *Server) command(w http.ResponseWriter, r *http.Request, id string, run func(ctx context.Context, raddr *net.UDPAddr, body []byte) error) {
+	raddr, err := s.lookup(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&body); err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	if err := run(r.Context(), raddr, body); err != nil {
+		writeError(w, err)
+		return
+	}
+	info, err := s.getSysinfo(r.Context(), raddr)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewDevice(info))
+}
+
+// relayRequest is the body of POST /devices/{id}/relay.
+type relayRequest struct {
+	On *bool `json:"on"`
+}
+
+func (s *Server) relayCommand(ctx context.Context, raddr *net.UDPAddr, body []byte) error {
+	var req relayRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	if req.On == nil {
+		return fmt.Errorf(`%w: missing "on"`, errBadRequest)
+	}
+	return s.setRelayState(ctx, raddr, *req.On)
+}
+
+func (s *Server) lightCommand(ctx context.Context, raddr *net.UDPAddr, body []byte) error {
+	var state kasa.LightState
+	if err := json.Unmarshal(body, &state); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return s.setLightState(ctx, raddr, state)
+}
+
+// Requests to devices, each bounded by the server's timeout.
+
+func (s *Server) getSysinfo(ctx context.Context, raddr *net.UDPAddr) (*kasa.SystemInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return kasa.GetDeviceSystemInformation(ctx, raddr, s.laddr)
+}
+
+func (s *Server) setRelayState(ctx context.Context, raddr *net.UDPAddr, state bool) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return kasa.SetRelayStateChecked(ctx, raddr, s.laddr, state)
+}
+
+func (s *Server) setLightState(ctx context.Context, raddr *net.UDPAddr, state kasa.LightState) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return kasa.SetLightStateChecked(ctx, raddr, s.laddr, state)
+}
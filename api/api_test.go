@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// testServer of a plug and a smart bulb, which has polled them once.
+func testServer(t *testing.T) (s *Server, modem, lamp *kasatest.Device) {
+	t.Helper()
+	modem = kasatest.Start(t, kasatest.WithDeviceID("modem"), kasatest.WithAlias("ADSL Modem"), kasatest.WithRelay(true))
+	modem.Update(func(s map[string]interface{}) { s["model"] = "HS110(US)" })
+	lamp = kasatest.Start(t,
+		kasatest.WithDeviceID("lamp"),
+		kasatest.WithAlias("Lamp"),
+		kasatest.WithLight(kasa.LightState{OnOff: intPtr(0), Brightness: intPtr(40)}))
+	lamp.Update(func(s map[string]interface{}) { s["model"] = "KL110(US)" })
+	s = New(kasatest.Discover(modem, lamp), WithTimeout(kasatest.Timeout))
+	if _, err := s.inv.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, modem, lamp
+}
+
+type response struct {
+	status int
+	body   map[string]interface{}
+}
+
+func do(t *testing.T, s *Server, method, path, body string) response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("%v %v: got Content-Type %q", method, path, got)
+	}
+	var v interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("%v %v: decoding %q: %v", method, path, rec.Body, err)
+	}
+	r := response{status: rec.Code}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		r.body = v
+	case []interface{}:
+		r.body = map[string]interface{}{"devices": v}
+	}
+	return r
+}
+
+// summary of a device in a response, omitting sysinfo.
+func summary(v interface{}) map[string]interface{} {
+	d := v.(map[string]interface{})
+	delete(d, "sysinfo")
+	return d
+}
+
+func TestListDevices(t *testing.T) {
+	s, modem, lamp := testServer(t)
+	got := do(t, s, http.MethodGet, "/devices", "")
+	if got.status != http.StatusOK {
+		t.Fatalf("got status %v, want 200", got.status)
+	}
+	var devices []map[string]interface{}
+	for _, d := range got.body["devices"].([]interface{}) {
+		devices = append(devices, summary(d))
+	}
+	want := []map[string]interface{}{
+		{
+			"id": "lamp", "address": lamp.Addr(), "alias": "Lamp", "model": "KL110(US)", "on": false,
+			"light": map[string]interface{}{"on_off": 0.0, "brightness": 40.0},
+		},
+		{"id": "modem", "address": modem.Addr(), "alias": "ADSL Modem", "model": "HS110(US)", "on": true},
+	}
+	if diff := cmp.Diff(want, devices); diff != "" {
+		t.Errorf("devices mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeviceCommands(t *testing.T) {
+	// Addresses of the devices, which are only known once they are started,
+	// are substituted for these in the wanted responses.
+	const (
+		modemAddr = "$modem"
+		lampAddr  = "$lamp"
+	)
+	for tn, tc := range map[string]struct {
+		method, path, body string
+		// faults injected into the modem after it is found.
+		faults     kasatest.Faults
+		wantStatus int
+		want       map[string]interface{}
+	}{
+		"get device": {
+			method:     http.MethodGet,
+			path:       "/devices/modem",
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"id": "modem", "address": modemAddr, "alias": "ADSL Modem", "model": "HS110(US)", "on": true},
+		},
+		"relay off": {
+			method:     http.MethodPost,
+			path:       "/devices/modem/relay",
+			body:       `{"on": false}`,
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"id": "modem", "address": modemAddr, "alias": "ADSL Modem", "model": "HS110(US)", "on": false},
+		},
+		"light on": {
+			method:     http.MethodPost,
+			path:       "/devices/lamp/light",
+			body:       `{"on_off": 1, "brightness": 80}`,
+			wantStatus: http.StatusOK,
+			want: map[string]interface{}{
+				"id": "lamp", "address": lampAddr, "alias": "Lamp", "model": "KL110(US)", "on": true,
+				"light": map[string]interface{}{"on_off": 1.0, "brightness": 80.0},
+			},
+		},
+		"unknown device": {
+			method:     http.MethodGet,
+			path:       "/devices/nope",
+			wantStatus: http.StatusNotFound,
+			want:       map[string]interface{}{"code": "not_found", "message": `unknown device: "nope"`},
+		},
+		"unknown path": {
+			method:     http.MethodGet,
+			path:       "/things",
+			wantStatus: http.StatusNotFound,
+			want:       map[string]interface{}{"code": "not_found", "message": "not found"},
+		},
+		"wrong method": {
+			method:     http.MethodGet,
+			path:       "/devices/modem/relay",
+			wantStatus: http.StatusMethodNotAllowed,
+			want:       map[string]interface{}{"code": "method_not_allowed", "message": "method not allowed"},
+		},
+		"missing relay state": {
+			method:     http.MethodPost,
+			path:       "/devices/modem/relay",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]interface{}{"code": "bad_request", "message": `bad request: missing "on"`},
+		},
+		"malformed body": {
+			method:     http.MethodPost,
+			path:       "/devices/modem/relay",
+			body:       `{`,
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]interface{}{"code": "bad_request", "message": "bad request: unexpected EOF"},
+		},
+		"light on a plug": {
+			method:     http.MethodPost,
+			path:       "/devices/modem/light",
+			body:       `{"on_off": 1}`,
+			wantStatus: http.StatusNotImplemented,
+			want: map[string]interface{}{
+				"code":        "not_supported",
+				"message":     "transition_light_state failed: error code -1: module not support",
+				"device_code": -1.0,
+			},
+		},
+		"device error": {
+			method:     http.MethodPost,
+			path:       "/devices/modem/relay",
+			body:       `{"on": true}`,
+			faults:     kasatest.Faults{ErrorCode: -10},
+			wantStatus: http.StatusBadGateway,
+			want: map[string]interface{}{
+				"code":        "device_error",
+				"message":     "set_relay_state failed: error code -10",
+				"device_code": -10.0,
+			},
+		},
+		"no response": {
+			method:     http.MethodPost,
+			path:       "/devices/modem/relay",
+			body:       `{"on": true}`,
+			faults:     kasatest.Faults{Drop: true},
+			wantStatus: http.StatusGatewayTimeout,
+			want:       map[string]interface{}{"code": "device_timeout", "message": "no response from " + modemAddr},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			s, modem, lamp := testServer(t)
+			modem.SetFaults(tc.faults)
+			got := do(t, s, tc.method, tc.path, tc.body)
+			if got.status != tc.wantStatus {
+				t.Errorf("got status %v, want %v", got.status, tc.wantStatus)
+			}
+			body := got.body
+			if e, isErr := body["error"]; isErr {
+				body = e.(map[string]interface{})
+			} else {
+				body = summary(body)
+			}
+			addrs := strings.NewReplacer(modemAddr, modem.Addr(), lampAddr, lamp.Addr())
+			want := make(map[string]interface{}, len(tc.want))
+			for k, v := range tc.want {
+				if s, ok := v.(string); ok {
+					v = addrs.Replace(s)
+				}
+				want[k] = v
+			}
+			if diff := cmp.Diff(want, body); diff != "" {
+				t.Errorf("body mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
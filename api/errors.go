@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+var (
+	errNotFound         = errors.New("not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errUnknownDevice    = errors.New("unknown device")
+	errBadRequest       = errors.New("bad request")
+)
+
+// Error is the body of error responses.
+type Error struct {
+	// Code identifying the kind of error, for example not_found.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// DeviceCode is the error code reported by the device, if any.
+	DeviceCode int `json:"device_code,omitempty"`
+}
+
+type errorResponse struct {
+	Error Error `json:"error"`
+}
+
+// deviceCodes maps error codes reported by devices to API errors. Other codes
+// are reported as device_error.
+var deviceCodes = map[int]struct {
+	status int
+	code   string
+}{
+	-1: {http.StatusNotImplemented, "not_supported"}, // module not support
+	-2: {http.StatusNotImplemented, "not_supported"}, // member not support
+	-3: {http.StatusBadRequest, "invalid_argument"},
+}
+
+// classify an error as an HTTP status and Error.
+func classify(err error) (int, Error) {
+	e := Error{Message: err.Error()}
+	var de *kasa.DeviceError
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, errUnknownDevice):
+		e.Code = "not_found"
+		return http.StatusNotFound, e
+	case errors.Is(err, errMethodNotAllowed):
+		e.Code = "method_not_allowed"
+		return http.StatusMethodNotAllowed, e
+	case errors.Is(err, errBadRequest):
+		e.Code = "bad_request"
+		return http.StatusBadRequest, e
+	case errors.As(err, &de):
+		e.DeviceCode = de.Code
+		if m, has := deviceCodes[de.Code]; has {
+			e.Code = m.code
+			return m.status, e
+		}
+		e.Code = "device_error"
+		return http.StatusBadGateway, e
+	case errors.Is(err, kasa.ErrNoResponse), errors.Is(err, context.DeadlineExceeded):
+		e.Code = "device_timeout"
+		return http.StatusGatewayTimeout, e
+	case errors.Is(err, kasa.ErrMalformedResponse):
+		e.Code = "malformed_response"
+		return http.StatusBadGateway, e
+	}
+	e.Code = "internal"
+	return http.StatusInternalServerError, e
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, e := classify(err)
+	writeJSON(w, status, errorResponse{Error: e})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cfunkhouser/kasa/rules"
+)
+
+// automation rules read from path if set, or else from the config, with
+// configured device names resolved to addresses.
+func automation(cfg *config, path string) (*rules.Config, error) {
+	var rc rules.Config
+	switch {
+	case path != "":
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(raw, &rc); err != nil {
+			return nil, fmt.Errorf("invalid rules %v: %w", path, err)
+		}
+	case cfg.Automation != nil:
+		rc = *cfg.Automation
+	}
+	if len(rc.Rules) == 0 {
+		return nil, errors.New("no rules given with --rules or in the config")
+	}
+	rc.Rules = append([]rules.Rule(nil), rc.Rules...)
+	for i, r := range rc.Rules {
+		if _, has := cfg.Devices[r.Device]; has {
+			daddr, err := cfg.resolve(r.Device)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: device %q: %w", r.Name, r.Device, err)
+			}
+			rc.Rules[i].Device = daddr.String()
+		}
+	}
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+func automate(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	discover, err := parseDiscover(c, cfg, laddr)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	rc, err := automation(cfg, c.String("rules"))
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	e := rules.New(discover, *rc,
+		rules.WithLocalAddr(laddr),
+		rules.WithErrorHandler(func(err error) {
+			fmt.Fprintf(os.Stderr, "Automation: %v\n", err)
+		}),
+		rules.WithEventHandler(func(ev rules.Event) {
+			fmt.Fprintln(c.App.Writer, ev)
+		}))
+	if err := e.Run(ctx, c.Duration("interval")); err != nil && !errors.Is(err, context.Canceled) {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
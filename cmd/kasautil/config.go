@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/export"
+	"github.com/cfunkhouser/kasa/notify"
+	"github.com/cfunkhouser/kasa/rules"
+)
+
+// sceneState is the desired state of a single device in a scene. Nil fields
+// are left untouched. Relay applies to plugs and switches, the remainder to
+// smart bulbs.
+type sceneState struct {
+	Relay      *bool `yaml:"relay,omitempty"`
+	Brightness *int  `yaml:"brightness,omitempty"`
+	Hue        *int  `yaml:"hue,omitempty"`
+	Saturation *int  `yaml:"saturation,omitempty"`
+	ColorTemp  *int  `yaml:"color_temp,omitempty"`
+}
+
+// config for kasautil, read from a YAML file. For example:
+//
+//	local: 10.24.6.15:54321
+//	broadcast: 10.24.6.255:9999
+//	devices:
+//	  modem: 10.24.6.14:9999
+//	  lamp: 10.24.6.16:9999
+//	groups:
+//	  living-room: [lamp, 10.24.6.17:9999]
+//	scenes:
+//	  movie:
+//	    lamp: {relay: true, brightness: 20}
+//	    modem: {relay: true}
+//	exporter:
+//	  modules:
+//	    power: {collect: [sysinfo, emeter]}
+//	  targets:
+//	    - {address: modem, module: power, labels: {room: office}}
+//	webhooks:
+//	  - url: https://chat.example.com/hooks/abc123
+//	    events: [offline, relay]
+//	    devices: [modem]
+//	automation:
+//	  rules:
+//	    - name: modem-recovery
+//	      device: modem
+//	      when: {power_below: 1}
+//	      for: 5m
+//	      action: cycle
+//	      cooldown: 30m
+type config struct {
+	Local      string                           `yaml:"local,omitempty"`
+	Broadcast  string                           `yaml:"broadcast,omitempty"`
+	Devices    map[string]string                `yaml:"devices,omitempty"`
+	Groups     map[string][]string              `yaml:"groups,omitempty"`
+	Scenes     map[string]map[string]sceneState `yaml:"scenes,omitempty"`
+	Exporter   *export.Config                   `yaml:"exporter,omitempty"`
+	Webhooks   []notify.Webhook                 `yaml:"webhooks,omitempty"`
+	Automation *rules.Config                    `yaml:"automation,omitempty"`
+}
+
+// resolve a device reference, which is either the name of a device in the
+// config or an ip:port.
+func (cfg *config) resolve(ref string) (*net.UDPAddr, error) {
+	if addr, has := cfg.Devices[ref]; has {
+		return kasa.ParseAddr(addr)
+	}
+	return kasa.ParseAddr(ref)
+}
+
+// group returns the sorted device references in the named group.
+func (cfg *config) group(name string) ([]string, error) {
+	refs, has := cfg.Groups[name]
+	if !has {
+		return nil, fmt.Errorf("no group named %q in config", name)
+	}
+	refs = append([]string(nil), refs...)
+	sort.Strings(refs)
+	return refs, nil
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kasautil", "config.yaml")
+}
+
+func readConfig(path string) (*config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %v: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// loadConfig named by the --config flag. If the flag is not set, the default
+// config path is tried, and an empty config is returned if it does not exist.
+func loadConfig(c *cli.Context) (*config, error) {
+	if p := c.String("config"); p != "" {
+		return readConfig(p)
+	}
+	p := defaultConfigPath()
+	if p == "" {
+		return &config{}, nil
+	}
+	cfg, err := readConfig(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config{}, nil
+	}
+	return cfg, err
+}
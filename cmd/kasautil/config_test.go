@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa/export"
+	"github.com/cfunkhouser/kasa/notify"
+	"github.com/cfunkhouser/kasa/rules"
+)
+
+const testConfig = `local: 10.24.6.15:54321
+broadcast: 10.24.6.255:9999
+devices:
+  modem: 10.24.6.14:9999
+  lamp: 10.24.6.16:9999
+groups:
+  living-room: [lamp, 10.24.6.17:9999]
+scenes:
+  movie:
+    lamp: {relay: on, brightness: 20}
+    modem: {relay: true}
+`
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadConfig(t *testing.T) {
+	cfg, err := readConfig(writeTestConfig(t, testConfig))
+	if err != nil {
+		t.Fatalf("readConfig(): unexpected error: %v", err)
+	}
+	yes, twenty := true, 20
+	want := &config{
+		Local:     "10.24.6.15:54321",
+		Broadcast: "10.24.6.255:9999",
+		Devices: map[string]string{
+			"modem": "10.24.6.14:9999",
+			"lamp":  "10.24.6.16:9999",
+		},
+		Groups: map[string][]string{
+			"living-room": {"lamp", "10.24.6.17:9999"},
+		},
+		Scenes: map[string]map[string]sceneState{
+			"movie": {
+				"lamp":  {Relay: &yes, Brightness: &twenty},
+				"modem": {Relay: &yes},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Errorf("readConfig(): mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	if _, err := readConfig(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readConfig(): want ErrNotExist for missing file, got: %v", err)
+	}
+	if _, err := readConfig(writeTestConfig(t, "bogus: field\n")); err == nil {
+		t.Error("readConfig(): want error for unknown field, got nil")
+	}
+}
+
+func TestConfigResolve(t *testing.T) {
+	cfg := &config{
+		Devices: map[string]string{
+			"modem": "10.24.6.14:9999",
+		},
+	}
+	for tn, tc := range map[string]struct {
+		ref     string
+		want    *net.UDPAddr
+		wantErr bool
+	}{
+		"by name": {
+			ref:  "modem",
+			want: &net.UDPAddr{IP: net.ParseIP("10.24.6.14"), Port: 9999},
+		},
+		"by address": {
+			ref:  "10.24.6.16:9999",
+			want: &net.UDPAddr{IP: net.ParseIP("10.24.6.16"), Port: 9999},
+		},
+		"unknown name": {
+			ref:     "lamp",
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			got, err := cfg.resolve(tc.ref)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("resolve(%q): unexpected error: %v", tc.ref, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("resolve(%q): mismatch (-want +got):\n%v", tc.ref, diff)
+			}
+		})
+	}
+}
+
+func TestExporterConfig(t *testing.T) {
+	cfg, err := readConfig(writeTestConfig(t, `devices:
+  modem: 10.24.6.14:9999
+exporter:
+  modules:
+    power: {collect: [sysinfo, emeter], timeout: 2s}
+  targets:
+    - {address: modem, module: power, labels: {room: office}}
+    - {address: 10.24.6.16:9999}
+`))
+	if err != nil {
+		t.Fatalf("readConfig(): unexpected error: %v", err)
+	}
+	got, err := exporterConfig(cfg)
+	if err != nil {
+		t.Fatalf("exporterConfig(): unexpected error: %v", err)
+	}
+	want := &export.Config{
+		Modules: map[string]export.Module{
+			"power": {Collect: []string{"sysinfo", "emeter"}, Timeout: 2 * time.Second},
+		},
+		Targets: []export.Target{
+			{Address: "10.24.6.14:9999", Module: "power", Labels: map[string]string{"room": "office"}},
+			{Address: "10.24.6.16:9999"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("exporterConfig(): mismatch (-want +got):\n%v", diff)
+	}
+	if cfg.Exporter.Targets[0].Address != "modem" {
+		t.Errorf("exporterConfig(): modified the loaded config")
+	}
+
+	cfg.Exporter.Targets = append(cfg.Exporter.Targets, export.Target{Address: "lamp"})
+	if _, err := exporterConfig(cfg); err == nil {
+		t.Error("exporterConfig(): want error for unknown device name, got nil")
+	}
+}
+
+func TestConfigWebhooks(t *testing.T) {
+	cfg, err := readConfig(writeTestConfig(t, `devices:
+  modem: 10.24.6.14:9999
+webhooks:
+  - url: https://chat.example.com/hooks/abc123
+    events: [offline, power]
+    devices: [modem, Heater]
+    power_above: 15
+    retries: 5
+    backoff: 2s
+`))
+	if err != nil {
+		t.Fatalf("readConfig(): unexpected error: %v", err)
+	}
+	got, err := configWebhooks(cfg)
+	if err != nil {
+		t.Fatalf("configWebhooks(): unexpected error: %v", err)
+	}
+	want := []notify.Webhook{{
+		URL:        "https://chat.example.com/hooks/abc123",
+		Events:     []notify.EventType{notify.Offline, notify.Power},
+		Devices:    []string{"10.24.6.14:9999", "Heater"},
+		PowerAbove: 15,
+		Retries:    5,
+		Backoff:    2 * time.Second,
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("configWebhooks(): mismatch (-want +got):\n%v", diff)
+	}
+	if cfg.Webhooks[0].Devices[0] != "modem" {
+		t.Errorf("configWebhooks(): modified the loaded config")
+	}
+
+	cfg.Webhooks[0].Events = append(cfg.Webhooks[0].Events, "exploded")
+	if _, err := configWebhooks(cfg); err == nil {
+		t.Error("configWebhooks(): want error for unknown event, got nil")
+	}
+}
+
+func TestAutomation(t *testing.T) {
+	cfg, err := readConfig(writeTestConfig(t, `devices:
+  modem: 10.24.6.14:9999
+automation:
+  max_actions_per_hour: 10
+  rules:
+    - name: modem-recovery
+      device: modem
+      when: {power_below: 1}
+      for: 5m
+      action: cycle
+    - name: heater-timeout
+      device: Heater
+      when: {on_for: 2h}
+      action: off
+`))
+	if err != nil {
+		t.Fatalf("readConfig(): unexpected error: %v", err)
+	}
+	got, err := automation(cfg, "")
+	if err != nil {
+		t.Fatalf("automation(): unexpected error: %v", err)
+	}
+	below := 1.0
+	want := &rules.Config{
+		MaxActionsPerHour: 10,
+		Rules: []rules.Rule{
+			{Name: "modem-recovery", Device: "10.24.6.14:9999", When: rules.Condition{PowerBelow: &below}, For: 5 * time.Minute, Action: rules.ActionCycle},
+			{Name: "heater-timeout", Device: "Heater", When: rules.Condition{OnFor: 2 * time.Hour}, Action: rules.ActionOff},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("automation(): mismatch (-want +got):\n%v", diff)
+	}
+	if cfg.Automation.Rules[0].Device != "modem" {
+		t.Errorf("automation(): modified the loaded config")
+	}
+
+	if _, err := automation(&config{}, ""); err == nil {
+		t.Error("automation(): want error for no rules, got nil")
+	}
+	cfg.Automation.Rules[1].Action = "explode"
+	if _, err := automation(cfg, ""); !errors.Is(err, rules.ErrInvalidConfig) {
+		t.Errorf("automation(): got %v, want ErrInvalidConfig", err)
+	}
+}
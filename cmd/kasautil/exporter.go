@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/export"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// exporterConfig from the exporter section of the config, with configured
+// device names resolved to addresses.
+func exporterConfig(cfg *config) (*export.Config, error) {
+	if cfg.Exporter == nil {
+		return nil, nil
+	}
+	ecfg := *cfg.Exporter
+	ecfg.Targets = make([]export.Target, len(cfg.Exporter.Targets))
+	for i, t := range cfg.Exporter.Targets {
+		daddr, err := cfg.resolve(t.Address)
+		if err != nil {
+			return nil, fmt.Errorf("exporter target %q: %w", t.Address, err)
+		}
+		t.Address = daddr.String()
+		ecfg.Targets[i] = t
+	}
+	return &ecfg, nil
+}
+
+// reloadOnHangup rereads the config and applies its exporter section to h each
+// time the process receives SIGHUP. An invalid config is reported and ignored.
+func reloadOnHangup(ctx context.Context, c *cli.Context, h *export.Handler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		cfg, err := loadConfig(c)
+		if err == nil {
+			var ecfg *export.Config
+			if ecfg, err = exporterConfig(cfg); err == nil {
+				err = h.ApplyConfig(ecfg)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed reloading config, keeping previous config: %v\n", err)
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "Reloaded config")
+	}
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "OK")
+}
+
+// readyz responds with 503 Service Unavailable until h is ready to serve
+// scrapes.
+func readyz(h *export.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "Not Ready")
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Kasa Exporter</title></head>
+<body>
+<h1>Kasa Exporter</h1>
+<p>Version {{.Version}}</p>
+<ul>
+<li><a href="/metrics">Metrics</a>{{if .All}}, including every discovered device{{end}}</li>
+<li><a href="/sd">Service Discovery</a></li>
+<li><a href="/healthz">Health</a></li>
+<li><a href="/readyz">Readiness</a></li>
+</ul>
+<h2>Targets</h2>
+{{if .Targets}}<table>
+<tr><th>Target</th><th>Module</th><th>Up</th><th>Last Poll</th></tr>
+{{range .Targets}}<tr>
+<td><a href="/scrape?target={{.Address}}&amp;module={{.Module}}">{{.Address}}</a>{{if .Pinned}} (configured){{end}}</td>
+<td>{{.Module}}</td>
+<td>{{if .Up}}yes{{else}}no{{end}}</td>
+<td>{{if .LastPoll.IsZero}}never{{else}}{{.LastPoll.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td>
+</tr>
+{{end}}</table>{{else}}<p>No targets have been scraped yet.</p>{{end}}
+</body>
+</html>
+`))
+
+// landingPage lists the exporter's endpoints, and the targets known to h with
+// links to scrape them.
+func landingPage(h *export.Handler, all bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, struct {
+			Version string
+			All     bool
+			Targets []export.KnownTarget
+		}{Version, all, h.Targets()}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed rendering landing page: %v\n", err)
+		}
+	})
+}
+
+// serveExporter until the process receives SIGINT or SIGTERM.
+func serveExporter(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return err
+	}
+	discover := c.String("discover")
+	if !c.IsSet("discover") && cfg.Broadcast != "" {
+		discover = cfg.Broadcast
+	}
+	baddr, err := kasa.ParseAddr(discover)
+	if err != nil {
+		return err
+	}
+	r := prometheus.NewRegistry()
+	if err := r.Register(versionMetric); err != nil {
+		return err
+	}
+	versionMetric.Set(1.0)
+	if c.Bool("all") {
+		discover := inventory.Broadcast(baddr, laddr)
+		copts := []export.CollectorOption{export.WithCollectTimeout(c.Duration("timeout"))}
+		if targets := c.StringSlice("target"); len(targets) > 0 {
+			var daddrs []*net.UDPAddr
+			for _, t := range targets {
+				daddr, err := cfg.resolve(t)
+				if err != nil {
+					return err
+				}
+				daddrs = append(daddrs, daddr)
+			}
+			discover = inventory.Static(daddrs, laddr)
+			copts = append(copts, export.WithStaticTargets(daddrs...))
+		}
+		if err := r.Register(export.NewCollector(discover, copts...)); err != nil {
+			return err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	resolveAll := func(refs []string) ([]string, error) {
+		var targets []string
+		for _, ref := range refs {
+			daddr, err := cfg.resolve(ref)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, daddr.String())
+		}
+		return targets, nil
+	}
+	targets, err := resolveAll(c.StringSlice("target"))
+	if err != nil {
+		return err
+	}
+	allowed, err := resolveAll(c.StringSlice("allow"))
+	if err != nil {
+		return err
+	}
+	opts := []export.Option{
+		export.WithLocalAddr(laddr),
+		export.WithTargets(targets...),
+		export.WithAllowedTargets(allowed...),
+		export.WithIdleTimeout(c.Duration("idle-timeout")),
+	}
+	sinks, err := parseSinks(c)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, sinks...)
+	interval := c.Duration("poll-interval")
+	if len(sinks) > 0 && interval <= 0 {
+		interval = defaultPushInterval
+	}
+	if interval > 0 {
+		opts = append(opts, export.WithBackgroundPolling(interval, c.Duration("stale-after")))
+	}
+	h := export.New(opts...)
+	ecfg, err := exporterConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if err := h.ApplyConfig(ecfg); err != nil {
+		return err
+	}
+	if len(sinks) > 0 && len(targets) == 0 && (ecfg == nil || len(ecfg.Targets) == 0) {
+		return cli.Exit("--push, --influxdb and --graphite need targets, given with --target or in the config", 1)
+	}
+	if err := r.Register(h); err != nil {
+		return err
+	}
+	go reloadOnHangup(ctx, c, h)
+	go func() {
+		if err := h.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "Background polling stopped: %v\n", err)
+		}
+	}()
+	mux.Handle("/scrape", h)
+	mux.HandleFunc("/healthz", healthz)
+	mux.Handle("/readyz", readyz(h))
+	mux.Handle("/", landingPage(h, c.Bool("all")))
+	mux.Handle("/sd", &sdHandler{
+		discover: inventory.Broadcast(baddr, laddr),
+		ttl:      c.Duration("sd-cache-ttl"),
+	})
+	l, err := net.Listen("tcp", c.String("metricsaddress"))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return serveHTTP(ctx, l, mux, c.String("web-config-file"), c.Duration("shutdown-timeout"))
+}
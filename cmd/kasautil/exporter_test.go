@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/kasa/export"
+)
+
+func TestLandingPage(t *testing.T) {
+	h := export.New(export.WithTargets("10.42.0.10:9999"))
+	if err := h.ApplyConfig(&export.Config{
+		Targets: []export.Target{{Address: "10.42.0.11:9999"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	landingPage(h, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("landing page: got status %v", rec.Code)
+	}
+	// Targets are not known until scraped or polled in the background.
+	if got := rec.Body.String(); !strings.Contains(got, "No targets have been scraped yet.") {
+		t.Errorf("landing page: want no targets in:\n%v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	landingPage(h, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bogus", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown path: got status %v, want %v", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		h    *export.Handler
+		want int
+	}{
+		"polling on scrape": {
+			h:    export.New(),
+			want: http.StatusOK,
+		},
+		"background polling not yet run": {
+			h:    export.New(export.WithBackgroundPolling(time.Minute, 0)),
+			want: http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			readyz(tc.h).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tc.want {
+				t.Errorf("readyz: got status %v, want %v", rec.Code, tc.want)
+			}
+		})
+	}
+}
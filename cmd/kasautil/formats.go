@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v2"
 
@@ -14,21 +21,181 @@ import (
 
 type formatter func(io.Writer, []*kasa.SystemInformation)
 
-func human(out io.Writer, infos []*kasa.SystemInformation) {
-	if len(infos) == 0 {
-		fmt.Fprintln(out, "No devices detected on local network")
-		return
+// column of tabular output.
+type column struct {
+	header string
+	value  func(*kasa.SystemInformation) string
+	// less orders two devices by this column. If nil, values are compared
+	// lexically.
+	less func(a, b *kasa.SystemInformation) bool
+}
+
+func relayState(info *kasa.SystemInformation) string {
+	if info.RelayState == 1 {
+		return "On"
 	}
-	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.DiscardEmptyColumns)
-	fmt.Fprintln(w, "Address\tAlias\tState")
-	for _, info := range infos {
-		state := "Off"
-		if info.RelayState == 1 {
-			state = "On"
+	return "Off"
+}
+
+var columns = map[string]column{
+	"address": {
+		header: "Address",
+		value: func(info *kasa.SystemInformation) string {
+			if info.RemoteAddress == nil {
+				return ""
+			}
+			return info.RemoteAddress.String()
+		},
+		less: func(a, b *kasa.SystemInformation) bool {
+			if a.RemoteAddress == nil || b.RemoteAddress == nil {
+				return b.RemoteAddress != nil
+			}
+			if c := bytes.Compare(a.RemoteAddress.IP.To16(), b.RemoteAddress.IP.To16()); c != 0 {
+				return c < 0
+			}
+			return a.RemoteAddress.Port < b.RemoteAddress.Port
+		},
+	},
+	"alias": {
+		header: "Alias",
+		value:  func(info *kasa.SystemInformation) string { return info.Alias },
+	},
+	"state": {
+		header: "State",
+		value:  relayState,
+	},
+	"model": {
+		header: "Model",
+		value:  func(info *kasa.SystemInformation) string { return info.Model },
+	},
+	"name": {
+		header: "Name",
+		value:  func(info *kasa.SystemInformation) string { return info.DevName },
+	},
+	"mac": {
+		header: "MAC",
+		value:  func(info *kasa.SystemInformation) string { return info.MAC },
+	},
+	"rssi": {
+		header: "RSSI",
+		value:  func(info *kasa.SystemInformation) string { return strconv.Itoa(info.RSSI) },
+		less:   func(a, b *kasa.SystemInformation) bool { return a.RSSI < b.RSSI },
+	},
+	"sw_ver": {
+		header: "Software",
+		value:  func(info *kasa.SystemInformation) string { return info.SoftwareVersion },
+	},
+	"hw_ver": {
+		header: "Hardware",
+		value:  func(info *kasa.SystemInformation) string { return info.HardwareVersion },
+	},
+	"on_time": {
+		header: "On Time",
+		value: func(info *kasa.SystemInformation) string {
+			return (time.Duration(info.OnTime) * time.Second).String()
+		},
+		less: func(a, b *kasa.SystemInformation) bool { return a.OnTime < b.OnTime },
+	},
+	"device_id": {
+		header: "Device ID",
+		value:  func(info *kasa.SystemInformation) string { return info.DeviceID },
+	},
+}
+
+var defaultColumns = []string{"address", "alias", "state"}
+
+// columnNames returns the sorted names of all supported columns.
+func columnNames() []string {
+	var names []string
+	for n := range columns {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupColumns by name.
+func lookupColumns(names []string) ([]column, error) {
+	cols := make([]column, 0, len(names))
+	for _, n := range names {
+		col, has := columns[n]
+		if !has {
+			return nil, fmt.Errorf("unsupported column %q, possible values: %v", n, strings.Join(columnNames(), ", "))
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// sorted wraps a formatter so that it receives devices ordered by column.
+func sorted(col column, f formatter) formatter {
+	less := col.less
+	if less == nil {
+		less = func(a, b *kasa.SystemInformation) bool {
+			return col.value(a) < col.value(b)
+		}
+	}
+	return func(out io.Writer, infos []*kasa.SystemInformation) {
+		infos = append([]*kasa.SystemInformation(nil), infos...)
+		sort.SliceStable(infos, func(i, j int) bool {
+			return less(infos[i], infos[j])
+		})
+		f(out, infos)
+	}
+}
+
+// table formats devices as aligned columns for humans.
+func table(cols []column, header bool) formatter {
+	return func(out io.Writer, infos []*kasa.SystemInformation) {
+		if len(infos) == 0 {
+			fmt.Fprintln(out, "No devices detected on local network")
+			return
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.DiscardEmptyColumns)
+		row := make([]string, len(cols))
+		if header {
+			for i, col := range cols {
+				row[i] = col.header
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		for _, info := range infos {
+			for i, col := range cols {
+				row[i] = col.value(info)
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	}
+}
+
+// csvTable formats devices as comma separated values.
+func csvTable(cols []column, header bool) formatter {
+	return func(out io.Writer, infos []*kasa.SystemInformation) {
+		w := csv.NewWriter(out)
+		row := make([]string, len(cols))
+		if header {
+			for i, col := range cols {
+				row[i] = col.header
+			}
+			_ = w.Write(row)
+		}
+		for _, info := range infos {
+			for i, col := range cols {
+				row[i] = col.value(info)
+			}
+			_ = w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "There was an error generating csv format output: %v\n", err)
 		}
-		fmt.Fprintf(w, "%v\t%v\t%v\n", info.RemoteAddress, info.Alias, state)
 	}
-	w.Flush()
+}
+
+func human(out io.Writer, infos []*kasa.SystemInformation) {
+	cols, _ := lookupColumns(defaultColumns)
+	table(cols, true)(out, infos)
 }
 
 type fileSDConfig struct {
@@ -38,11 +205,19 @@ type fileSDConfig struct {
 
 var now = time.Now
 
-func promFileSD(out io.Writer, infos []*kasa.SystemInformation) {
+func writeFileSD(out io.Writer, groups []fileSDConfig) {
 	fmt.Fprintf(out, "# Generated by kasautil version %v\n", Version)
 	fmt.Fprintf(out, "#           at %v\n", now().UTC().Format(time.RFC3339))
 	fmt.Fprintln(out, "---")
 
+	enc := yaml.NewEncoder(out)
+	if err := enc.Encode(&groups); err != nil {
+		fmt.Fprintf(out, "# There was an error generating promsd format output:\n# %v\n", err)
+	}
+}
+
+// promFileSD writes all devices as a single target group with no labels.
+func promFileSD(out io.Writer, infos []*kasa.SystemInformation) {
 	var d fileSDConfig
 	if len(infos) > 0 {
 		for _, info := range infos {
@@ -50,9 +225,259 @@ func promFileSD(out io.Writer, infos []*kasa.SystemInformation) {
 		}
 		sort.Strings(d.Targets)
 	}
-	dd := []fileSDConfig{d}
-	enc := yaml.NewEncoder(out)
-	if err := enc.Encode(&dd); err != nil {
-		fmt.Fprintf(out, "# There was an error generating promsd format output:\n# %v\n", err)
+	writeFileSD(out, []fileSDConfig{d})
+}
+
+// sanitizeLabelValue strips surrounding whitespace and any non-printable
+// characters from a label value.
+func sanitizeLabelValue(v string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, v))
+}
+
+// deviceLabels describing a device, suitable for use by Prometheus relabeling.
+// Labels with empty values are omitted.
+func deviceLabels(info *kasa.SystemInformation) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range map[string]string{
+		"alias":     info.Alias,
+		"model":     info.Model,
+		"device_id": info.DeviceID,
+		"mac":       strings.ToLower(info.MAC),
+	} {
+		if v = sanitizeLabelValue(v); v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// targetGroups returns one labeled target group per device, ordered by
+// target.
+func targetGroups(infos []*kasa.SystemInformation) []fileSDConfig {
+	groups := make([]fileSDConfig, 0, len(infos))
+	for _, info := range infos {
+		groups = append(groups, fileSDConfig{
+			Targets: []string{info.RemoteAddress.String()},
+			Labels:  deviceLabels(info),
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Targets[0] < groups[j].Targets[0]
+	})
+	return groups
+}
+
+// promFileSDPerTarget writes one target group per device, labeled with details
+// about the device.
+func promFileSDPerTarget(out io.Writer, infos []*kasa.SystemInformation) {
+	writeFileSD(out, targetGroups(infos))
+}
+
+// httpSD writes one labeled target group per device in the JSON format used by
+// Prometheus HTTP service discovery.
+func httpSD(out io.Writer, infos []*kasa.SystemInformation) {
+	if err := json.NewEncoder(out).Encode(targetGroups(infos)); err != nil {
+		fmt.Fprintf(os.Stderr, "There was an error generating httpsd format output: %v\n", err)
+	}
+}
+
+type consulService struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// consulServices writes a Consul service definition file registering each
+// device as an instance of the "kasa" service.
+func consulServices(out io.Writer, infos []*kasa.SystemInformation) {
+	services := make([]consulService, 0, len(infos))
+	for _, info := range infos {
+		id := info.DeviceID
+		if id == "" {
+			id = info.RemoteAddress.String()
+		}
+		svc := consulService{
+			ID:      "kasa-" + id,
+			Name:    "kasa",
+			Address: info.RemoteAddress.IP.String(),
+			Port:    info.RemoteAddress.Port,
+			Meta:    deviceLabels(info),
+		}
+		if info.Model != "" {
+			svc.Tags = []string{sanitizeLabelValue(info.Model)}
+		}
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string][]consulService{"services": services}); err != nil {
+		fmt.Fprintf(os.Stderr, "There was an error generating consul format output: %v\n", err)
+	}
+}
+
+// dnsLabel converts a device alias into a valid DNS label, or returns the empty
+// string if nothing usable remains.
+func dnsLabel(alias string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(alias) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	label := strings.TrimRight(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// zone writes a BIND zone file snippet with an A record for each device, named
+// after its alias. Devices without a usable alias are named after their
+// address, and duplicate names are numbered.
+func zone(out io.Writer, infos []*kasa.SystemInformation) {
+	fmt.Fprintf(out, "; Generated by kasautil version %v\n", Version)
+	fmt.Fprintf(out, ";           at %v\n", now().UTC().Format(time.RFC3339))
+
+	infos = append([]*kasa.SystemInformation(nil), infos...)
+	sort.SliceStable(infos, func(i, j int) bool {
+		return columns["address"].less(infos[i], infos[j])
+	})
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	seen := make(map[string]int)
+	for _, info := range infos {
+		ip := info.RemoteAddress.IP
+		if ip.To4() == nil {
+			continue
+		}
+		name := dnsLabel(info.Alias)
+		if name == "" {
+			name = "kasa-" + strings.ReplaceAll(ip.String(), ".", "-")
+		}
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name = fmt.Sprintf("%v-%d", name, n)
+		}
+		fmt.Fprintf(w, "%v\tIN\tA\t%v\n", name, ip)
+	}
+	w.Flush()
+}
+
+// deviceRecord is the representation of a device in json and ndjson output:
+// its full system information, plus the address from which it responded.
+// Unlike in kasa.SystemInformation, fields which are zero are included, so
+// that an off relay or LED is distinguishable from an unreported one. The
+// children of power strips and light state of bulbs appear only for devices
+// which have them.
+type deviceRecord struct {
+	RemoteAddress       string           `json:"remote_address"`
+	ActiveMode          string           `json:"active_mode"`
+	Alias               string           `json:"alias"`
+	DeviceID            string           `json:"deviceId"`
+	DevName             string           `json:"dev_name"`
+	Feature             string           `json:"feature"`
+	HardwareID          string           `json:"hwId"`
+	HardwareVersion     string           `json:"hw_ver"`
+	IconHash            string           `json:"icon_hash"`
+	LEDOff              int              `json:"led_off"`
+	MAC                 string           `json:"mac"`
+	MicType             string           `json:"mic_type"`
+	Model               string           `json:"model"`
+	NTCCode             int              `json:"ntc_code"`
+	OEMID               string           `json:"oemId"`
+	OnTime              int              `json:"on_time"`
+	RelayState          int              `json:"relay_state"`
+	RSSI                int              `json:"rssi"`
+	SoftwareVersion     string           `json:"sw_ver"`
+	Status              string           `json:"status"`
+	Updating            int              `json:"updating"`
+	IsDimmable          int              `json:"is_dimmable"`
+	IsColor             int              `json:"is_color"`
+	IsVariableColorTemp int              `json:"is_variable_color_temp"`
+	Children            []childRecord    `json:"children,omitempty"`
+	LightState          *kasa.LightState `json:"light_state,omitempty"`
+}
+
+// childRecord is the representation of an outlet of a power strip in json and
+// ndjson output.
+type childRecord struct {
+	ID     string `json:"id"`
+	Alias  string `json:"alias"`
+	State  int    `json:"state"`
+	OnTime int    `json:"on_time"`
+}
+
+func newDeviceRecord(info *kasa.SystemInformation) deviceRecord {
+	r := deviceRecord{
+		ActiveMode:          info.ActiveMode,
+		Alias:               info.Alias,
+		DeviceID:            info.DeviceID,
+		DevName:             info.DevName,
+		Feature:             info.Feature,
+		HardwareID:          info.HardwareID,
+		HardwareVersion:     info.HardwareVersion,
+		IconHash:            info.IconHash,
+		LEDOff:              info.LEDOff,
+		MAC:                 info.MAC,
+		MicType:             info.MicType,
+		Model:               info.Model,
+		NTCCode:             info.NTCCode,
+		OEMID:               info.OEMID,
+		OnTime:              info.OnTime,
+		RelayState:          info.RelayState,
+		RSSI:                info.RSSI,
+		SoftwareVersion:     info.SoftwareVersion,
+		Status:              info.Status,
+		Updating:            info.Updating,
+		IsDimmable:          info.IsDimmable,
+		IsColor:             info.IsColor,
+		IsVariableColorTemp: info.IsVariableColorTemp,
+		LightState:          info.LightState,
+	}
+	if info.RemoteAddress != nil {
+		r.RemoteAddress = info.RemoteAddress.String()
+	}
+	for _, c := range info.Children {
+		r.Children = append(r.Children, childRecord{ID: c.ID, Alias: c.Alias, State: c.State, OnTime: c.OnTime})
+	}
+	return r
+}
+
+func jsonArray(out io.Writer, infos []*kasa.SystemInformation) {
+	records := make([]deviceRecord, 0, len(infos))
+	for _, info := range infos {
+		records = append(records, newDeviceRecord(info))
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(records); err != nil {
+		fmt.Fprintf(os.Stderr, "There was an error generating json format output: %v\n", err)
+	}
+}
+
+func ndjson(out io.Writer, infos []*kasa.SystemInformation) {
+	enc := json.NewEncoder(out)
+	for _, info := range infos {
+		if err := enc.Encode(newDeviceRecord(info)); err != nil {
+			fmt.Fprintf(os.Stderr, "There was an error generating ndjson format output: %v\n", err)
+			return
+		}
 	}
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -131,3 +133,316 @@ func TestPromFileSD(t *testing.T) {
 		})
 	}
 }
+
+func TestJSONFormats(t *testing.T) {
+	on, brightness := 1, 40
+	infos := []*kasa.SystemInformation{
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("1.2.3.4"),
+				Port: 9999,
+			},
+			Alias:      "Test Device",
+			DeviceID:   "8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF",
+			RelayState: 1.0,
+			RSSI:       -51,
+		},
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("4.3.2.1"),
+				Port: 9999,
+			},
+			Alias: "Other Device",
+		},
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("4.3.2.2"),
+				Port: 9999,
+			},
+			Alias:      "Lamp",
+			IsDimmable: 1,
+			LightState: &kasa.LightState{OnOff: &on, Brightness: &brightness},
+		},
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("4.3.2.3"),
+				Port: 9999,
+			},
+			Alias:    "Power Strip",
+			Children: []kasa.ChildInformation{{ID: "00", Alias: "Desk", State: 1}, {ID: "01", Alias: "Fan"}},
+		},
+		{
+			// An off plug, whose zero relay_state and on_time are reported.
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("4.3.2.4"),
+				Port: 9999,
+			},
+			Alias: "Kettle",
+			Model: "HS103(US)",
+			RSSI:  -60,
+		},
+	}
+	wantRecords := []string{
+		`{"remote_address":"1.2.3.4:9999","active_mode":"","alias":"Test Device","deviceId":"8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF","dev_name":"","feature":"","hwId":"","hw_ver":"","icon_hash":"","led_off":0,"mac":"","mic_type":"","model":"","ntc_code":0,"oemId":"","on_time":0,"relay_state":1,"rssi":-51,"sw_ver":"","status":"","updating":0,"is_dimmable":0,"is_color":0,"is_variable_color_temp":0}`,
+		`{"remote_address":"4.3.2.1:9999","active_mode":"","alias":"Other Device","deviceId":"","dev_name":"","feature":"","hwId":"","hw_ver":"","icon_hash":"","led_off":0,"mac":"","mic_type":"","model":"","ntc_code":0,"oemId":"","on_time":0,"relay_state":0,"rssi":0,"sw_ver":"","status":"","updating":0,"is_dimmable":0,"is_color":0,"is_variable_color_temp":0}`,
+		`{"remote_address":"4.3.2.2:9999","active_mode":"","alias":"Lamp","deviceId":"","dev_name":"","feature":"","hwId":"","hw_ver":"","icon_hash":"","led_off":0,"mac":"","mic_type":"","model":"","ntc_code":0,"oemId":"","on_time":0,"relay_state":0,"rssi":0,"sw_ver":"","status":"","updating":0,"is_dimmable":1,"is_color":0,"is_variable_color_temp":0,"light_state":{"on_off":1,"brightness":40}}`,
+		`{"remote_address":"4.3.2.3:9999","active_mode":"","alias":"Power Strip","deviceId":"","dev_name":"","feature":"","hwId":"","hw_ver":"","icon_hash":"","led_off":0,"mac":"","mic_type":"","model":"","ntc_code":0,"oemId":"","on_time":0,"relay_state":0,"rssi":0,"sw_ver":"","status":"","updating":0,"is_dimmable":0,"is_color":0,"is_variable_color_temp":0,"children":[{"id":"00","alias":"Desk","state":1,"on_time":0},{"id":"01","alias":"Fan","state":0,"on_time":0}]}`,
+		`{"remote_address":"4.3.2.4:9999","active_mode":"","alias":"Kettle","deviceId":"","dev_name":"","feature":"","hwId":"","hw_ver":"","icon_hash":"","led_off":0,"mac":"","mic_type":"","model":"HS103(US)","ntc_code":0,"oemId":"","on_time":0,"relay_state":0,"rssi":-60,"sw_ver":"","status":"","updating":0,"is_dimmable":0,"is_color":0,"is_variable_color_temp":0}`,
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		var b bytes.Buffer
+		ndjson(&b, infos)
+		want := strings.Join(wantRecords, "\n") + "\n"
+		if diff := cmp.Diff(want, b.String()); diff != "" {
+			t.Errorf("ndjson(): mismatch (-want +got):\n%v", diff)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var b bytes.Buffer
+		jsonArray(&b, infos)
+		var got []map[string]interface{}
+		if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+			t.Fatalf("jsonArray(): produced invalid JSON: %v", err)
+		}
+		var want []map[string]interface{}
+		if err := json.Unmarshal([]byte("["+strings.Join(wantRecords, ",")+"]"), &want); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("jsonArray(): mismatch (-want +got):\n%v", diff)
+		}
+	})
+
+	t.Run("json empty", func(t *testing.T) {
+		var b bytes.Buffer
+		jsonArray(&b, nil)
+		if got, want := b.String(), "[]\n"; got != want {
+			t.Errorf("jsonArray(): got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestColumnFormats(t *testing.T) {
+	infos := []*kasa.SystemInformation{
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("10.42.0.11"),
+				Port: 9999,
+			},
+			Alias:      "Test, Device",
+			Model:      "HS103(US)",
+			OnTime:     6831,
+			RelayState: 1.0,
+			RSSI:       -51,
+		},
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("10.42.0.10"),
+				Port: 9999,
+			},
+			Alias: "Other Device",
+			Model: "HS110(US)",
+			RSSI:  -67,
+		},
+	}
+	for tn, tc := range map[string]struct {
+		columns  []string
+		header   bool
+		sortBy   string
+		csv      bool
+		want     string
+		wantErr  bool
+		noDevice bool
+	}{
+		"human custom columns": {
+			columns: []string{"alias", "model", "on_time"},
+			header:  true,
+			want:    "Alias         Model      On Time\nTest, Device  HS103(US)  1h53m51s\nOther Device  HS110(US)  0s\n",
+		},
+		"human no header sorted by address": {
+			columns: []string{"address", "state"},
+			sortBy:  "address",
+			want:    "10.42.0.10:9999  Off\n10.42.0.11:9999  On\n",
+		},
+		"csv": {
+			columns: []string{"alias", "rssi", "state"},
+			header:  true,
+			csv:     true,
+			want:    "Alias,RSSI,State\n\"Test, Device\",-51,On\nOther Device,-67,Off\n",
+		},
+		"csv sorted by rssi": {
+			columns: []string{"address", "rssi"},
+			sortBy:  "rssi",
+			csv:     true,
+			want:    "10.42.0.10:9999,-67\n10.42.0.11:9999,-51\n",
+		},
+		"csv empty": {
+			columns:  []string{"address", "rssi"},
+			header:   true,
+			csv:      true,
+			noDevice: true,
+			want:     "Address,RSSI\n",
+		},
+		"unknown column": {
+			columns: []string{"address", "bogus"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			cols, err := lookupColumns(tc.columns)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("lookupColumns(): unexpected error: %v", err)
+			}
+			if tc.wantErr {
+				return
+			}
+			f := table(cols, tc.header)
+			if tc.csv {
+				f = csvTable(cols, tc.header)
+			}
+			if tc.sortBy != "" {
+				f = sorted(columns[tc.sortBy], f)
+			}
+			in := infos
+			if tc.noDevice {
+				in = nil
+			}
+			var b bytes.Buffer
+			f(&b, in)
+			if diff := cmp.Diff(tc.want, b.String()); diff != "" {
+				t.Errorf("formatter: mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPromFileSDPerTarget(t *testing.T) {
+	origNow := now
+	defer func() { now = origNow }()
+	now = func() time.Time {
+		return time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	}
+
+	for tn, tc := range map[string]struct {
+		infos []*kasa.SystemInformation
+		want  string
+	}{
+		"empty": {
+			want: "# Generated by kasautil version development\n#           at 2017-08-19T22:16:00Z\n---\n[]\n",
+		},
+		"labeled and ordered": {
+			infos: []*kasa.SystemInformation{
+				{
+					RemoteAddress: &net.UDPAddr{
+						IP:   net.ParseIP("10.42.0.11"),
+						Port: 9999,
+					},
+					Alias:    " Test Device\n",
+					Model:    "HS103(US)",
+					DeviceID: "8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF",
+					MAC:      "E4:C3:2A:C6:62:20",
+				},
+				{
+					RemoteAddress: &net.UDPAddr{
+						IP:   net.ParseIP("10.42.0.10"),
+						Port: 9999,
+					},
+				},
+			},
+			want: "# Generated by kasautil version development\n#           at 2017-08-19T22:16:00Z\n---\n- targets:\n  - 10.42.0.10:9999\n- targets:\n  - 10.42.0.11:9999\n  labels:\n    alias: Test Device\n    device_id: 8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF\n    mac: e4:c3:2a:c6:62:20\n    model: HS103(US)\n",
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			var b bytes.Buffer
+			promFileSDPerTarget(&b, tc.infos)
+			if diff := cmp.Diff(tc.want, b.String()); diff != "" {
+				t.Errorf("promFileSDPerTarget(): mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestServiceDiscoveryFormats(t *testing.T) {
+	origNow := now
+	defer func() { now = origNow }()
+	now = func() time.Time {
+		return time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	}
+
+	infos := []*kasa.SystemInformation{
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("10.42.0.11"),
+				Port: 9999,
+			},
+			Alias:    "ADSL Modem",
+			Model:    "HS103(US)",
+			DeviceID: "8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF",
+		},
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("10.42.0.10"),
+				Port: 9999,
+			},
+			Alias: "adsl modem!",
+		},
+		{
+			RemoteAddress: &net.UDPAddr{
+				IP:   net.ParseIP("10.42.0.12"),
+				Port: 9999,
+			},
+			Alias: "☃",
+		},
+	}
+	for tn, tc := range map[string]struct {
+		f    formatter
+		want string
+	}{
+		"httpsd": {
+			f:    httpSD,
+			want: `[{"targets":["10.42.0.10:9999"],"labels":{"alias":"adsl modem!"}},{"targets":["10.42.0.11:9999"],"labels":{"alias":"ADSL Modem","device_id":"8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF","model":"HS103(US)"}},{"targets":["10.42.0.12:9999"],"labels":{"alias":"☃"}}]` + "\n",
+		},
+		"zone": {
+			f:    zone,
+			want: "; Generated by kasautil version development\n;           at 2017-08-19T22:16:00Z\nadsl-modem      IN A 10.42.0.10\nadsl-modem-2    IN A 10.42.0.11\nkasa-10-42-0-12 IN A 10.42.0.12\n",
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			var b bytes.Buffer
+			tc.f(&b, infos)
+			if diff := cmp.Diff(tc.want, b.String()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+
+	t.Run("consul", func(t *testing.T) {
+		var b bytes.Buffer
+		consulServices(&b, infos[:1])
+		var got map[string][]consulService
+		if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+			t.Fatalf("consulServices(): produced invalid JSON: %v", err)
+		}
+		want := map[string][]consulService{
+			"services": {
+				{
+					ID:      "kasa-8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF",
+					Name:    "kasa",
+					Address: "10.42.0.11",
+					Port:    9999,
+					Tags:    []string{"HS103(US)"},
+					Meta: map[string]string{
+						"alias":     "ADSL Modem",
+						"device_id": "8006AAE0AE5DF7AA5BDCCC8A8CE55ED91DBF87BF",
+						"model":     "HS103(US)",
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("consulServices(): mismatch (-want +got):\n%v", diff)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+// result of applying an action to a single device.
+type result struct {
+	ref  string
+	addr *net.UDPAddr
+	err  error
+}
+
+// applyAll resolves each device reference and applies the action to all of
+// them concurrently. Results are returned in the same order as refs.
+func applyAll(cfg *config, refs []string, action func(ref string, daddr *net.UDPAddr) error) []result {
+	results := make([]result, len(refs))
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		results[i].ref = ref
+		daddr, err := cfg.resolve(ref)
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		results[i].addr = daddr
+		wg.Add(1)
+		go func(r *result) {
+			defer wg.Done()
+			r.err = action(r.ref, r.addr)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// report writes a summary of results, and returns an error if any failed.
+func report(out io.Writer, results []result) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Device\tAddress\tResult")
+	var failed int
+	for _, r := range results {
+		addr := "-"
+		if r.addr != nil {
+			addr = r.addr.String()
+		}
+		res := "OK"
+		if r.err != nil {
+			res = fmt.Sprintf("Failed: %v", r.err)
+			failed++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", r.ref, addr, res)
+	}
+	w.Flush()
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d devices failed", failed, len(results)), 1)
+	}
+	return nil
+}
+
+func setGroupState(c *cli.Context, state bool) error {
+	if c.NArg() != 1 {
+		return cli.Exit("specify exactly one group name", 1)
+	}
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	refs, err := cfg.group(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	results := applyAll(cfg, refs, func(_ string, daddr *net.UDPAddr) error {
+		return kasa.SetRelayStateChecked(c.Context, daddr, laddr, state)
+	})
+	return report(c.App.Writer, results)
+}
+
+// apply the scene state to the device at daddr. If any bulb fields are set,
+// the device is treated as a bulb, and Relay controls whether it is lit.
+func (s sceneState) apply(c *cli.Context, daddr, laddr *net.UDPAddr) error {
+	if s.Brightness == nil && s.Hue == nil && s.Saturation == nil && s.ColorTemp == nil {
+		if s.Relay == nil {
+			return nil
+		}
+		return kasa.SetRelayStateChecked(c.Context, daddr, laddr, *s.Relay)
+	}
+	ls := kasa.LightState{
+		Brightness: s.Brightness,
+		Hue:        s.Hue,
+		Saturation: s.Saturation,
+		ColorTemp:  s.ColorTemp,
+	}
+	if s.Relay != nil {
+		on := 0
+		if *s.Relay {
+			on = 1
+		}
+		ls.OnOff = &on
+	}
+	return kasa.SetLightStateChecked(c.Context, daddr, laddr, ls)
+}
+
+func applyScene(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.Exit("specify exactly one scene name", 1)
+	}
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	name := c.Args().First()
+	scene, has := cfg.Scenes[name]
+	if !has {
+		return cli.Exit(fmt.Sprintf("no scene named %q in config", name), 1)
+	}
+	var refs []string
+	for ref := range scene {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	results := applyAll(cfg, refs, func(ref string, daddr *net.UDPAddr) error {
+		return scene[ref].apply(c, daddr, laddr)
+	})
+	return report(c.App.Writer, results)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+// testContext for running an action with the config file, writing output to
+// the returned buffer.
+func testContext(t *testing.T, cfg string, args ...string) (*cli.Context, *bytes.Buffer) {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("config", writeTestConfig(t, cfg), "")
+	set.String("local", "", "")
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	return cli.NewContext(&cli.App{Writer: &out}, set, nil), &out
+}
+
+func TestGroupAndSceneFailures(t *testing.T) {
+	kettle := kasatest.Start(t)
+	toaster := kasatest.Start(t, kasatest.WithFaults(kasatest.Faults{ErrorCode: -10, ErrorMessage: "device busy"}))
+	fan := kasatest.Start(t, kasatest.WithFaults(kasatest.Faults{Drop: true}))
+	on := 1
+	lamp := kasatest.Start(t, kasatest.WithLight(kasa.LightState{OnOff: &on}))
+	cfg := fmt.Sprintf(`devices:
+  kettle: %v
+  toaster: %v
+  fan: %v
+  lamp: %v
+groups:
+  kitchen: [kettle, toaster, fan]
+  working: [kettle]
+scenes:
+  breakfast:
+    kettle: {relay: on}
+    lamp: {relay: off, brightness: 20}
+    toaster: {relay: on}
+`, kettle.Addr(), toaster.Addr(), fan.Addr(), lamp.Addr())
+
+	for tn, tc := range map[string]struct {
+		action     func(c *cli.Context) error
+		args       []string
+		wantFailed []string
+	}{
+		"group": {
+			action:     func(c *cli.Context) error { return setGroupState(c, true) },
+			args:       []string{"kitchen"},
+			wantFailed: []string{"fan", "toaster"},
+		},
+		"group succeeds": {
+			action: func(c *cli.Context) error { return setGroupState(c, true) },
+			args:   []string{"working"},
+		},
+		"scene": {
+			action:     applyScene,
+			args:       []string{"breakfast"},
+			wantFailed: []string{"toaster"},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			c, out := testContext(t, cfg, tc.args...)
+			err := tc.action(c)
+			var exit cli.ExitCoder
+			if len(tc.wantFailed) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v\n%s", err, out)
+				}
+			} else if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+				t.Fatalf("got error %v, want exit code 1\n%s", err, out)
+			}
+			var failed []string
+			for _, line := range strings.Split(out.String(), "\n") {
+				if strings.Contains(line, "Failed") {
+					failed = append(failed, strings.Fields(line)[0])
+				}
+			}
+			if strings.Join(failed, ",") != strings.Join(tc.wantFailed, ",") {
+				t.Errorf("got failed devices %v, want %v\n%s", failed, tc.wantFailed, out)
+			}
+		})
+	}
+	if !kettle.Relay() {
+		t.Error("kettle was not turned on")
+	}
+	if got := lamp.Light(); got.OnOff == nil || *got.OnOff != 0 || got.Brightness == nil || *got.Brightness != 20 {
+		t.Errorf("lamp light state: got %+v", got)
+	}
+}
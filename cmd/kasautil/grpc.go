@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+
+	"github.com/cfunkhouser/kasa/rpc"
+	"github.com/cfunkhouser/kasa/rpc/kasapb"
+)
+
+var defaultGRPCAddress = ":50051"
+
+func serveGRPC(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	discover, err := parseDiscover(c, cfg, laddr)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	s := rpc.New(discover, rpc.WithLocalAddr(laddr), rpc.WithTimeout(c.Duration("timeout")))
+	go func() {
+		err := s.Run(ctx, c.Duration("interval"), func(err error) {
+			fmt.Fprintf(os.Stderr, "Failed polling Kasa devices: %v\n", err)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "Polling stopped: %v\n", err)
+		}
+	}()
+	l, err := net.Listen("tcp", c.String("address"))
+	if err != nil {
+		return err
+	}
+	gs := grpc.NewServer()
+	kasapb.RegisterKasaServer(gs, s)
+	go func() {
+		<-ctx.Done()
+		// Streams never end on their own, so are cut off after the timeout.
+		t := time.AfterFunc(c.Duration("shutdown-timeout"), gs.Stop)
+		defer t.Stop()
+		gs.GracefulStop()
+	}()
+	return gs.Serve(l)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/brutella/hc"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/homekit"
+)
+
+// defaultHomeKitStorage is the directory in which pairings are stored, next
+// to the default config.
+func defaultHomeKitStorage() string {
+	if p := defaultConfigPath(); p != "" {
+		return filepath.Join(filepath.Dir(p), "homekit")
+	}
+	return "homekit"
+}
+
+// homeKitPin with which the bridge is paired: override if it is set, and
+// otherwise the PIN stored in the storage directory. A random PIN is generated
+// and stored on first run.
+func homeKitPin(storage, override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	path := filepath.Join(storage, "pin")
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	pin, err := randomHomeKitPin()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(pin+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return pin, nil
+}
+
+// randomHomeKitPin of 8 digits, avoiding those HomeKit does not allow.
+func randomHomeKitPin() (string, error) {
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(100000000))
+		if err != nil {
+			return "", err
+		}
+		pin := fmt.Sprintf("%08d", n)
+		if _, err := hc.ValidatePin(pin); err == nil {
+			return pin, nil
+		}
+	}
+}
+
+func serveHomeKit(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	discover, err := parseDiscover(c, cfg, laddr)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	b := homekit.New(discover,
+		homekit.WithLocalAddr(laddr),
+		homekit.WithName(c.String("name")),
+		homekit.WithTimeout(c.Duration("timeout")),
+		homekit.WithErrorHandler(func(err error) {
+			fmt.Fprintf(os.Stderr, "HomeKit bridge: %v\n", err)
+		}))
+	accs, err := b.Setup(ctx)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("failed finding devices: %w", err), 1)
+	}
+	storage := c.String("storage")
+	if err := os.MkdirAll(storage, 0700); err != nil {
+		return cli.Exit(err, 1)
+	}
+	pin, err := homeKitPin(storage, c.String("pin"))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("failed reading HomeKit PIN: %w", err), 1)
+	}
+	t, err := hc.NewIPTransport(hc.Config{
+		StoragePath: storage,
+		Pin:         pin,
+		Port:        c.String("port"),
+	}, b.Accessory(), accs...)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Fprintf(c.App.Writer, "Bridging %v devices to HomeKit. Pair using PIN %v.\n", len(accs), pin)
+	go t.Start()
+	err = b.Run(ctx, c.Duration("interval"))
+	<-t.Stop()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
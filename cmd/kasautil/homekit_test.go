@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/brutella/hc"
+)
+
+func TestHomeKitPin(t *testing.T) {
+	storage := t.TempDir()
+	pin, err := homeKitPin(storage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.ValidatePin(pin); err != nil {
+		t.Errorf("homeKitPin(): generated invalid PIN %q: %v", pin, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(storage, "pin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), pin+"\n"; got != want {
+		t.Errorf("stored PIN: got %q, want %q", got, want)
+	}
+
+	// The stored PIN is kept on later runs, unless overridden.
+	if got, err := homeKitPin(storage, ""); err != nil || got != pin {
+		t.Errorf("homeKitPin(): got %q, %v, want %q", got, err, pin)
+	}
+	if got, err := homeKitPin(storage, "03145154"); err != nil || got != "03145154" {
+		t.Errorf("homeKitPin() with override: got %q, %v, want %q", got, err, "03145154")
+	}
+	if got, err := homeKitPin(storage, ""); err != nil || got != pin {
+		t.Errorf("homeKitPin() after override: got %q, %v, want %q", got, err, pin)
+	}
+
+	// Every run with fresh storage gets a different PIN.
+	other, err := homeKitPin(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == pin {
+		t.Errorf("homeKitPin(): got the same PIN %q for different storage", pin)
+	}
+}
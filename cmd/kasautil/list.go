@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// writeFileAtomic writes data to a temporary file alongside path, then renames
+// it into place, so that readers never observe a partially written file. The
+// mode of an existing file is preserved.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeOutput formats infos to the file named by the output flag, or STDOUT if
+// there isn't one.
+func writeOutput(c *cli.Context, format formatter, infos []*kasa.SystemInformation) error {
+	o := c.String("output")
+	if o == "" {
+		format(c.App.Writer, infos)
+		return nil
+	}
+	var b bytes.Buffer
+	format(&b, infos)
+	return writeFileAtomic(o, b.Bytes())
+}
+
+// deviceSet returns a string identifying the set of devices and the details of
+// each which appear in output, so that successive discoveries can be compared.
+func deviceSet(infos []*kasa.SystemInformation) string {
+	devices := make([]string, 0, len(infos))
+	for _, info := range infos {
+		devices = append(devices, fmt.Sprintf("%v|%v|%v|%v|%v", info.RemoteAddress, info.DeviceID, info.Alias, info.Model, info.MAC))
+	}
+	sort.Strings(devices)
+	return strings.Join(devices, "\n")
+}
+
+func list(c *cli.Context) error {
+	daddr, laddr, err := parseAddrs(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	format, err := parseFormatter(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	every := c.Duration("every")
+	if every <= 0 {
+		infos, err := kasa.GetSystemInformation(c.Context, daddr, laddr, false)
+		if err != nil {
+			return err
+		}
+		if err := writeOutput(c, format, infos); err != nil {
+			return cli.Exit(err, 1)
+		}
+		return nil
+	}
+
+	inv := inventory.New(inventory.Broadcast(daddr, laddr), inventory.DiffOptions{
+		MissedPolls: c.Int("missed-polls"),
+	})
+	r := &rewriter{write: func(infos []*kasa.SystemInformation) error {
+		return writeOutput(c, format, infos)
+	}}
+	err = inv.Run(c.Context, every, func(_ []inventory.Event, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed discovering Kasa devices: %v\n", err)
+			return
+		}
+		if err := r.update(inv.Devices()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed writing output: %v\n", err)
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// rewriter writes output whenever the set of devices changes.
+type rewriter struct {
+	write   func([]*kasa.SystemInformation) error
+	last    string
+	written bool
+}
+
+// update with the devices found by a poll, writing them if they differ from
+// those last written.
+func (r *rewriter) update(infos []*kasa.SystemInformation) error {
+	set := deviceSet(infos)
+	if r.written && set == r.last {
+		return nil
+	}
+	if err := r.write(infos); err != nil {
+		return err
+	}
+	r.last, r.written = set, true
+	return nil
+}
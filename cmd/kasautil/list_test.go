@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "kasa.yml")
+	if err := writeFileAtomic(p, []byte("first")); err != nil {
+		t.Fatalf("writeFileAtomic(): unexpected error: %v", err)
+	}
+	if err := os.Chmod(p, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(p, []byte("second")); err != nil {
+		t.Fatalf("writeFileAtomic(): unexpected error: %v", err)
+	}
+	got, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "second" {
+		t.Errorf("writeFileAtomic(): got content %q, want %q", got, "second")
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0o640 {
+		t.Errorf("writeFileAtomic(): got mode %v, want %v", mode, os.FileMode(0o640))
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("writeFileAtomic(): left %d files in directory, want 1", len(entries))
+	}
+}
+
+func TestDeviceSet(t *testing.T) {
+	a := &kasa.SystemInformation{
+		RemoteAddress: &net.UDPAddr{IP: net.ParseIP("10.42.0.10"), Port: 9999},
+		Alias:         "Lamp",
+	}
+	b := &kasa.SystemInformation{
+		RemoteAddress: &net.UDPAddr{IP: net.ParseIP("10.42.0.11"), Port: 9999},
+		Alias:         "Modem",
+		RelayState:    1,
+	}
+	bOff := *b
+	bOff.RelayState = 0
+	bRenamed := *b
+	bRenamed.Alias = "ADSL Modem"
+
+	if deviceSet([]*kasa.SystemInformation{a, b}) != deviceSet([]*kasa.SystemInformation{&bOff, a}) {
+		t.Error("deviceSet(): want order and relay state to be ignored")
+	}
+	if deviceSet([]*kasa.SystemInformation{a, b}) == deviceSet([]*kasa.SystemInformation{a, &bRenamed}) {
+		t.Error("deviceSet(): want alias change to be detected")
+	}
+	if deviceSet([]*kasa.SystemInformation{a, b}) == deviceSet([]*kasa.SystemInformation{a}) {
+		t.Error("deviceSet(): want removed device to be detected")
+	}
+}
+
+func TestRewriterMissedPolls(t *testing.T) {
+	lamp := kasatest.Start(t, kasatest.WithAlias("Lamp"), kasatest.WithDeviceID("lamp"))
+	modem := kasatest.Start(t, kasatest.WithAlias("Modem"), kasatest.WithDeviceID("modem"))
+	inv := inventory.New(kasatest.Discover(lamp, modem), inventory.DiffOptions{})
+	var writes [][]string
+	r := &rewriter{write: func(infos []*kasa.SystemInformation) error {
+		var aliases []string
+		for _, info := range infos {
+			aliases = append(aliases, info.Alias)
+		}
+		writes = append(writes, aliases)
+		return nil
+	}}
+	poll := func() {
+		t.Helper()
+		if _, err := inv.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.update(inv.Devices()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	poll()
+	// A single dropped response does not rewrite the file without the modem,
+	// but the modem is removed once it has missed enough polls in a row.
+	modem.SetFaults(kasatest.Faults{Drop: true})
+	poll()
+	if len(writes) != 1 {
+		t.Errorf("after a dropped response: got writes %v, want 1", writes)
+	}
+	for i := 1; i < inventory.DefaultMissedPolls; i++ {
+		poll()
+	}
+	if diff := cmp.Diff([][]string{{"Lamp", "Modem"}, {"Lamp"}}, writes); diff != "" {
+		t.Errorf("writes mismatch (-want +got):\n%s", diff)
+	}
+}
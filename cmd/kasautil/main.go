@@ -2,17 +2,22 @@ package main
 
 import (
 	"fmt"
-	"net"
-	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
 	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/api"
 	"github.com/cfunkhouser/kasa/export"
+	"github.com/cfunkhouser/kasa/homekit"
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/mqtt"
+	"github.com/cfunkhouser/kasa/notify"
+	"github.com/cfunkhouser/kasa/rpc"
+	"github.com/cfunkhouser/kasa/watchdog"
 )
 
 var (
@@ -28,6 +33,9 @@ var (
 	})
 
 	defaultCycleSleep         = time.Second * 15
+	defaultWatchInterval      = time.Second * 10
+	defaultWatchdogInterval   = time.Second * 30
+	defaultIdleTimeout        = time.Hour
 	defaultPromMetricsAddress = ":9142"
 )
 
@@ -39,30 +47,18 @@ func setState(c *cli.Context, state bool) error {
 	return kasa.SetRelayState(c.Context, daddr, laddr, state)
 }
 
-func serveExporter(c *cli.Context) error {
-	var laddr *net.UDPAddr
-	if l := c.String("local"); l != "" {
-		var err error
-		if laddr, err = kasa.ParseAddr(l); err != nil {
-			return err
-		}
-	}
-	r := prometheus.NewRegistry()
-	if err := r.Register(versionMetric); err != nil {
-		return err
-	}
-	versionMetric.Set(1.0)
-	http.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
-	http.Handle("/scrape", export.New(export.WithLocalAddr(laddr)))
-	return http.ListenAndServe(c.String("metricsaddress"), nil)
-}
-
 var commonFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:    "local",
 		Usage:   "Local ip:port from which to send discovery requests",
 		Aliases: []string{"L"},
 	},
+	&cli.StringFlag{
+		Name:    "config",
+		Usage:   "Path to kasautil config file. Defaults to kasautil/config.yaml in the user config directory.",
+		Aliases: []string{"c"},
+		EnvVars: []string{"KASAUTIL_CONFIG"},
+	},
 }
 
 func main() {
@@ -86,38 +82,199 @@ func main() {
 					&cli.StringFlag{
 						Name:    "format",
 						Aliases: []string{"f"},
-						Usage:   "Possible values: promsd, human",
+						Usage:   "Possible values: promsd, httpsd, consul, zone, human, csv, json, ndjson",
 						Value:   "human",
 					},
+					&cli.StringFlag{
+						Name:  "columns",
+						Usage: "Comma separated columns for human and csv formats. Possible values: " + strings.Join(columnNames(), ", "),
+						Value: strings.Join(defaultColumns, ","),
+					},
+					&cli.StringFlag{
+						Name:  "sort",
+						Usage: "Column by which to sort devices",
+					},
+					&cli.BoolFlag{
+						Name:  "no-header",
+						Usage: "Omit the header row from human and csv formats",
+					},
+					&cli.BoolFlag{
+						Name:  "promsd-single-group",
+						Usage: "Write all promsd targets in a single group without labels",
+					},
 					&cli.StringFlag{
 						Name:    "output",
 						Aliases: []string{"o"},
 						Usage:   "File to which output is written. If unset, use STDOUT.",
 					},
+					&cli.DurationFlag{
+						Name:  "every",
+						Usage: "If set, rerun discovery on this interval, and rewrite output whenever the set of devices changes. Blocks until killed.",
+					},
+					&cli.IntFlag{
+						Name:  "missed-polls",
+						Usage: "With --every, number of discoveries in a row a device must miss before it is removed from the output",
+						Value: inventory.DefaultMissedPolls,
+					},
 				),
-				Action: func(c *cli.Context) error {
-					daddr, laddr, err := parseAddrs(c)
-					if err != nil {
-						return cli.Exit(err, 1)
-					}
-					format, err := parseFormatter(c)
-					if err != nil {
-						return cli.Exit(err, 1)
-					}
-					infos, err := kasa.GetSystemInformation(c.Context, daddr, laddr, false)
-					if err != nil {
-						return err
-					}
-					// Parse and open the output file _after_ the network call,
-					// so that if it fails, we don't truncate an extant file with
-					// garbage.
-					out, err := parseOutFile(c)
-					if err != nil {
-						return cli.Exit(err, 1)
-					}
-					format(out, infos)
-					return nil
-				},
+				Action: list,
+			},
+			{
+				Name:  "watch",
+				Usage: "Poll kasa devices on the local network and print changes. Blocks until killed.",
+				Flags: append(
+					commonFlags,
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between polls",
+						Value:   defaultWatchInterval,
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Possible values: text, json",
+						Value:   "text",
+					},
+					&cli.IntFlag{
+						Name:  "rssi-drop",
+						Usage: "Minimum drop in RSSI between polls which is reported",
+						Value: inventory.DefaultRSSIDrop,
+					},
+					&cli.IntFlag{
+						Name:  "missed-polls",
+						Usage: "Number of polls in a row a device must miss before it is reported as having disappeared",
+						Value: inventory.DefaultMissedPolls,
+					},
+				),
+				Action: watch,
+			},
+			{
+				Name:  "notify",
+				Usage: "Poll kasa devices and send webhooks when they go offline, relays toggle, power rises above a threshold or firmware changes. Blocks until killed.",
+				Flags: append(
+					commonFlags,
+					&cli.StringSliceFlag{
+						Name:  "webhook",
+						Usage: "URL to which notifications of every device are POSTed as JSON, in addition to webhooks in the config",
+					},
+					&cli.StringFlag{
+						Name:    "secret",
+						Usage:   "Secret with which --webhook requests are signed in the " + notify.SignatureHeader + " header",
+						EnvVars: []string{"KASAUTIL_WEBHOOK_SECRET"},
+					},
+					&cli.Float64Flag{
+						Name:  "power-above",
+						Usage: "Notify --webhook when a device's power rises above this many watts. Zero disables.",
+					},
+					&cli.IntFlag{
+						Name:  "offline-after",
+						Usage: "Polls in a row a device must miss before it is reported offline",
+						Value: notify.DefaultOfflineAfter,
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to watch. If unset, devices are discovered by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between polls",
+						Value:   defaultWatchInterval,
+					},
+				),
+				Action: notifyWebhooks,
+			},
+			{
+				Name:  "automate",
+				Usage: "Poll kasa devices and turn them on, off or cycle them when automation rules match, for example to cycle a hung modem. Blocks until killed.",
+				Flags: append(
+					commonFlags,
+					&cli.StringFlag{
+						Name:    "rules",
+						Aliases: []string{"r"},
+						Usage:   "YAML file of automation rules, used instead of the automation section of the config",
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to poll. If unset, devices are discovered by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between polls, at which rules are evaluated",
+						Value:   defaultWatchInterval,
+					},
+				),
+				Action: automate,
+			},
+			{
+				Name:  "homekit",
+				Usage: "Bridge kasa devices to Apple HomeKit. Devices are found once at startup. Blocks until killed.",
+				Flags: append(
+					commonFlags,
+					&cli.StringFlag{
+						Name:  "pin",
+						Usage: "8 digit PIN entered in the Home app when pairing. If unset, a random PIN is generated on first run and kept in the storage directory.",
+					},
+					&cli.StringFlag{
+						Name:  "storage",
+						Usage: "Directory in which pairings are stored",
+						Value: defaultHomeKitStorage(),
+					},
+					&cli.StringFlag{
+						Name:  "port",
+						Usage: "Port on which the bridge listens. If unset, a random port is used.",
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Name of the bridge in the Home app",
+						Value: homekit.DefaultName,
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to bridge. If unset, devices are discovered by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between polls",
+						Value:   defaultWatchInterval,
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Timeout of requests to devices",
+						Value: homekit.DefaultTimeout,
+					},
+				),
+				Action: serveHomeKit,
 			},
 			{
 				Name:  "off",
@@ -126,7 +283,7 @@ func main() {
 					Name:     "device",
 					Aliases:  []string{"d"},
 					Required: true,
-					Usage:    "ip:port of Kasa device",
+					Usage:    "ip:port or configured name of Kasa device",
 				}),
 				Action: func(c *cli.Context) error {
 					return setState(c, false)
@@ -139,7 +296,7 @@ func main() {
 					Name:     "device",
 					Aliases:  []string{"d"},
 					Required: true,
-					Usage:    "ip:port of Kasa device",
+					Usage:    "ip:port or configured name of Kasa device",
 				}),
 				Action: func(c *cli.Context) error {
 					return setState(c, true)
@@ -153,7 +310,7 @@ func main() {
 						Name:     "device",
 						Aliases:  []string{"d"},
 						Required: true,
-						Usage:    "ip:port of Kasa device",
+						Usage:    "ip:port or configured name of Kasa device",
 					},
 					&cli.DurationFlag{
 						Name:    "sleep",
@@ -169,17 +326,323 @@ func main() {
 					return setState(c, true)
 				},
 			},
+			{
+				Name:  "watchdog",
+				Usage: "Cycle a kasa device, such as a modem's plug, when every probe of network connectivity fails. Blocks until killed.",
+				Flags: append(commonFlags,
+					&cli.StringFlag{
+						Name:     "device",
+						Aliases:  []string{"d"},
+						Required: true,
+						Usage:    "ip:port or configured name of Kasa device",
+					},
+					&cli.StringSliceFlag{
+						Name:     "probe",
+						Aliases:  []string{"p"},
+						Required: true,
+						Usage:    "tcp://host:port or http(s):// URL probing connectivity. Connectivity is lost when every probe fails.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between probes",
+						Value:   defaultWatchdogInterval,
+					},
+					&cli.IntFlag{
+						Name:  "failures",
+						Usage: "Failed probes in a row after which the device is cycled",
+						Value: watchdog.DefaultFailures,
+					},
+					&cli.DurationFlag{
+						Name:    "sleep",
+						Aliases: []string{"s"},
+						Value:   defaultCycleSleep,
+						Usage:   `Time to wait between setting device "off" and "on"`,
+					},
+					&cli.DurationFlag{
+						Name:  "backoff",
+						Usage: "Time after cycling the device before it may be cycled again, doubling while connectivity is not restored",
+						Value: watchdog.DefaultBackoff,
+					},
+					&cli.IntFlag{
+						Name:  "max-cycles-per-hour",
+						Usage: "Maximum times the device is cycled in an hour. Negative is unlimited.",
+						Value: watchdog.DefaultMaxCyclesPerHour,
+					},
+					&cli.DurationFlag{
+						Name:  "probe-timeout",
+						Usage: "Time after which a probe fails",
+						Value: watchdog.DefaultProbeTimeout,
+					}),
+				Action: runWatchdog,
+			},
 			{
 				Name:  "export",
 				Usage: "Export Kasa metrics to Prometheus. Blocks until killed.",
-				Flags: append(commonFlags, &cli.StringFlag{
-					Name:    "metricsaddress",
-					Aliases: []string{"a"},
-					Value:   defaultPromMetricsAddress,
-					Usage:   "ip:port from which to serve Prometheus metrics",
-				}),
+				Flags: append(commonFlags,
+					&cli.StringFlag{
+						Name:    "metricsaddress",
+						Aliases: []string{"a"},
+						Value:   defaultPromMetricsAddress,
+						Usage:   "ip:port from which to serve Prometheus metrics",
+					},
+					&cli.StringFlag{
+						Name:    "discover",
+						Aliases: []string{"d"},
+						Usage:   "Broadcast ip:port target for discovery requests made by /sd",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.DurationFlag{
+						Name:  "sd-cache-ttl",
+						Usage: "How long /sd caches discovery results",
+						Value: defaultSDCacheTTL,
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Include metrics for every discovered device, labeled by device, in /metrics",
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "With --all, how long /metrics waits for devices to respond",
+						Value: export.DefaultCollectTimeout,
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to include in /metrics with --all, and to poll from startup with --poll-interval. If unset, --all discovers devices by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:  "poll-interval",
+						Usage: "If set, poll /scrape targets in the background on this interval, and serve scrapes from the results",
+					},
+					&cli.DurationFlag{
+						Name:  "stale-after",
+						Usage: "With --poll-interval, stop serving device metrics when the last successful poll is older than this. Defaults to three poll intervals.",
+					},
+					&cli.DurationFlag{
+						Name:  "idle-timeout",
+						Usage: "Forget /scrape targets which have not been scraped for this long. Targets given with --target are never forgotten. Zero disables.",
+						Value: defaultIdleTimeout,
+					},
+					&cli.StringSliceFlag{
+						Name:  "allow",
+						Usage: "ip:port or configured name of a device /scrape may poll, in addition to those given with --target. If unset, any target may be polled.",
+					},
+					&cli.StringFlag{
+						Name:  "push",
+						Usage: "URL of a Prometheus Pushgateway or remote write endpoint to which metrics of targets given with --target or in the config are sent after each poll. Polls every minute unless --poll-interval is set.",
+					},
+					&cli.StringFlag{
+						Name:  "push-protocol",
+						Usage: "Possible values: pushgateway, remote-write",
+						Value: "pushgateway",
+					},
+					&cli.StringFlag{
+						Name:  "push-job",
+						Usage: "Job label of pushed metrics",
+						Value: "kasa",
+					},
+					&cli.StringFlag{
+						Name:  "influxdb",
+						Usage: "InfluxDB write URL to which metrics of targets are sent after each poll, as with --push. For example http://influxdb:8086/write?db=kasa, or udp://influxdb:8089",
+					},
+					&cli.StringFlag{
+						Name:    "influxdb-token",
+						Usage:   "Token with which to authorize InfluxDB writes over HTTP",
+						EnvVars: []string{"INFLUXDB_TOKEN"},
+					},
+					&cli.StringFlag{
+						Name:  "graphite",
+						Usage: "host:port of a Graphite plaintext listener to which metrics of targets are sent after each poll, as with --push",
+					},
+					&cli.StringFlag{
+						Name:  "graphite-prefix",
+						Usage: "Prefix of metric names sent to Graphite",
+					},
+					&cli.StringFlag{
+						Name:  "web-config-file",
+						Usage: "Path to a Prometheus exporter-toolkit web config file enabling TLS and basic auth",
+					},
+					&cli.DurationFlag{
+						Name:  "shutdown-timeout",
+						Usage: "On SIGTERM, how long to wait for outstanding requests before exiting",
+						Value: defaultShutdownTimeout,
+					}),
 				Action: serveExporter,
 			},
+			{
+				Name:  "mqtt",
+				Usage: "Bridge Kasa devices to an MQTT broker, with Home Assistant discovery. Blocks until killed.",
+				Flags: append(commonFlags,
+					&cli.StringFlag{
+						Name:     "broker",
+						Aliases:  []string{"b"},
+						Usage:    "URL of the MQTT broker, for example tcp://localhost:1883",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "client-id",
+						Usage: "MQTT client ID",
+						Value: defaultMQTTClientID,
+					},
+					&cli.StringFlag{
+						Name:    "username",
+						Usage:   "Username with which to connect to the broker",
+						EnvVars: []string{"MQTT_USERNAME"},
+					},
+					&cli.StringFlag{
+						Name:    "password",
+						Usage:   "Password with which to connect to the broker",
+						EnvVars: []string{"MQTT_PASSWORD"},
+					},
+					&cli.StringFlag{
+						Name:  "topic-prefix",
+						Usage: "Prefix of device state and command topics",
+						Value: mqtt.DefaultTopicPrefix,
+					},
+					&cli.StringFlag{
+						Name:  "discovery-prefix",
+						Usage: "Home Assistant discovery prefix. Set to empty to disable discovery.",
+						Value: mqtt.DefaultDiscoveryPrefix,
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to bridge. If unset, devices are discovered by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between polls",
+						Value:   defaultWatchInterval,
+					}),
+				Action: bridgeMQTT,
+			},
+			{
+				Name:  "serve",
+				Usage: "Serve a REST API for listing and controlling Kasa devices. Blocks until killed.",
+				Flags: append(commonFlags,
+					&cli.StringFlag{
+						Name:    "address",
+						Aliases: []string{"a"},
+						Usage:   "ip:port from which to serve the API",
+						Value:   defaultAPIAddress,
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to serve. If unset, devices are discovered by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between discovery polls",
+						Value:   defaultWatchInterval,
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Timeout of requests to devices",
+						Value: api.DefaultTimeout,
+					},
+					&cli.StringFlag{
+						Name:  "web-config-file",
+						Usage: "Path to a Prometheus exporter-toolkit web config file enabling TLS and basic auth",
+					},
+					&cli.DurationFlag{
+						Name:  "shutdown-timeout",
+						Usage: "On SIGTERM, how long to wait for outstanding requests before exiting",
+						Value: defaultShutdownTimeout,
+					}),
+				Action: serveAPI,
+			},
+			{
+				Name:  "grpc-serve",
+				Usage: "Serve the Kasa gRPC service for listing and controlling Kasa devices. Blocks until killed.",
+				Flags: append(commonFlags,
+					&cli.StringFlag{
+						Name:    "address",
+						Aliases: []string{"a"},
+						Usage:   "ip:port from which to serve gRPC",
+						Value:   defaultGRPCAddress,
+					},
+					&cli.StringFlag{
+						Name:    "device",
+						Aliases: []string{"d", "discover"},
+						Usage:   "Broadcast ip:port target for discovery requests",
+						Value:   "255.255.255.255:9999",
+					},
+					&cli.StringSliceFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "ip:port or configured name of a device to serve. If unset, devices are discovered by broadcast.",
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Usage:   "Time between discovery polls, and so the granularity of streamed state changes",
+						Value:   defaultWatchInterval,
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "Timeout of requests to devices",
+						Value: rpc.DefaultTimeout,
+					},
+					&cli.DurationFlag{
+						Name:  "shutdown-timeout",
+						Usage: "On SIGTERM, how long to wait for outstanding calls before exiting",
+						Value: defaultShutdownTimeout,
+					}),
+				Action: serveGRPC,
+			},
+			{
+				Name:  "group",
+				Usage: "Control a group of Kasa devices defined in the config file.",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "on",
+						Usage:     `Set all devices in a group to "on"`,
+						ArgsUsage: "<group>",
+						Flags:     commonFlags,
+						Action: func(c *cli.Context) error {
+							return setGroupState(c, true)
+						},
+					},
+					{
+						Name:      "off",
+						Usage:     `Set all devices in a group to "off"`,
+						ArgsUsage: "<group>",
+						Flags:     commonFlags,
+						Action: func(c *cli.Context) error {
+							return setGroupState(c, false)
+						},
+					},
+				},
+			},
+			{
+				Name:  "scene",
+				Usage: "Apply scenes defined in the config file.",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "apply",
+						Usage:     "Set each device in a scene to its configured state",
+						ArgsUsage: "<scene>",
+						Flags:     commonFlags,
+						Action:    applyScene,
+					},
+				},
+			},
 		},
 	}
 
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/mqtt"
+)
+
+const defaultMQTTClientID = "kasautil"
+
+func bridgeMQTT(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	discover, err := parseDiscover(c, cfg, laddr)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	prefix := c.String("topic-prefix")
+	client, err := mqtt.DialPaho(mqtt.PahoConfig{
+		Broker:            c.String("broker"),
+		ClientID:          c.String("client-id"),
+		Username:          c.String("username"),
+		Password:          c.String("password"),
+		AvailabilityTopic: mqtt.AvailabilityTopic(prefix),
+	})
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	b := mqtt.New(client, discover,
+		mqtt.WithLocalAddr(laddr),
+		mqtt.WithTopicPrefix(prefix),
+		mqtt.WithDiscoveryPrefix(c.String("discovery-prefix")),
+		mqtt.WithErrorHandler(func(err error) {
+			fmt.Fprintf(os.Stderr, "MQTT bridge: %v\n", err)
+		}))
+	if err := b.Run(ctx, c.Duration("interval")); err != nil && err != context.Canceled {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/notify"
+)
+
+// configWebhooks from the config, with configured device names resolved to
+// addresses.
+func configWebhooks(cfg *config) ([]notify.Webhook, error) {
+	hooks := make([]notify.Webhook, 0, len(cfg.Webhooks))
+	for _, h := range cfg.Webhooks {
+		devices := make([]string, len(h.Devices))
+		for i, d := range h.Devices {
+			devices[i] = d
+			if _, has := cfg.Devices[d]; has {
+				daddr, err := cfg.resolve(d)
+				if err != nil {
+					return nil, fmt.Errorf("webhook %v: device %q: %w", h.URL, d, err)
+				}
+				devices[i] = daddr.String()
+			}
+		}
+		h.Devices = devices
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// webhooks from the config and flags.
+func webhooks(c *cli.Context, cfg *config) ([]notify.Webhook, error) {
+	hooks, err := configWebhooks(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range c.StringSlice("webhook") {
+		h := notify.Webhook{
+			URL:        url,
+			Secret:     c.String("secret"),
+			PowerAbove: c.Float64("power-above"),
+		}
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	if len(hooks) == 0 {
+		return nil, errors.New("no webhooks given with --webhook or in the config")
+	}
+	return hooks, nil
+}
+
+func notifyWebhooks(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	discover, err := parseDiscover(c, cfg, laddr)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	hooks, err := webhooks(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	n := notify.New(discover, hooks,
+		notify.WithLocalAddr(laddr),
+		notify.WithOfflineAfter(c.Int("offline-after")),
+		notify.WithErrorHandler(func(err error) {
+			fmt.Fprintf(os.Stderr, "Notifier: %v\n", err)
+		}))
+	if err := n.Run(ctx, c.Duration("interval")); err != nil && !errors.Is(err, context.Canceled) {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
@@ -2,41 +2,108 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"net"
-	"os"
+	"strings"
 
 	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
 	"github.com/urfave/cli/v2"
 )
 
 func parseAddrs(c *cli.Context) (daddr, laddr *net.UDPAddr, err error) {
-	daddr, err = kasa.ParseAddr(c.String("device"))
+	cfg, err := loadConfig(c)
 	if err != nil {
 		return
 	}
-	if l := c.String("local"); l != "" {
-		if laddr, err = kasa.ParseAddr(l); err != nil {
-			return
-		}
+	device := c.String("device")
+	if !c.IsSet("device") && cfg.Broadcast != "" {
+		device = cfg.Broadcast
+	}
+	if daddr, err = cfg.resolve(device); err != nil {
+		return
 	}
+	laddr, err = parseLocal(c, cfg)
 	return
 }
 
+// parseDiscover returns a DiscoverFunc polling the devices given with
+// --target, or if none are, broadcasting to --device.
+func parseDiscover(c *cli.Context, cfg *config, laddr *net.UDPAddr) (inventory.DiscoverFunc, error) {
+	if refs := c.StringSlice("target"); len(refs) > 0 {
+		daddrs := make([]*net.UDPAddr, len(refs))
+		for i, ref := range refs {
+			daddr, err := cfg.resolve(ref)
+			if err != nil {
+				return nil, err
+			}
+			daddrs[i] = daddr
+		}
+		return inventory.Static(daddrs, laddr), nil
+	}
+	device := c.String("device")
+	if !c.IsSet("device") && cfg.Broadcast != "" {
+		device = cfg.Broadcast
+	}
+	baddr, err := cfg.resolve(device)
+	if err != nil {
+		return nil, err
+	}
+	return inventory.Broadcast(baddr, laddr), nil
+}
+
+// parseLocal address from flags, falling back to the config default.
+func parseLocal(c *cli.Context, cfg *config) (*net.UDPAddr, error) {
+	l := c.String("local")
+	if l == "" {
+		l = cfg.Local
+	}
+	if l == "" {
+		return nil, nil
+	}
+	return kasa.ParseAddr(l)
+}
+
 func parseFormatter(c *cli.Context) (formatter, error) {
-	f := c.String("format")
-	switch f {
+	names := defaultColumns
+	if cs := c.String("columns"); cs != "" {
+		names = strings.Split(cs, ",")
+	}
+	cols, err := lookupColumns(names)
+	if err != nil {
+		return human, err
+	}
+	header := !c.Bool("no-header")
+
+	var f formatter
+	switch format := c.String("format"); format {
 	case "promsd":
-		return promFileSD, nil
+		f = promFileSDPerTarget
+		if c.Bool("promsd-single-group") {
+			f = promFileSD
+		}
+	case "httpsd":
+		f = httpSD
+	case "consul":
+		f = consulServices
+	case "zone":
+		f = zone
+	case "json":
+		f = jsonArray
+	case "ndjson":
+		f = ndjson
+	case "csv":
+		f = csvTable(cols, header)
 	case "", "human":
-		return human, nil
+		f = table(cols, header)
+	default:
+		return human, fmt.Errorf("unsupported format %q", format)
 	}
-	return human, fmt.Errorf("unsupported format %q", f)
-}
-
-func parseOutFile(c *cli.Context) (io.Writer, error) {
-	if o := c.String("output"); o != "" {
-		return os.Create(o)
+	if s := c.String("sort"); s != "" {
+		col, has := columns[s]
+		if !has {
+			return human, fmt.Errorf("unsupported sort column %q, possible values: %v", s, strings.Join(columnNames(), ", "))
+		}
+		f = sorted(col, f)
 	}
-	return os.Stdout, nil
+	return f, nil
 }
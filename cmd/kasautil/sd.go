@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+var defaultSDCacheTTL = time.Minute
+
+// sdHandler serves Prometheus HTTP service discovery responses. Discovery is
+// run when a request arrives and the cached response is older than ttl. If
+// discovery fails, the stale response is served until it succeeds.
+type sdHandler struct {
+	discover inventory.DiscoverFunc
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	cached  []byte
+	expires time.Time
+}
+
+func (h *sdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	cached, fresh := h.cached, now().Before(h.expires)
+	h.mu.Unlock()
+	if cached == nil || !fresh {
+		// Discovery takes as long as the broadcast timeout, so it is run without
+		// holding the lock, rather than queueing concurrent requests behind it.
+		infos, err := h.discover(r.Context())
+		switch {
+		case err == nil:
+			var b bytes.Buffer
+			httpSD(&b, infos)
+			cached = b.Bytes()
+			h.mu.Lock()
+			h.cached = cached
+			h.expires = now().Add(h.ttl)
+			h.mu.Unlock()
+		case cached != nil:
+			fmt.Fprintf(os.Stderr, "Failed discovering Kasa devices, serving previous targets: %v\n", err)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Failed discovering Kasa devices: %v", err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(cached)
+}
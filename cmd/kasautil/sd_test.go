@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+func TestSDHandler(t *testing.T) {
+	origNow := now
+	defer func() { now = origNow }()
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+
+	var calls int
+	var fail bool
+	h := &sdHandler{
+		discover: func(context.Context) ([]*kasa.SystemInformation, error) {
+			calls++
+			if fail {
+				return nil, errors.New("oops")
+			}
+			return []*kasa.SystemInformation{
+				{
+					RemoteAddress: &net.UDPAddr{IP: net.ParseIP("10.42.0.10"), Port: 9999},
+					Alias:         "Lamp",
+				},
+			}, nil
+		},
+		ttl: time.Minute,
+	}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sd", nil))
+		return rec
+	}
+
+	want := `[{"targets":["10.42.0.10:9999"],"labels":{"alias":"Lamp"}}]` + "\n"
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Fatalf("ServeHTTP(): got %v %q, want 200 %q", rec.Code, rec.Body.String(), want)
+	}
+	at = at.Add(30 * time.Second)
+	if rec := get(); rec.Body.String() != want || calls != 1 {
+		t.Errorf("ServeHTTP(): want cached response with 1 discovery, got %d discoveries", calls)
+	}
+	at = at.Add(time.Minute)
+	fail = true
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != want || calls != 2 {
+		t.Errorf("ServeHTTP(): want stale response after expiry and failed discovery, got %v %q with %d discoveries", rec.Code, rec.Body.String(), calls)
+	}
+	// Discovery is retried on the next request.
+	fail = false
+	if rec := get(); rec.Code != http.StatusOK || calls != 3 {
+		t.Errorf("ServeHTTP(): want discovery retried, got %v with %d discoveries", rec.Code, calls)
+	}
+}
+
+func TestSDHandlerFailure(t *testing.T) {
+	h := &sdHandler{
+		discover: func(context.Context) ([]*kasa.SystemInformation, error) {
+			return nil, errors.New("oops")
+		},
+		ttl: time.Minute,
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sd", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP(): got %v, want 500 when discovery fails with nothing cached", rec.Code)
+	}
+}
+
+func TestSDHandlerConcurrent(t *testing.T) {
+	// A slow discovery does not block requests served from the cache.
+	release := make(chan struct{})
+	var calls int32
+	h := &sdHandler{
+		discover: func(context.Context) ([]*kasa.SystemInformation, error) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				<-release
+			}
+			return nil, nil
+		},
+		ttl: time.Minute,
+	}
+	get := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sd", nil))
+		return rec.Code
+	}
+	get()
+	h.mu.Lock()
+	h.expires = time.Time{}
+	h.mu.Unlock()
+	done := make(chan int)
+	go func() { done <- get() }()
+	for atomic.LoadInt32(&calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	h.mu.Lock()
+	h.expires = now().Add(time.Minute)
+	h.mu.Unlock()
+	if code := get(); code != http.StatusOK {
+		t.Errorf("ServeHTTP(): got %v during a slow refresh, want 200", code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("ServeHTTP(): got %v after a slow refresh, want 200", code)
+	}
+}
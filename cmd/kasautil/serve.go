@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/api"
+)
+
+var defaultAPIAddress = ":8080"
+
+func serveAPI(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	laddr, err := parseLocal(c, cfg)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	discover, err := parseDiscover(c, cfg, laddr)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	s := api.New(discover, api.WithLocalAddr(laddr), api.WithTimeout(c.Duration("timeout")))
+	go func() {
+		err := s.Run(ctx, c.Duration("interval"), func(err error) {
+			fmt.Fprintf(os.Stderr, "Failed polling Kasa devices: %v\n", err)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "Polling stopped: %v\n", err)
+		}
+	}()
+	l, err := net.Listen("tcp", c.String("address"))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return serveHTTP(ctx, l, s, c.String("web-config-file"), c.Duration("shutdown-timeout"))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/export"
+)
+
+var defaultPushInterval = time.Minute
+
+// pushSink for the --push flags.
+func pushSink(protocol, u, job string) (export.Sink, error) {
+	switch protocol {
+	case "pushgateway":
+		return &export.Pushgateway{URL: u, Job: job}, nil
+	case "remote-write":
+		return &export.RemoteWrite{URL: u, Job: job}, nil
+	}
+	return nil, cli.Exit(fmt.Sprintf("unknown push protocol %q, possible values: pushgateway, remote-write", protocol), 1)
+}
+
+// influxSink for the --influxdb flags.
+func influxSink(u, token string) (export.Sink, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("bad InfluxDB URL %q: %v", u, err), 1)
+	}
+	switch pu.Scheme {
+	case "http", "https", "udp":
+		return &export.InfluxDB{URL: u, Token: token}, nil
+	}
+	return nil, cli.Exit(fmt.Sprintf("bad InfluxDB URL %q: scheme must be http, https or udp", u), 1)
+}
+
+// parseSinks to which the exporter writes the metrics of its targets after
+// each poll, selected by flags.
+func parseSinks(c *cli.Context) ([]export.Option, error) {
+	var opts []export.Option
+	if u := c.String("push"); u != "" {
+		sink, err := pushSink(c.String("push-protocol"), u, c.String("push-job"))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, export.WithSink(c.String("push-protocol"), sink))
+	}
+	if u := c.String("influxdb"); u != "" {
+		sink, err := influxSink(u, c.String("influxdb-token"))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, export.WithSink("influxdb", sink))
+	}
+	if addr := c.String("graphite"); addr != "" {
+		opts = append(opts, export.WithSink("graphite", &export.Graphite{
+			Address: addr,
+			Prefix:  c.String("graphite-prefix"),
+		}))
+	}
+	return opts, nil
+}
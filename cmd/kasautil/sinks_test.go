@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestInfluxSink(t *testing.T) {
+	for u, wantErr := range map[string]bool{
+		"http://influxdb:8086/write?db=kasa": false,
+		"https://influxdb/api/v2/write":      false,
+		"udp://influxdb:8089":                false,
+		"influxdb:8086":                      true,
+		"tcp://influxdb:8086":                true,
+	} {
+		if _, err := influxSink(u, ""); (err != nil) != wantErr {
+			t.Errorf("influxSink(%q): got error %v, want error: %v", u, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+func watch(c *cli.Context) error {
+	baddr, laddr, err := parseAddrs(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	var emit func(inventory.Event)
+	switch f := c.String("format"); f {
+	case "", "text":
+		emit = func(e inventory.Event) {
+			fmt.Fprintln(c.App.Writer, e)
+		}
+	case "json":
+		enc := json.NewEncoder(c.App.Writer)
+		emit = func(e inventory.Event) {
+			if err := enc.Encode(&e); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed encoding event: %v\n", err)
+			}
+		}
+	default:
+		return cli.Exit(fmt.Sprintf("unsupported format %q", f), 1)
+	}
+	inv := inventory.New(inventory.Broadcast(baddr, laddr), inventory.DiffOptions{
+		RSSIDrop:    c.Int("rssi-drop"),
+		MissedPolls: c.Int("missed-polls"),
+	})
+	return inv.Run(c.Context, c.Duration("interval"), func(events []inventory.Event, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed polling Kasa devices: %v\n", err)
+			return
+		}
+		for _, e := range events {
+			emit(e)
+		}
+	})
+}
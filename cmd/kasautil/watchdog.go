@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/cfunkhouser/kasa/watchdog"
+)
+
+func runWatchdog(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	daddr, laddr, err := parseAddrs(c)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	var probes []watchdog.Probe
+	for _, s := range c.StringSlice("probe") {
+		p, err := watchdog.ParseProbe(s)
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+		probes = append(probes, p)
+	}
+	w := watchdog.New(daddr, probes,
+		watchdog.WithLocalAddr(laddr),
+		watchdog.WithFailures(c.Int("failures")),
+		watchdog.WithCycleOff(c.Duration("sleep")),
+		watchdog.WithBackoff(c.Duration("backoff")),
+		watchdog.WithMaxCyclesPerHour(c.Int("max-cycles-per-hour")),
+		watchdog.WithProbeTimeout(c.Duration("probe-timeout")),
+		watchdog.WithEventHandler(func(e watchdog.Event) {
+			fmt.Fprintln(c.App.Writer, e)
+		}))
+	if err := w.Run(ctx, c.Duration("interval")); err != nil && !errors.Is(err, context.Canceled) {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+var defaultShutdownTimeout = time.Second * 10
+
+// serveHTTP serves handler on l until ctx is done, then waits up to
+// shutdownTimeout for outstanding requests to finish. If webConfig is set, it
+// is the path to a Prometheus exporter-toolkit web config file enabling TLS and
+// basic auth. See
+// https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+func serveHTTP(ctx context.Context, l net.Listener, handler http.Handler, webConfig string, shutdownTimeout time.Duration) error {
+	if webConfig != "" {
+		if err := web.Validate(webConfig); err != nil {
+			return err
+		}
+	}
+	srv := &http.Server{Handler: handler}
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	errc := make(chan error, 1)
+	go func() {
+		errc <- web.Serve(l, srv, webConfig, logger)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP(t *testing.T) {
+	// hunter2, hashed with bcrypt.
+	webConfig := writeTestConfig(t, `basic_auth_users:
+  prometheus: $2a$04$ef6t72CN7NfOKK.k11NtAOIzap1DtBHjCiBLtsii95ZaKnSg.L5c.
+`)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serveHTTP(ctx, l, http.HandlerFunc(healthz), webConfig, time.Second)
+	}()
+
+	url := "http://" + l.Addr().String() + "/healthz"
+	for tn, tc := range map[string]struct {
+		user, password string
+		want           int
+	}{
+		"no credentials": {
+			want: http.StatusUnauthorized,
+		},
+		"wrong password": {
+			user:     "prometheus",
+			password: "hunter3",
+			want:     http.StatusUnauthorized,
+		},
+		"valid credentials": {
+			user:     "prometheus",
+			password: "hunter2",
+			want:     http.StatusOK,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.user != "" {
+				req.SetBasicAuth(tc.user, tc.password)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("GET /healthz: got status %v, want %v", resp.StatusCode, tc.want)
+			}
+		})
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serveHTTP(): got error after shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveHTTP(): did not return after context was canceled")
+	}
+}
+
+func TestServeHTTPInvalidWebConfig(t *testing.T) {
+	webConfig := writeTestConfig(t, "tls_server_config:\n  cert_file: missing.crt\n")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := serveHTTP(context.Background(), l, http.HandlerFunc(healthz), webConfig, time.Second); err == nil {
+		t.Error("serveHTTP(): want error for invalid web config, got nil")
+	}
+}
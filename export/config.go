@@ -0,0 +1,182 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Collectors which a Module may enable.
+const (
+	// CollectSysinfo exports relay state, on time, RSSI and device info.
+	CollectSysinfo = "sysinfo"
+	// CollectEmeter exports power, voltage, current and energy readings from
+	// devices with an energy meter.
+	CollectEmeter = "emeter"
+	// CollectChildren exports the state of each outlet of a power strip.
+	CollectChildren = "children"
+	// CollectLight exports the state of smart bulbs.
+	CollectLight = "light"
+)
+
+var knownCollectors = map[string]bool{
+	CollectSysinfo:  true,
+	CollectEmeter:   true,
+	CollectChildren: true,
+	CollectLight:    true,
+}
+
+// Module selects what is collected from a device, similar to modules of the
+// Prometheus blackbox exporter. Scrapes select a module with the module query
+// parameter.
+type Module struct {
+	// Collect lists the collectors to enable.
+	Collect []string `yaml:"collect"`
+	// Timeout for polling the device. If zero, the device is polled until it
+	// has not responded for a second.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retries of a poll to which the device did not respond. UDP requests and
+	// responses may be lost, particularly over Wi-Fi.
+	Retries int `yaml:"retries,omitempty"`
+}
+
+func (m Module) collects(c string) bool {
+	for _, mc := range m.Collect {
+		if mc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultModule is used when a scrape names no module, and the configuration
+// has no module named "default".
+var DefaultModule = Module{Collect: []string{CollectSysinfo}}
+
+// Target is a device with settings which apply whenever it is scraped.
+type Target struct {
+	// Address of the device, as ip:port.
+	Address string `yaml:"address"`
+	// Module used when a scrape of this target names no module.
+	Module string `yaml:"module,omitempty"`
+	// Timeout for polling the device, overriding that of the module.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Labels added to every metric exported for the device.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// Config for a Handler. For example:
+//
+//	modules:
+//	  default:
+//	    collect: [sysinfo]
+//	  power:
+//	    collect: [sysinfo, emeter]
+//	    timeout: 2s
+//	targets:
+//	  - address: 10.24.6.14:9999
+//	    module: power
+//	    labels:
+//	      room: office
+//	restrict_targets: true
+type Config struct {
+	Modules map[string]Module `yaml:"modules,omitempty"`
+	Targets []Target          `yaml:"targets,omitempty"`
+	// RestrictTargets to those listed, so that scrapes can not be used to
+	// probe arbitrary addresses.
+	RestrictTargets bool `yaml:"restrict_targets,omitempty"`
+}
+
+var (
+	ErrInvalidConfig = errors.New("invalid exporter config")
+	ErrUnknownModule = errors.New("unknown module")
+)
+
+// Validate the configuration.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for name, m := range c.Modules {
+		if m.Retries < 0 {
+			return fmt.Errorf("%w: module %q: negative retries", ErrInvalidConfig, name)
+		}
+		for _, col := range m.Collect {
+			if !knownCollectors[col] {
+				return fmt.Errorf("%w: module %q: unknown collector %q, possible values: %v", ErrInvalidConfig, name, col, collectorNames())
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for _, t := range c.Targets {
+		_, key, err := normalizeTarget(t.Address)
+		if err != nil {
+			return fmt.Errorf("%w: target %q: %v", ErrInvalidConfig, t.Address, err)
+		}
+		if seen[key] {
+			return fmt.Errorf("%w: target %q listed more than once", ErrInvalidConfig, t.Address)
+		}
+		seen[key] = true
+		if _, has := c.Modules[t.Module]; t.Module != "" && t.Module != "default" && !has {
+			return fmt.Errorf("%w: target %q: %v %q", ErrInvalidConfig, t.Address, ErrUnknownModule, t.Module)
+		}
+		for ln := range t.Labels {
+			if !model.LabelName(ln).IsValid() || strings.HasPrefix(ln, "__") {
+				return fmt.Errorf("%w: target %q: invalid label name %q", ErrInvalidConfig, t.Address, ln)
+			}
+		}
+	}
+	return nil
+}
+
+func collectorNames() string {
+	var names []string
+	for n := range knownCollectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// target settings for the normalized address, if it is listed.
+func (c *Config) target(key string) (Target, bool) {
+	if c == nil {
+		return Target{}, false
+	}
+	for _, t := range c.Targets {
+		if _, tk, err := normalizeTarget(t.Address); err == nil && tk == key {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// module named by a scrape of the target with the normalized address. If name
+// is empty, the target's module is used, then the module named "default",
+// then DefaultModule.
+func (c *Config) module(key, name string) (string, Module, error) {
+	if name == "" {
+		if t, has := c.target(key); has {
+			name = t.Module
+		}
+	}
+	var modules map[string]Module
+	if c != nil {
+		modules = c.Modules
+	}
+	if name == "" || name == "default" {
+		if m, has := modules["default"]; has {
+			return "default", m, nil
+		}
+		return "default", DefaultModule, nil
+	}
+	m, has := modules[name]
+	if !has {
+		return "", Module{}, fmt.Errorf("%w: %q", ErrUnknownModule, name)
+	}
+	return name, m, nil
+}
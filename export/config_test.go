@@ -0,0 +1,199 @@
+package export
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func TestConfigValidate(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		cfg     *Config
+		wantErr bool
+	}{
+		"nil": {},
+		"valid": {
+			cfg: &Config{
+				Modules: map[string]Module{
+					"power": {Collect: []string{CollectSysinfo, CollectEmeter}},
+				},
+				Targets: []Target{
+					{Address: "10.24.6.14:9999", Module: "power", Labels: map[string]string{"room": "office"}},
+					{Address: "10.24.6.15:9999", Module: "default"},
+				},
+			},
+		},
+		"unknown collector": {
+			cfg: &Config{
+				Modules: map[string]Module{"power": {Collect: []string{"volts"}}},
+			},
+			wantErr: true,
+		},
+		"bad address": {
+			cfg:     &Config{Targets: []Target{{Address: "modem"}}},
+			wantErr: true,
+		},
+		"duplicate target": {
+			cfg: &Config{Targets: []Target{
+				{Address: "10.24.6.14:9999"},
+				{Address: "10.24.6.14"},
+			}},
+			wantErr: true,
+		},
+		"unknown module": {
+			cfg:     &Config{Targets: []Target{{Address: "10.24.6.14:9999", Module: "power"}}},
+			wantErr: true,
+		},
+		"invalid label": {
+			cfg: &Config{Targets: []Target{
+				{Address: "10.24.6.14:9999", Labels: map[string]string{"__name__": "x"}},
+			}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Validate(): got error %v, want error: %v", err, tc.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Validate(): %v is not ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestConfigModule(t *testing.T) {
+	power := Module{Collect: []string{CollectEmeter}, Timeout: time.Second}
+	cfg := &Config{
+		Modules: map[string]Module{"power": power},
+		Targets: []Target{{Address: "10.24.6.14:9999", Module: "power"}},
+	}
+	for tn, tc := range map[string]struct {
+		cfg     *Config
+		key     string
+		name    string
+		want    Module
+		wantErr bool
+	}{
+		"nil config": {
+			key:  "10.24.6.14:9999",
+			want: DefaultModule,
+		},
+		"target module": {
+			cfg:  cfg,
+			key:  "10.24.6.14:9999",
+			want: power,
+		},
+		"unlisted target": {
+			cfg:  cfg,
+			key:  "10.24.6.15:9999",
+			want: DefaultModule,
+		},
+		"named": {
+			cfg:  cfg,
+			key:  "10.24.6.15:9999",
+			name: "power",
+			want: power,
+		},
+		"unknown": {
+			cfg:     cfg,
+			key:     "10.24.6.14:9999",
+			name:    "light",
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			_, got, err := tc.cfg.module(tc.key, tc.name)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("module(): got error %v, want error: %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("module(): mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestHandlerModules(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":       "Dryer",
+		"deviceId":    "dryer",
+		"relay_state": 1,
+	})
+	d.SetEmeter(kasatest.Emeter{Current: 1.5, Voltage: 120, Power: 180, Total: 2500})
+	h := New()
+	if err := h.ApplyConfig(&Config{
+		Modules: map[string]Module{
+			"power": {Collect: []string{CollectSysinfo, CollectEmeter}},
+		},
+		Targets: []Target{
+			{Address: d.Addr(), Labels: map[string]string{"room": "laundry"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := scrape(t, h, d.Addr())
+	if want := `kasa_relay_state{room="laundry"} 1` + "\n"; !strings.Contains(got, want) {
+		t.Errorf("scrape: want %q in:\n%v", want, got)
+	}
+	if strings.Contains(got, "kasa_power_watts") {
+		t.Errorf("scrape: want no emeter metrics without power module in:\n%v", got)
+	}
+
+	got = scrape(t, h, d.Addr()+"&module=power")
+	for _, want := range []string{
+		`kasa_power_watts{room="laundry"} 180` + "\n",
+		`kasa_voltage_volts{room="laundry"} 120` + "\n",
+		`kasa_current_amperes{room="laundry"} 1.5` + "\n",
+		`kasa_energy_watt_hours{room="laundry"} 2500` + "\n",
+		`kasa_up{room="laundry"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("power scrape: want %q in:\n%v", want, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scrape?target="+d.Addr()+"&module=light", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown module: got status %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerNoEmeter(t *testing.T) {
+	d := kasatest.Start(t, kasatest.WithRelay(true))
+	h := New()
+	if err := h.ApplyConfig(&Config{
+		Modules: map[string]Module{
+			"default": {Collect: []string{CollectSysinfo, CollectEmeter}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The device reports the whole emeter module as unsupported, as plugs
+	// without an energy meter such as the HS100 do.
+	got := scrape(t, h, d.Addr())
+	for _, want := range []string{"kasa_up 1\n", "kasa_relay_state 1\n", `kasa_poll_errors_total{reason="decode"} 0` + "\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("scrape: want %q in:\n%v", want, got)
+		}
+	}
+	if strings.Contains(got, "kasa_power_watts") {
+		t.Errorf("scrape: want no emeter metrics for a device without an emeter in:\n%v", got)
+	}
+
+	// Readings are exported once the device reports them.
+	d.SetEmeter(kasatest.Emeter{Power: 60})
+	if got := scrape(t, h, d.Addr()); !strings.Contains(got, "kasa_power_watts 60\n") {
+		t.Errorf("scrape: want power readings in:\n%v", got)
+	}
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+// Wi-Fi signal quality buckets, from best to worst, and the weakest RSSI in
+// dBm which falls into each.
+var wifiQualities = []struct {
+	name    string
+	minRSSI int
+}{
+	{"excellent", -50},
+	{"good", -60},
+	{"fair", -70},
+	{"poor", -200},
+}
+
+// wifiQuality buckets an RSSI reading.
+func wifiQuality(rssi int) string {
+	for _, q := range wifiQualities {
+		if rssi >= q.minRSSI {
+			return q.name
+		}
+	}
+	return wifiQualities[len(wifiQualities)-1].name
+}
+
+// clockSlack is how far a device clock may go backwards between polls, for
+// example when corrected by NTP, before the device is considered rebooted.
+const clockSlack = time.Minute
+
+// derivedState remembers what a device reported at the previous successful
+// poll, so that events between polls can be inferred.
+type derivedState struct {
+	seen       bool
+	relayState int
+	onTime     int
+	clock      time.Time
+}
+
+// observe the device's sysinfo and, if it supports the time module, its clock.
+// Returns the number of relay changes inferred since the previous poll, and
+// whether the device rebooted.
+//
+// A relay which is on at both polls, but has been on for less time than at the
+// previous poll, was either turned off and on again or the device rebooted. If
+// the device clock went backwards, the device rebooted and its clock has not
+// yet been set by NTP. If it did not, the relay was switched. Without a clock,
+// the device is assumed to have rebooted.
+func (s *derivedState) observe(info *kasa.SystemInformation, clock *time.Time) (changes int, rebooted bool) {
+	defer func() {
+		s.seen = true
+		s.relayState = info.RelayState
+		s.onTime = info.OnTime
+		s.clock = time.Time{}
+		if clock != nil {
+			s.clock = *clock
+		}
+	}()
+	if !s.seen {
+		return 0, false
+	}
+	clockRegressed := clock != nil && !s.clock.IsZero() && clock.Before(s.clock.Add(-clockSlack))
+	if info.RelayState != s.relayState {
+		return 1, clockRegressed
+	}
+	if info.RelayState != 0 && info.OnTime < s.onTime {
+		if clock != nil && !s.clock.IsZero() && !clockRegressed {
+			return 2, false
+		}
+		return 0, true
+	}
+	return 0, clockRegressed
+}
@@ -0,0 +1,108 @@
+package export
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+func TestWifiQuality(t *testing.T) {
+	for rssi, want := range map[int]string{
+		-42:  "excellent",
+		-50:  "excellent",
+		-51:  "good",
+		-65:  "fair",
+		-71:  "poor",
+		-120: "poor",
+	} {
+		if got := wifiQuality(rssi); got != want {
+			t.Errorf("wifiQuality(%v): got %q, want %q", rssi, got, want)
+		}
+	}
+}
+
+func TestDerivedStateObserve(t *testing.T) {
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	later := at.Add(time.Minute)
+	reset := time.Date(2016, time.January, 1, 0, 0, 30, 0, time.UTC)
+	type poll struct {
+		relay  int
+		onTime int
+		clock  *time.Time
+	}
+	for tn, tc := range map[string]struct {
+		prev, cur    poll
+		wantChanges  int
+		wantRebooted bool
+	}{
+		"unchanged": {
+			prev: poll{relay: 1, onTime: 100, clock: &at},
+			cur:  poll{relay: 1, onTime: 160, clock: &later},
+		},
+		"turned off": {
+			prev:        poll{relay: 1, onTime: 100, clock: &at},
+			cur:         poll{relay: 0, clock: &later},
+			wantChanges: 1,
+		},
+		"cycled between polls": {
+			prev:        poll{relay: 1, onTime: 100, clock: &at},
+			cur:         poll{relay: 1, onTime: 20, clock: &later},
+			wantChanges: 2,
+		},
+		"rebooted with clock reset": {
+			prev:         poll{relay: 1, onTime: 100, clock: &at},
+			cur:          poll{relay: 1, onTime: 20, clock: &reset},
+			wantRebooted: true,
+		},
+		"rebooted with relay off": {
+			prev:         poll{clock: &at},
+			cur:          poll{clock: &reset},
+			wantRebooted: true,
+		},
+		"rebooted without clock": {
+			prev:         poll{relay: 1, onTime: 100},
+			cur:          poll{relay: 1, onTime: 20},
+			wantRebooted: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			var s derivedState
+			if changes, rebooted := s.observe(&kasa.SystemInformation{RelayState: tc.prev.relay, OnTime: tc.prev.onTime}, tc.prev.clock); changes != 0 || rebooted {
+				t.Errorf("first observe(): got (%v, %v), want (0, false)", changes, rebooted)
+			}
+			changes, rebooted := s.observe(&kasa.SystemInformation{RelayState: tc.cur.relay, OnTime: tc.cur.onTime}, tc.cur.clock)
+			if changes != tc.wantChanges || rebooted != tc.wantRebooted {
+				t.Errorf("observe(): got (%v, %v), want (%v, %v)", changes, rebooted, tc.wantChanges, tc.wantRebooted)
+			}
+		})
+	}
+}
+
+func TestHandlerDerivedMetrics(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"relay_state": 1,
+		"on_time":     3600,
+		"rssi":        -63,
+	})
+	h := New()
+	scrape(t, h, d.Addr())
+	d.Update(func(s map[string]interface{}) {
+		s["relay_state"] = 0
+		s["on_time"] = 0
+		s["rssi"] = -48
+	})
+	got := scrape(t, h, d.Addr())
+	for _, want := range []string{
+		"kasa_relay_changes_total 1\n",
+		"kasa_device_reboots_total 0\n",
+		"kasa_relay_on_seconds 0\n",
+		`kasa_wifi_signal_quality{quality="excellent"} 1` + "\n",
+		`kasa_wifi_signal_quality{quality="fair"} 0` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("scrape: want %q in:\n%v", want, got)
+		}
+	}
+}
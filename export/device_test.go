@@ -0,0 +1,13 @@
+package export
+
+import (
+	"testing"
+
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+// newFakeDevice answering with exactly the system information.
+func newFakeDevice(t *testing.T, sysinfo map[string]interface{}) *kasatest.Device {
+	t.Helper()
+	return kasatest.Start(t, kasatest.WithSysinfo(sysinfo))
+}
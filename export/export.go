@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/cfunkhouser/kasa"
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,94 +19,358 @@ type deviceMetrics struct {
 	relayState prometheus.Gauge
 	rssi       prometheus.Gauge
 	info       *prometheus.GaugeVec
+
+	relayOnSeconds prometheus.Gauge
+	relayChanges   prometheus.Counter
+	reboots        prometheus.Counter
+	wifiQuality    *prometheus.GaugeVec
+
+	// Emeter readings have no labels, and are reset for devices without an
+	// energy meter rather than being reported as zero.
+	power   *prometheus.GaugeVec
+	voltage *prometheus.GaugeVec
+	current *prometheus.GaugeVec
+	energy  *prometheus.GaugeVec
+
+	childRelayState *prometheus.GaugeVec
+	childOnTime     *prometheus.GaugeVec
+
+	lightOn         prometheus.Gauge
+	lightBrightness prometheus.Gauge
+	lightHue        prometheus.Gauge
+	lightSaturation prometheus.Gauge
+	lightColorTemp  prometheus.Gauge
 }
 
-func (m *deviceMetrics) register(r prometheus.Registerer) error {
-	if err := r.Register(m.onTime); err != nil {
-		return err
+func newDeviceMetrics() deviceMetrics {
+	return deviceMetrics{
+		onTime: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_on_time",
+				Help: "Amount of time a Kasa device has been on. Deprecated: use kasa_relay_on_seconds.",
+			},
+		),
+		relayState: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_relay_state",
+				Help: "State of the relay for a given Kasa device.",
+			},
+		),
+		rssi: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_rssi",
+				Help: "RSSI of the Kasa device radio.",
+			},
+		),
+		info: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_device_info",
+				Help: "Information describing the Kasa device.",
+			},
+			[]string{"alias", "id", "name", "model", "sw"},
+		),
+		relayOnSeconds: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_relay_on_seconds",
+				Help: "Time since the relay of the Kasa device was turned on, or zero if it is off.",
+			},
+		),
+		relayChanges: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "kasa_relay_changes_total",
+				Help: "Number of times the relay of the Kasa device has been switched, inferred between polls.",
+			},
+		),
+		reboots: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "kasa_device_reboots_total",
+				Help: "Number of times the Kasa device has rebooted, inferred between polls.",
+			},
+		),
+		wifiQuality: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_wifi_signal_quality",
+				Help: "Quality of the Kasa device Wi-Fi signal, bucketed from RSSI. The current quality is 1, others are 0.",
+			},
+			[]string{"quality"},
+		),
+		power: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_power_watts",
+				Help: "Power drawn through the Kasa device.",
+			},
+			nil,
+		),
+		voltage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_voltage_volts",
+				Help: "Supply voltage measured by the Kasa device.",
+			},
+			nil,
+		),
+		current: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_current_amperes",
+				Help: "Current drawn through the Kasa device.",
+			},
+			nil,
+		),
+		energy: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_energy_watt_hours",
+				Help: "Energy used through the Kasa device since its meter was reset.",
+			},
+			nil,
+		),
+		childRelayState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_child_relay_state",
+				Help: "State of the relay for an outlet of a Kasa power strip.",
+			},
+			[]string{"child_id", "child_alias"},
+		),
+		childOnTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kasa_child_on_time",
+				Help: "Amount of time an outlet of a Kasa power strip has been on.",
+			},
+			[]string{"child_id", "child_alias"},
+		),
+		lightOn: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_light_on",
+				Help: "Whether a Kasa smart bulb is lit.",
+			},
+		),
+		lightBrightness: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_light_brightness_percent",
+				Help: "Brightness of a Kasa smart bulb.",
+			},
+		),
+		lightHue: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_light_hue_degrees",
+				Help: "Hue of a Kasa smart bulb.",
+			},
+		),
+		lightSaturation: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_light_saturation_percent",
+				Help: "Saturation of a Kasa smart bulb.",
+			},
+		),
+		lightColorTemp: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_light_color_temp_kelvin",
+				Help: "Color temperature of a Kasa smart bulb.",
+			},
+		),
 	}
-	if err := r.Register(m.relayState); err != nil {
-		return err
+}
+
+func (m *deviceMetrics) register(r prometheus.Registerer, module Module) error {
+	var cs []prometheus.Collector
+	if module.collects(CollectSysinfo) {
+		cs = append(cs, m.onTime, m.relayState, m.rssi, m.info,
+			m.relayOnSeconds, m.relayChanges, m.reboots, m.wifiQuality)
 	}
-	if err := r.Register(m.rssi); err != nil {
-		return err
+	if module.collects(CollectEmeter) {
+		cs = append(cs, m.power, m.voltage, m.current, m.energy)
 	}
-	if err := r.Register(m.info); err != nil {
-		return err
+	if module.collects(CollectChildren) {
+		cs = append(cs, m.childRelayState, m.childOnTime)
+	}
+	if module.collects(CollectLight) {
+		cs = append(cs, m.lightOn, m.lightBrightness, m.lightHue, m.lightSaturation, m.lightColorTemp)
+	}
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func setIfReported(g prometheus.Gauge, v *int) {
+	if v != nil {
+		g.Set(float64(*v))
+	}
+}
+
 type deviceExporter struct {
-	daddr    *net.UDPAddr
-	metrics  deviceMetrics
-	registry *prometheus.Registry
+	daddr      *net.UDPAddr
+	target     string
+	moduleName string
+	module     Module
+	timeout    time.Duration
+	metrics    deviceMetrics
+	registry   *prometheus.Registry
+	instr      *handlerMetrics
+
+	// lastUsed is protected by the Handler's lock.
+	lastUsed time.Time
+
+	pollMu         sync.Mutex
+	infoLabels     prometheus.Labels
+	derived        derivedState
+	polled         bool
+	up             bool
+	lastPoll       time.Time
+	status         deviceStatus
+	statusRegistry *prometheus.Registry
 }
 
 var (
 	ErrTooManyResponses = errors.New("got multiple responses for address")
-	ErrNoDeviceResponse = errors.New("no response from device")
+	// ErrNoDeviceResponse is kasa.ErrNoResponse, kept for existing callers.
+	ErrNoDeviceResponse = kasa.ErrNoResponse
 )
 
+func equalLabels(a, b prometheus.Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, has := b[k]; !has || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (e *deviceExporter) update(ctx context.Context, laddr *net.UDPAddr) error {
-	infos, err := kasa.GetSystemInformation(ctx, e.daddr, laddr, true)
+	message := &kasa.APIMessage{
+		System: map[string]interface{}{
+			"get_sysinfo": nil,
+		},
+		// The device clock is used to tell reboots from relay changes.
+		Time: map[string]interface{}{
+			"get_time": nil,
+		},
+	}
+	if e.module.collects(CollectEmeter) {
+		message.Emeter = map[string]interface{}{
+			"get_realtime": nil,
+		}
+	}
+	replies, err := kasa.Send(ctx, message, e.daddr, laddr, true)
 	if err != nil {
 		return err
 	}
-	if len(infos) > 1 {
+	if len(replies) > 1 {
 		return fmt.Errorf("%w: %v", ErrTooManyResponses, e.daddr)
 	}
-	if len(infos) == 0 {
-		return fmt.Errorf("%w: %v", ErrNoDeviceResponse, e.daddr)
+	if len(replies) == 0 {
+		return fmt.Errorf("%w from %v", kasa.ErrNoResponse, e.daddr)
 	}
-	info := infos[0]
+	var info kasa.SystemInformation
+	if err := info.FromAPIMessage(replies[0]); err != nil {
+		return err
+	}
+	if err := info.Err(); err != nil {
+		return err
+	}
+	// Devices without an energy meter report an error for get_realtime, or
+	// for the whole emeter module, which is not a failed poll.
+	var emeter *kasa.EmeterRealtime
+	if e.module.collects(CollectEmeter) {
+		var em kasa.EmeterRealtime
+		if err := em.FromAPIMessage(replies[0]); err != nil {
+			return err
+		}
+		if em.Err() == nil {
+			emeter = &em
+		}
+	}
+
+	// Devices which do not support the time module report an error for it,
+	// which is not a failed poll.
+	var clock *time.Time
+	var dt kasa.DeviceTime
+	if err := dt.FromAPIMessage(replies[0]); err == nil && dt.Err() == nil {
+		t := dt.Time()
+		clock = &t
+	}
+
 	e.metrics.onTime.Set(float64(info.OnTime))
+	e.metrics.relayOnSeconds.Set(float64(info.OnTime))
 	e.metrics.relayState.Set(float64(info.RelayState))
 	e.metrics.rssi.Set(float64(info.RSSI))
-	e.metrics.info.With(prometheus.Labels{
+	quality := wifiQuality(info.RSSI)
+	for _, q := range wifiQualities {
+		v := 0.0
+		if q.name == quality {
+			v = 1
+		}
+		e.metrics.wifiQuality.WithLabelValues(q.name).Set(v)
+	}
+	changes, rebooted := e.derived.observe(&info, clock)
+	e.metrics.relayChanges.Add(float64(changes))
+	if rebooted {
+		e.metrics.reboots.Inc()
+	}
+	labels := prometheus.Labels{
 		"alias": info.Alias,
 		"id":    info.DeviceID,
 		"name":  info.DevName,
 		"model": info.Model,
 		"sw":    info.SoftwareVersion,
-	}).Set(1.0)
+	}
+	if !equalLabels(labels, e.infoLabels) {
+		// Drop the series describing the device before it was renamed,
+		// upgraded, etc.
+		e.metrics.info.Reset()
+		e.infoLabels = labels
+	}
+	e.metrics.info.With(labels).Set(1.0)
+
+	if emeter != nil {
+		e.metrics.power.WithLabelValues().Set(emeter.Power)
+		e.metrics.voltage.WithLabelValues().Set(emeter.Voltage)
+		e.metrics.current.WithLabelValues().Set(emeter.Current)
+		e.metrics.energy.WithLabelValues().Set(emeter.Total)
+	} else {
+		e.metrics.power.Reset()
+		e.metrics.voltage.Reset()
+		e.metrics.current.Reset()
+		e.metrics.energy.Reset()
+	}
+
+	e.metrics.childRelayState.Reset()
+	e.metrics.childOnTime.Reset()
+	for _, child := range info.Children {
+		e.metrics.childRelayState.WithLabelValues(child.ID, child.Alias).Set(float64(child.State))
+		e.metrics.childOnTime.WithLabelValues(child.ID, child.Alias).Set(float64(child.OnTime))
+	}
+
+	if ls := info.LightState; ls != nil {
+		setIfReported(e.metrics.lightOn, ls.OnOff)
+		setIfReported(e.metrics.lightBrightness, ls.Brightness)
+		setIfReported(e.metrics.lightHue, ls.Hue)
+		setIfReported(e.metrics.lightSaturation, ls.Saturation)
+		setIfReported(e.metrics.lightColorTemp, ls.ColorTemp)
+	}
 	return nil
 }
 
-func newDeviceExporter(daddr *net.UDPAddr) (*deviceExporter, error) {
+// newDeviceExporter for the device at daddr, collecting metrics enabled by the
+// module. Labels are added to every metric exported.
+func newDeviceExporter(daddr *net.UDPAddr, module Module, timeout time.Duration, labels prometheus.Labels) (*deviceExporter, error) {
 	de := &deviceExporter{
-		daddr: daddr,
-		metrics: deviceMetrics{
-			onTime: prometheus.NewGauge(
-				prometheus.GaugeOpts{
-					Name: "kasa_on_time",
-					Help: "Amount of time a Kasa device has been on.",
-				},
-			),
-			relayState: prometheus.NewGauge(
-				prometheus.GaugeOpts{
-					Name: "kasa_relay_state",
-					Help: "State of the relay for a given Kasa device.",
-				},
-			),
-			rssi: prometheus.NewGauge(
-				prometheus.GaugeOpts{
-					Name: "kasa_rssi",
-					Help: "RSSI of the Kasa device radio.",
-				},
-			),
-			info: prometheus.NewGaugeVec(
-				prometheus.GaugeOpts{
-					Name: "kasa_device_info",
-					Help: "Information describing the Kasa device.",
-				},
-				[]string{"alias", "id", "name", "model", "sw"},
-			),
-		},
-
-		registry: prometheus.NewRegistry(),
+		daddr:          daddr,
+		target:         daddr.String(),
+		module:         module,
+		timeout:        timeout,
+		metrics:        newDeviceMetrics(),
+		registry:       prometheus.NewRegistry(),
+		status:         newDeviceStatus(),
+		statusRegistry: prometheus.NewRegistry(),
+	}
+	if err := de.metrics.register(prometheus.WrapRegistererWith(labels, de.registry), module); err != nil {
+		return nil, err
 	}
-	if err := de.metrics.register(de.registry); err != nil {
+	if err := de.status.register(prometheus.WrapRegistererWith(labels, de.statusRegistry)); err != nil {
 		return nil, err
 	}
 	return de, nil
@@ -115,30 +380,95 @@ type Handler struct {
 	sync.RWMutex
 	exporters map[string]*deviceExporter
 	laddr     *net.UDPAddr
+
+	pollInterval time.Duration
+	staleAfter   time.Duration
+	targets      []string
+	idleTimeout  time.Duration
+	allowlist    []string
+	config       *Config
+	now          func() time.Time
+	metrics      *handlerMetrics
+	sinks        []namedSink
+	// ready once background polling has polled every pinned target.
+	ready bool
+
+	// pinned targets are never evicted, and allowed targets may be scraped.
+	// Both are keyed by normalized address.
+	pinned   map[string]bool
+	allowed  map[string]bool
+	restrict bool
 }
 
-var ErrBadTarget = errors.New("bad target")
+var (
+	ErrBadTarget        = errors.New("bad target")
+	ErrTargetNotAllowed = errors.New("target not allowed")
+)
 
-func (h *Handler) exporterFor(t string) (*deviceExporter, error) {
+// normalizeTarget so that different spellings of the same address share an
+// exporter.
+func normalizeTarget(t string) (*net.UDPAddr, string, error) {
 	daddr, err := kasa.ParseAddr(t)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrBadTarget, err)
+		return nil, "", fmt.Errorf("%w: %v", ErrBadTarget, err)
+	}
+	return daddr, daddr.String(), nil
+}
+
+// evictIdle exporters which have not been used within the idle timeout. Must
+// be called with the lock held.
+func (h *Handler) evictIdle(now time.Time) {
+	if h.idleTimeout <= 0 {
+		return
+	}
+	for k, de := range h.exporters {
+		if !h.pinned[de.target] && now.Sub(de.lastUsed) > h.idleTimeout {
+			h.metrics.forget(de)
+			delete(h.exporters, k)
+		}
+	}
+}
+
+// exporterFor the target, collecting the named module. If module is empty, the
+// target's configured module or the default module is used.
+func (h *Handler) exporterFor(t, module string) (*deviceExporter, error) {
+	daddr, key, err := normalizeTarget(t)
+	if err != nil {
+		return nil, err
 	}
-	h.RLock()
-	de, has := h.exporters[t]
-	h.RUnlock()
+	now := h.now()
 
+	h.Lock()
+	defer h.Unlock()
+	if h.restrict && !h.allowed[key] && !h.pinned[key] {
+		return nil, fmt.Errorf("%w: %v", ErrTargetNotAllowed, key)
+	}
+	name, m, err := h.config.module(key, module)
+	if err != nil {
+		return nil, err
+	}
+	h.evictIdle(now)
+	ekey := name + "/" + key
+	de, has := h.exporters[ekey]
 	if !has {
+		timeout := m.Timeout
+		var labels prometheus.Labels
+		if t, has := h.config.target(key); has {
+			if t.Timeout > 0 {
+				timeout = t.Timeout
+			}
+			labels = t.Labels
+		}
 		var err error
-		de, err = newDeviceExporter(daddr)
+		de, err = newDeviceExporter(daddr, m, timeout, labels)
 		if err != nil {
 			return nil, err
 		}
-
-		h.Lock()
-		h.exporters[t] = de
-		h.Unlock()
+		de.moduleName = name
+		de.instr = h.metrics
+		h.exporters[ekey] = de
 	}
+	de.lastUsed = now
 	return de, nil
 }
 
@@ -150,18 +480,71 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	de, err := h.exporterFor(target)
+	de, err := h.exporterFor(target, r.URL.Query().Get("module"))
+	if errors.Is(err, ErrTargetNotAllowed) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Target Not Allowed")
+		return
+	}
+	if errors.Is(err, ErrUnknownModule) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Unknown Module")
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Hrm, that ain't right: %v", err)
 		return
 	}
-	if err := de.update(r.Context(), h.laddr); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed polling Kasa device: %v", err)
+	h.metrics.requests.WithLabelValues(de.target, de.moduleName).Inc()
+	if h.pollInterval > 0 {
+		h.serveCached(w, r, de)
 		return
 	}
-	promhttp.HandlerFor(de.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	// Failing to poll the device is not a failed scrape. Instead, kasa_up and
+	// kasa_poll_errors_total report the failure.
+	var g prometheus.Gatherer = prometheus.Gatherers{de.registry, de.statusRegistry}
+	if err := de.poll(withTraceID(r), h.laddr, h.now); err != nil {
+		g = de.statusRegistry
+	}
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// ApplyConfig to the Handler, replacing any previous configuration. Exporters
+// for every target are discarded, so that changes to modules and labels take
+// effect at the next scrape. A nil config is valid, and uses DefaultModule for
+// all targets.
+func (h *Handler) ApplyConfig(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.config = cfg
+	h.pinned = make(map[string]bool)
+	h.allowed = make(map[string]bool)
+	for _, de := range h.exporters {
+		h.metrics.forget(de)
+	}
+	targets := append([]string(nil), h.targets...)
+	if cfg != nil {
+		for _, t := range cfg.Targets {
+			targets = append(targets, t.Address)
+		}
+	}
+	for _, t := range targets {
+		if _, key, err := normalizeTarget(t); err == nil {
+			h.pinned[key] = true
+		}
+	}
+	for _, t := range h.allowlist {
+		if _, key, err := normalizeTarget(t); err == nil {
+			h.allowed[key] = true
+		}
+	}
+	h.restrict = len(h.allowlist) > 0 || (cfg != nil && cfg.RestrictTargets)
+	h.exporters = make(map[string]*deviceExporter)
+	return nil
 }
 
 type Option func(*Handler)
@@ -172,12 +555,56 @@ func WithLocalAddr(laddr *net.UDPAddr) Option {
 	}
 }
 
+// WithBackgroundPolling causes the Handler to poll each known target every
+// interval when Run, instead of polling when scraped. Scrapes are served from
+// the results of the most recent poll, unless it is older than staleAfter, in
+// which case only kasa_up and kasa_last_poll_timestamp_seconds are served. If
+// staleAfter is zero, it defaults to three times the interval.
+func WithBackgroundPolling(interval, staleAfter time.Duration) Option {
+	return func(h *Handler) {
+		h.pollInterval = interval
+		h.staleAfter = staleAfter
+		if h.staleAfter == 0 {
+			h.staleAfter = 3 * interval
+		}
+	}
+}
+
+// WithTargets to poll in the background from startup, before they are first
+// scraped.
+func WithTargets(targets ...string) Option {
+	return func(h *Handler) {
+		h.targets = append(h.targets, targets...)
+	}
+}
+
+// WithIdleTimeout causes exporters for targets which have not been scraped
+// within the timeout to be discarded. Targets given WithTargets or listed in
+// the config are never discarded.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(h *Handler) {
+		h.idleTimeout = timeout
+	}
+}
+
+// WithAllowedTargets restricts the targets which may be scraped to those
+// listed, and those given WithTargets or listed in the config. This prevents
+// the Handler being used to probe arbitrary addresses.
+func WithAllowedTargets(targets ...string) Option {
+	return func(h *Handler) {
+		h.allowlist = append(h.allowlist, targets...)
+	}
+}
+
 func New(opts ...Option) *Handler {
 	h := &Handler{
-		exporters: make(map[string]*deviceExporter),
+		now:     time.Now,
+		metrics: newHandlerMetrics(),
 	}
 	for _, opt := range opts {
 		opt(h)
 	}
+	// A nil config is always valid.
+	_ = h.ApplyConfig(nil)
 	return h
 }
@@ -0,0 +1,175 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func TestHandlerServeHTTP(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":       "ADSL Modem",
+		"deviceId":    "modem",
+		"relay_state": 1,
+		"rssi":        -51,
+	})
+	h := New()
+
+	got := scrape(t, h, d.Addr())
+	for _, want := range []string{
+		"kasa_relay_state 1\n",
+		"kasa_rssi -51\n",
+		"kasa_up 1\n",
+		"kasa_scrape_duration_seconds ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("scrape: want %q in:\n%v", want, got)
+		}
+	}
+
+	d.Update(func(s map[string]interface{}) {
+		s["err_code"] = -1
+		s["error_msg"] = "module not support"
+	})
+	got = scrape(t, h, d.Addr())
+	for _, want := range []string{
+		"kasa_up 0\n",
+		`kasa_poll_errors_total{reason="device_error"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("device error scrape: want %q in:\n%v", want, got)
+		}
+	}
+	if strings.Contains(got, "kasa_relay_state") {
+		t.Errorf("device error scrape: want no device metrics in:\n%v", got)
+	}
+
+	d.SetFaults(kasatest.Faults{Drop: true})
+	got = scrape(t, h, d.Addr())
+	for _, want := range []string{
+		"kasa_up 0\n",
+		`kasa_poll_errors_total{reason="timeout"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("unresponsive scrape: want %q in:\n%v", want, got)
+		}
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		err  error
+		want string
+	}{
+		"no response": {
+			err:  fmt.Errorf("%w from 1.2.3.4:9999", kasa.ErrNoResponse),
+			want: "timeout",
+		},
+		"too many responses": {
+			err:  fmt.Errorf("%w: 1.2.3.4:9999", ErrTooManyResponses),
+			want: "too_many_responses",
+		},
+		"malformed": {
+			err:  fmt.Errorf("%w: unexpected end of JSON input", kasa.ErrMalformedResponse),
+			want: "decode",
+		},
+		"device error": {
+			err:  kasa.SystemInformation{ErrorCode: -1}.Err(),
+			want: "device_error",
+		},
+		"emeter device error": {
+			err:  kasa.EmeterRealtime{ErrorCode: -1, Error: "module not support"}.Err(),
+			want: "device_error",
+		},
+		"wrapped device error": {
+			err:  fmt.Errorf("polling 1.2.3.4:9999: %w", kasa.DeviceTime{ErrorCode: -3}.Err()),
+			want: "device_error",
+		},
+		"other": {
+			err:  errors.New("sendto: network is unreachable"),
+			want: "network",
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			if got := errorReason(tc.err); got != tc.want {
+				t.Errorf("errorReason(%v): got %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandlerInfoReset(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":  "ADSL Modem",
+		"sw_ver": "1.0.3",
+	})
+	h := New()
+	scrape(t, h, d.Addr())
+	d.Update(func(s map[string]interface{}) { s["sw_ver"] = "1.0.4" })
+	got := scrape(t, h, d.Addr())
+	if strings.Contains(got, `sw="1.0.3"`) {
+		t.Errorf("scrape: want stale info series removed in:\n%v", got)
+	}
+	if !strings.Contains(got, `sw="1.0.4"`) {
+		t.Errorf("scrape: want updated info series in:\n%v", got)
+	}
+}
+
+func TestHandlerAllowedTargets(t *testing.T) {
+	h := New(WithAllowedTargets("10.42.0.10:9999"), WithTargets("10.42.0.11:9999"))
+	for target, want := range map[string]bool{
+		"10.42.0.10:9999": true,
+		"10.42.0.11:9999": true,
+		"10.42.0.12:9999": false,
+	} {
+		_, err := h.exporterFor(target, "")
+		if got := !errors.Is(err, ErrTargetNotAllowed); got != want {
+			t.Errorf("exporterFor(%q): got allowed %v, want %v (err: %v)", target, got, want, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scrape?target=10.42.0.12:9999", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("ServeHTTP(): got status %v for disallowed target, want %v", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandlerIdleTimeout(t *testing.T) {
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	h := New(WithIdleTimeout(time.Hour), WithTargets("10.42.0.10:9999"))
+	h.now = func() time.Time { return at }
+
+	for _, target := range []string{"10.42.0.10:9999", "10.42.0.11:9999"} {
+		if _, err := h.exporterFor(target, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at = at.Add(30 * time.Minute)
+	if _, err := h.exporterFor("10.42.0.12:9999", ""); err != nil {
+		t.Fatal(err)
+	}
+	at = at.Add(45 * time.Minute)
+	if _, err := h.exporterFor("10.42.0.12:9999", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+	for target, want := range map[string]bool{
+		"10.42.0.10:9999": true,
+		"10.42.0.11:9999": false,
+		"10.42.0.12:9999": true,
+	} {
+		if _, got := h.exporters["default/"+target]; got != want {
+			t.Errorf("after idle timeout: exporter for %v present: %v, want %v", target, got, want)
+		}
+	}
+}
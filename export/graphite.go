@@ -0,0 +1,72 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Graphite is a Sink which writes the metrics of each target to a Graphite
+// plaintext listener over TCP. Labels, and the target as instance, are sent as
+// Graphite tags, for example:
+//
+//	kasa.kasa_relay_state;instance=10.42.0.10:9999 1 1503180960
+type Graphite struct {
+	// Address of the Graphite plaintext listener, as host:port.
+	Address string
+	// Prefix prepended to every metric name, separated by a dot, if set.
+	Prefix string
+}
+
+// Write implements Sink.
+func (g *Graphite) Write(ctx context.Context, target string, at time.Time, mfs []*dto.MetricFamily) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", g.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write(graphiteLines(g.Prefix, flatten(mfs, instanceLabel(target)), at))
+	return err
+}
+
+// graphiteTagEscaper replaces characters which Graphite does not allow in tags.
+var graphiteTagEscaper = strings.NewReplacer(";", "_", "~", "_", " ", "_", "\n", "_")
+
+// graphiteLines formats samples in the Graphite plaintext protocol. NaN and
+// infinite values are dropped.
+func graphiteLines(prefix string, samples []sample, at time.Time) []byte {
+	var b bytes.Buffer
+	for _, s := range samples {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		if prefix != "" {
+			b.WriteString(prefix)
+			b.WriteByte('.')
+		}
+		for _, l := range s.labels {
+			if l[0] == "__name__" {
+				b.WriteString(l[1])
+			}
+		}
+		for _, l := range s.labels {
+			if l[0] != "__name__" {
+				fmt.Fprintf(&b, ";%s=%s", l[0], graphiteTagEscaper.Replace(l[1]))
+			}
+		}
+		fmt.Fprintf(&b, " %s %d\n", formatFloat(s.value), at.Unix())
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,42 @@
+package export
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGraphite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	g := &Graphite{Address: l.Addr().String(), Prefix: "home"}
+	if err := g.Write(context.Background(), "10.42.0.10:9999", at, testMetricFamilies(t)); err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	select {
+	case got := <-received:
+		if want := "home.kasa_relay_state;instance=10.42.0.10:9999 1 1503180960\n"; got != want {
+			t.Errorf("Write(): got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write(): nothing received")
+	}
+}
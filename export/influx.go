@@ -0,0 +1,123 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxUDPPayload is the largest datagram sent to InfluxDB over UDP, so that
+// writes are not fragmented on typical networks.
+const maxUDPPayload = 1400
+
+// InfluxDB is a Sink which writes the metrics of each target in InfluxDB line
+// protocol. Each metric is a measurement with a single field named value, and
+// is tagged with its labels and the target as instance.
+type InfluxDB struct {
+	// URL of the InfluxDB write endpoint. An http or https URL is sent a
+	// POST, for example http://influxdb:8086/write?db=kasa, or with InfluxDB
+	// 2, http://influxdb:8086/api/v2/write?org=home&bucket=kasa. A udp URL,
+	// for example udp://influxdb:8089, is sent datagrams.
+	URL string
+	// Token sent as the Authorization header of HTTP writes, if set.
+	Token string
+	// Client used for HTTP writes. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Write implements Sink.
+func (i *InfluxDB) Write(ctx context.Context, target string, at time.Time, mfs []*dto.MetricFamily) error {
+	lines := influxLines(flatten(mfs, instanceLabel(target)), at)
+	if strings.HasPrefix(i.URL, "udp://") {
+		return i.writeUDP(ctx, lines)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.URL, bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.Token != "" {
+		req.Header.Set("Authorization", "Token "+i.Token)
+	}
+	client := i.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb write to %v: unexpected status %v: %s", i.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// writeUDP sends lines in as few datagrams as fit.
+func (i *InfluxDB) writeUDP(ctx context.Context, lines [][]byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", strings.TrimPrefix(i.URL, "udp://"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+len(line) > maxUDPPayload {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		_, err = conn.Write(packet)
+	}
+	return err
+}
+
+func instanceLabel(target string) []*dto.LabelPair {
+	return []*dto.LabelPair{{Name: stringPtr("instance"), Value: stringPtr(target)}}
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influxLines formats samples in line protocol, each terminated by a newline.
+// Line protocol can not represent NaN or infinite values, so they are dropped.
+func influxLines(samples []sample, at time.Time) [][]byte {
+	lines := make([][]byte, 0, len(samples))
+	for _, s := range samples {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		var b bytes.Buffer
+		for _, l := range s.labels {
+			if l[0] == "__name__" {
+				b.WriteString(influxMeasurementEscaper.Replace(l[1]))
+			}
+		}
+		for _, l := range s.labels {
+			if l[0] != "__name__" {
+				fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(l[0]), influxTagEscaper.Replace(l[1]))
+			}
+		}
+		fmt.Fprintf(&b, " value=%s %d\n", formatFloat(s.value), at.UnixNano())
+		lines = append(lines, b.Bytes())
+	}
+	return lines
+}
@@ -0,0 +1,83 @@
+package export
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInfluxLines(t *testing.T) {
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	got := influxLines([]sample{
+		{labels: [][2]string{{"__name__", "kasa_relay_state"}, {"instance", "10.42.0.10:9999"}}, value: 1},
+		{labels: [][2]string{{"__name__", "kasa_child_relay_state"}, {"child_alias", "Desk Lamp, left"}}, value: 0},
+		{labels: [][2]string{{"__name__", "kasa_power_watts"}}, value: math.NaN()},
+	}, at)
+	var lines []string
+	for _, l := range got {
+		lines = append(lines, string(l))
+	}
+	want := []string{
+		"kasa_relay_state,instance=10.42.0.10:9999 value=1 1503180960000000000\n",
+		`kasa_child_relay_state,child_alias=Desk\ Lamp\,\ left value=0 1503180960000000000` + "\n",
+	}
+	if diff := cmp.Diff(want, lines); diff != "" {
+		t.Errorf("influxLines(): mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestInfluxDBHTTP(t *testing.T) {
+	var got, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got = string(b)
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	i := &InfluxDB{URL: srv.URL + "/api/v2/write?org=home&bucket=kasa", Token: "secret"}
+	if err := i.Write(context.Background(), "10.42.0.10:9999", at, testMetricFamilies(t)); err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	if want := "kasa_relay_state,instance=10.42.0.10:9999 value=1 1503180960000000000\n"; got != want {
+		t.Errorf("Write(): got body %q, want %q", got, want)
+	}
+	if want := "Token secret"; auth != want {
+		t.Errorf("Write(): got Authorization %q, want %q", auth, want)
+	}
+}
+
+func TestInfluxDBUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	i := &InfluxDB{URL: "udp://" + conn.LocalAddr().String()}
+	if err := i.Write(context.Background(), "10.42.0.10:9999", at, testMetricFamilies(t)); err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxUDPPayload)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); !strings.HasPrefix(got, "kasa_relay_state,instance=10.42.0.10:9999 value=1 ") {
+		t.Errorf("Write(): got datagram %q", got)
+	}
+}
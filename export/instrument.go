@@ -0,0 +1,202 @@
+package export
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// handlerMetrics instrument the Handler itself, as opposed to the devices it
+// polls. They are labeled by target and module, and removed when the exporter
+// for a target is forgotten.
+type handlerMetrics struct {
+	requests       *prometheus.CounterVec
+	pollDuration   *prometheus.HistogramVec
+	retries        *prometheus.CounterVec
+	decodeFailures *prometheus.CounterVec
+	cacheHits      *prometheus.CounterVec
+	cacheMisses    *prometheus.CounterVec
+	sinkErrors     *prometheus.CounterVec
+	targets        *prometheus.Desc
+}
+
+var targetLabelNames = []string{"target", "module"}
+
+func newHandlerMetrics() *handlerMetrics {
+	return &handlerMetrics{
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_exporter_scrape_requests_total",
+				Help: "Number of scrape requests handled, by target.",
+			},
+			targetLabelNames,
+		),
+		pollDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "kasa_exporter_poll_duration_seconds",
+				Help:    "Time taken to poll Kasa devices, by target.",
+				Buckets: []float64{.05, .1, .25, .5, 1, 1.5, 2, 5, 10},
+			},
+			targetLabelNames,
+		),
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_exporter_poll_retries_total",
+				Help: "Number of polls retried after a Kasa device did not respond, by target.",
+			},
+			targetLabelNames,
+		),
+		decodeFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_exporter_decode_failures_total",
+				Help: "Number of responses from Kasa devices which could not be decoded, by target.",
+			},
+			targetLabelNames,
+		),
+		cacheHits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_exporter_cache_hits_total",
+				Help: "Number of scrapes served from the results of background polling, by target.",
+			},
+			targetLabelNames,
+		),
+		cacheMisses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_exporter_cache_misses_total",
+				Help: "Number of scrapes which could not be served from fresh background poll results, by target.",
+			},
+			targetLabelNames,
+		),
+		sinkErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_exporter_sink_errors_total",
+				Help: "Number of failed writes of target metrics to a sink, by sink.",
+			},
+			[]string{"sink"},
+		),
+		targets: prometheus.NewDesc(
+			"kasa_exporter_targets",
+			"Number of targets known to the exporter.",
+			nil, nil,
+		),
+	}
+}
+
+// observePoll records the duration of a poll. Polls made for a scrape which
+// carries a trace are recorded with the trace ID as an exemplar.
+func (m *handlerMetrics) observePoll(ctx context.Context, de *deviceExporter, took time.Duration) {
+	obs := m.pollDuration.WithLabelValues(de.target, de.moduleName)
+	if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+		obs.(prometheus.ExemplarObserver).ObserveWithExemplar(took.Seconds(), prometheus.Labels{"trace_id": id})
+		return
+	}
+	obs.Observe(took.Seconds())
+}
+
+// traceIDKey is the context key of the trace ID of a scrape.
+type traceIDKey struct{}
+
+// withTraceID returns the context of r, carrying the trace ID of its W3C
+// traceparent header if it has a valid one.
+func withTraceID(r *http.Request) context.Context {
+	// The header is version-traceid-parentid-flags, all in lowercase hex.
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || parts[1] != strings.ToLower(parts[1]) || parts[1] == strings.Repeat("0", 32) {
+		return r.Context()
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return r.Context()
+	}
+	return context.WithValue(r.Context(), traceIDKey{}, parts[1])
+}
+
+func (m *handlerMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.requests, m.pollDuration, m.retries, m.decodeFailures, m.cacheHits, m.cacheMisses, m.sinkErrors}
+}
+
+// forget the series for an exporter which is no longer known.
+func (m *handlerMetrics) forget(de *deviceExporter) {
+	for _, v := range []interface {
+		DeleteLabelValues(...string) bool
+	}{m.requests, m.pollDuration, m.retries, m.decodeFailures, m.cacheHits, m.cacheMisses} {
+		v.DeleteLabelValues(de.target, de.moduleName)
+	}
+}
+
+// Describe implements prometheus.Collector, so that the Handler's own metrics
+// may be registered alongside those of the rest of the program.
+func (h *Handler) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range h.metrics.collectors() {
+		c.Describe(ch)
+	}
+	ch <- h.metrics.targets
+}
+
+// Collect implements prometheus.Collector.
+func (h *Handler) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range h.metrics.collectors() {
+		c.Collect(ch)
+	}
+	h.RLock()
+	n := len(h.exporters)
+	h.RUnlock()
+	ch <- prometheus.MustNewConstMetric(h.metrics.targets, prometheus.GaugeValue, float64(n))
+}
+
+// KnownTarget describes a target for which the Handler keeps state.
+type KnownTarget struct {
+	// Address of the device, as ip:port.
+	Address string
+	// Module with which the target is scraped.
+	Module string
+	// Up is true if the device responded to the most recent poll.
+	Up bool
+	// LastPoll is the time at which the device last responded to a poll. It
+	// is zero if the device has never responded.
+	LastPoll time.Time
+	// Pinned targets are listed in the config or given WithTargets, and are
+	// never forgotten.
+	Pinned bool
+}
+
+// Targets known to the Handler, ordered by address and module.
+func (h *Handler) Targets() []KnownTarget {
+	h.RLock()
+	var known []KnownTarget
+	for _, de := range h.exporters {
+		de.pollMu.Lock()
+		known = append(known, KnownTarget{
+			Address:  de.target,
+			Module:   de.moduleName,
+			Up:       de.up,
+			LastPoll: de.lastPoll,
+			Pinned:   h.pinned[de.target],
+		})
+		de.pollMu.Unlock()
+	}
+	h.RUnlock()
+	sort.Slice(known, func(i, j int) bool {
+		if known[i].Address != known[j].Address {
+			return known[i].Address < known[j].Address
+		}
+		return known[i].Module < known[j].Module
+	})
+	return known
+}
+
+// Ready is true once the Handler can serve scrapes. With background polling,
+// that is once every target known at startup has been polled; otherwise it is
+// immediately.
+func (h *Handler) Ready() bool {
+	if h.pollInterval <= 0 {
+		return true
+	}
+	h.RLock()
+	defer h.RUnlock()
+	return h.ready
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func TestHandlerInstrumentation(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":       "ADSL Modem",
+		"relay_state": 1,
+	})
+	h := New()
+	if err := h.ApplyConfig(&Config{
+		Modules: map[string]Module{
+			"retry": {Collect: []string{CollectSysinfo}, Retries: 1},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape(t, h, d.Addr())
+	scrape(t, h, d.Addr())
+	d.Update(func(s map[string]interface{}) { s["relay_state"] = "on" })
+	scrape(t, h, d.Addr())
+	d.SetFaults(kasatest.Faults{Drop: true})
+	scrape(t, h, d.Addr()+"&module=retry")
+
+	for name, tc := range map[string]struct {
+		got  float64
+		want float64
+	}{
+		"requests": {
+			got:  testutil.ToFloat64(h.metrics.requests.WithLabelValues(d.Addr(), "default")),
+			want: 3,
+		},
+		"decode failures": {
+			got:  testutil.ToFloat64(h.metrics.decodeFailures.WithLabelValues(d.Addr(), "default")),
+			want: 1,
+		},
+		"retries": {
+			got:  testutil.ToFloat64(h.metrics.retries.WithLabelValues(d.Addr(), "retry")),
+			want: 1,
+		},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%v: got %v, want %v", name, tc.got, tc.want)
+		}
+	}
+	if n := testutil.CollectAndCount(h, "kasa_exporter_poll_duration_seconds"); n != 2 {
+		t.Errorf("poll duration: got %v histograms, want 2", n)
+	}
+	want := "\n# HELP kasa_exporter_targets Number of targets known to the exporter.\n# TYPE kasa_exporter_targets gauge\nkasa_exporter_targets 2\n"
+	if err := testutil.CollectAndCompare(h, strings.NewReader(want), "kasa_exporter_targets"); err != nil {
+		t.Error(err)
+	}
+
+	known := h.Targets()
+	for i := range known {
+		known[i].LastPoll = time.Time{}
+	}
+	if diff := cmp.Diff([]KnownTarget{
+		{Address: d.Addr(), Module: "default"},
+		{Address: d.Addr(), Module: "retry"},
+	}, known); diff != "" {
+		t.Errorf("Targets(): mismatch (-want +got):\n%v", diff)
+	}
+
+	// Forgotten targets take their series with them.
+	if err := h.ApplyConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(h, "kasa_exporter_scrape_requests_total"); n != 0 {
+		t.Errorf("after ApplyConfig(): got %v request series, want 0", n)
+	}
+}
+
+func TestHandlerPollExemplars(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":       "ADSL Modem",
+		"relay_state": 1,
+	})
+	h := New()
+	for _, traceparent := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		// Invalid trace IDs are ignored.
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"garbage",
+		"",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/scrape?target="+d.Addr(), nil)
+		req.Header.Set("traceparent", traceparent)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("scrape: got status %v: %v", rec.Code, rec.Body.String())
+		}
+	}
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(h)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(rec, req)
+	var exemplars []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "kasa_exporter_poll_duration_seconds_bucket") {
+			if i := strings.Index(line, " # "); i >= 0 {
+				exemplars = append(exemplars, strings.Fields(line[i+3:])[0])
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{`{trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`}, exemplars); diff != "" {
+		t.Errorf("exemplars mismatch (-want +got):\n%v", diff)
+	}
+}
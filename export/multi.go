@@ -0,0 +1,133 @@
+package export
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deviceLabelNames are attached to every metric exported by Collector, to
+// distinguish devices from one another.
+var deviceLabelNames = []string{"address", "id", "alias"}
+
+var (
+	multiOnTimeDesc = prometheus.NewDesc(
+		"kasa_on_time",
+		"Amount of time a Kasa device has been on.",
+		deviceLabelNames, nil,
+	)
+	multiRelayStateDesc = prometheus.NewDesc(
+		"kasa_relay_state",
+		"State of the relay for a given Kasa device.",
+		deviceLabelNames, nil,
+	)
+	multiRSSIDesc = prometheus.NewDesc(
+		"kasa_rssi",
+		"RSSI of the Kasa device radio.",
+		deviceLabelNames, nil,
+	)
+	multiInfoDesc = prometheus.NewDesc(
+		"kasa_device_info",
+		"Information describing the Kasa device.",
+		append(deviceLabelNames, "name", "model", "sw"), nil,
+	)
+	multiUpDesc = prometheus.NewDesc(
+		"kasa_up",
+		"Whether the Kasa device responded to discovery.",
+		[]string{"address"}, nil,
+	)
+	multiDevicesDesc = prometheus.NewDesc(
+		"kasa_devices",
+		"Number of Kasa devices which responded to discovery.",
+		nil, nil,
+	)
+)
+
+// DefaultCollectTimeout bounds discovery by a Collector which is not given a
+// timeout.
+const DefaultCollectTimeout = 5 * time.Second
+
+// Collector discovers Kasa devices each time it is collected, and exports
+// metrics for all of them labeled by device. It is suitable for small
+// installations where a single scrape of every device is preferable to
+// configuring a Prometheus target per device.
+type Collector struct {
+	discover inventory.DiscoverFunc
+	timeout  time.Duration
+	targets  []*net.UDPAddr
+}
+
+type CollectorOption func(*Collector)
+
+// WithCollectTimeout bounds discovery each time the Collector is collected.
+func WithCollectTimeout(timeout time.Duration) CollectorOption {
+	return func(c *Collector) {
+		c.timeout = timeout
+	}
+}
+
+// WithStaticTargets exports kasa_up for each of the addresses, which should be
+// those polled by discover, so that devices which did not respond are visible.
+func WithStaticTargets(daddrs ...*net.UDPAddr) CollectorOption {
+	return func(c *Collector) {
+		c.targets = daddrs
+	}
+}
+
+// NewCollector which finds devices using discover. Use inventory.Broadcast to
+// find all devices on the network, or inventory.Static to poll a list of
+// devices concurrently.
+func NewCollector(discover inventory.DiscoverFunc, opts ...CollectorOption) *Collector {
+	c := &Collector{discover: discover, timeout: DefaultCollectTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- multiOnTimeDesc
+	ch <- multiRelayStateDesc
+	ch <- multiRSSIDesc
+	ch <- multiInfoDesc
+	ch <- multiUpDesc
+	ch <- multiDevicesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	infos, err := c.discover(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(multiDevicesDesc, err)
+		return
+	}
+	devices := inventory.NewSnapshot(infos)
+	responded := make(map[string]bool)
+	for _, info := range devices {
+		if info.RemoteAddress != nil {
+			responded[info.RemoteAddress.String()] = true
+		}
+	}
+	for _, daddr := range c.targets {
+		up := 0.0
+		if responded[daddr.String()] {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(multiUpDesc, prometheus.GaugeValue, up, daddr.String())
+	}
+	ch <- prometheus.MustNewConstMetric(multiDevicesDesc, prometheus.GaugeValue, float64(len(devices)))
+	for _, info := range devices {
+		labels := []string{info.RemoteAddress.String(), info.DeviceID, info.Alias}
+		ch <- prometheus.MustNewConstMetric(multiOnTimeDesc, prometheus.GaugeValue, float64(info.OnTime), labels...)
+		ch <- prometheus.MustNewConstMetric(multiRelayStateDesc, prometheus.GaugeValue, float64(info.RelayState), labels...)
+		ch <- prometheus.MustNewConstMetric(multiRSSIDesc, prometheus.GaugeValue, float64(info.RSSI), labels...)
+		ch <- prometheus.MustNewConstMetric(multiInfoDesc, prometheus.GaugeValue, 1.0,
+			append(labels, info.DevName, info.Model, info.SoftwareVersion)...)
+	}
+}
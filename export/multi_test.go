@@ -0,0 +1,103 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/kasatest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector(func(context.Context) ([]*kasa.SystemInformation, error) {
+		return []*kasa.SystemInformation{
+			{
+				RemoteAddress:   &net.UDPAddr{IP: net.ParseIP("10.42.0.10"), Port: 9999},
+				Alias:           "ADSL Modem",
+				DeviceID:        "modem",
+				DevName:         "Smart Wi-Fi Plug Mini",
+				Model:           "HS103(US)",
+				SoftwareVersion: "1.0.3",
+				OnTime:          6831,
+				RelayState:      1,
+				RSSI:            -51,
+			},
+			{
+				RemoteAddress: &net.UDPAddr{IP: net.ParseIP("10.42.0.11"), Port: 9999},
+				Alias:         "Lamp",
+				DeviceID:      "lamp",
+				RSSI:          -67,
+			},
+		}, nil
+	})
+	want := `
+# HELP kasa_devices Number of Kasa devices which responded to discovery.
+# TYPE kasa_devices gauge
+kasa_devices 2
+# HELP kasa_device_info Information describing the Kasa device.
+# TYPE kasa_device_info gauge
+kasa_device_info{address="10.42.0.10:9999",alias="ADSL Modem",id="modem",model="HS103(US)",name="Smart Wi-Fi Plug Mini",sw="1.0.3"} 1
+kasa_device_info{address="10.42.0.11:9999",alias="Lamp",id="lamp",model="",name="",sw=""} 1
+# HELP kasa_on_time Amount of time a Kasa device has been on.
+# TYPE kasa_on_time gauge
+kasa_on_time{address="10.42.0.10:9999",alias="ADSL Modem",id="modem"} 6831
+kasa_on_time{address="10.42.0.11:9999",alias="Lamp",id="lamp"} 0
+# HELP kasa_relay_state State of the relay for a given Kasa device.
+# TYPE kasa_relay_state gauge
+kasa_relay_state{address="10.42.0.10:9999",alias="ADSL Modem",id="modem"} 1
+kasa_relay_state{address="10.42.0.11:9999",alias="Lamp",id="lamp"} 0
+# HELP kasa_rssi RSSI of the Kasa device radio.
+# TYPE kasa_rssi gauge
+kasa_rssi{address="10.42.0.10:9999",alias="ADSL Modem",id="modem"} -51
+kasa_rssi{address="10.42.0.11:9999",alias="Lamp",id="lamp"} -67
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Errorf("Collect(): %v", err)
+	}
+}
+
+func TestCollectorStaticTargets(t *testing.T) {
+	up := newFakeDevice(t, map[string]interface{}{
+		"alias":    "ADSL Modem",
+		"deviceId": "modem",
+	})
+	down := kasatest.Start(t, kasatest.WithFaults(kasatest.Faults{Drop: true}))
+	daddrs := []*net.UDPAddr{up.UDPAddr(), down.UDPAddr()}
+	c := NewCollector(inventory.Static(daddrs, nil),
+		WithCollectTimeout(kasatest.Timeout), WithStaticTargets(daddrs...))
+	want := fmt.Sprintf(`
+# HELP kasa_up Whether the Kasa device responded to discovery.
+# TYPE kasa_up gauge
+kasa_up{address=%q} 1
+kasa_up{address=%q} 0
+`, up.Addr(), down.Addr())
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "kasa_up"); err != nil {
+		t.Errorf("Collect(): %v", err)
+	}
+}
+
+func TestCollectorTimeout(t *testing.T) {
+	c := NewCollector(func(ctx context.Context) ([]*kasa.SystemInformation, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("discover: context has no deadline")
+		}
+		<-ctx.Done()
+		return nil, nil
+	}, WithCollectTimeout(10*time.Millisecond))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testutil.CollectAndCount(c)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collect(): discovery was not bounded by the timeout")
+	}
+}
@@ -0,0 +1,230 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// deviceStatus describes the outcome of polling a device.
+type deviceStatus struct {
+	up       prometheus.Gauge
+	lastPoll prometheus.Gauge
+	duration prometheus.Gauge
+	errors   *prometheus.CounterVec
+}
+
+func newDeviceStatus() deviceStatus {
+	s := deviceStatus{
+		up: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_up",
+				Help: "Whether the Kasa device responded to the most recent poll.",
+			},
+		),
+		lastPoll: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_last_poll_timestamp_seconds",
+				Help: "Time at which the Kasa device last responded to a poll.",
+			},
+		),
+		duration: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kasa_scrape_duration_seconds",
+				Help: "Time taken by the most recent poll of the Kasa device.",
+			},
+		),
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kasa_poll_errors_total",
+				Help: "Number of failed polls of the Kasa device, by reason.",
+			},
+			[]string{"reason"},
+		),
+	}
+	// Initialize every reason, so that increases from zero are visible.
+	for _, reason := range errorReasons {
+		s.errors.WithLabelValues(reason)
+	}
+	return s
+}
+
+func (s *deviceStatus) register(r prometheus.Registerer) error {
+	if err := r.Register(s.up); err != nil {
+		return err
+	}
+	if err := r.Register(s.lastPoll); err != nil {
+		return err
+	}
+	if err := r.Register(s.duration); err != nil {
+		return err
+	}
+	return r.Register(s.errors)
+}
+
+// Reasons for which polling a device may fail.
+const (
+	reasonTimeout          = "timeout"
+	reasonTooManyResponses = "too_many_responses"
+	reasonDecode           = "decode"
+	reasonDeviceError      = "device_error"
+	reasonNetwork          = "network"
+)
+
+var errorReasons = []string{reasonTimeout, reasonTooManyResponses, reasonDecode, reasonDeviceError, reasonNetwork}
+
+// errorReason classifies an error returned while polling a device.
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, kasa.ErrNoResponse):
+		return reasonTimeout
+	case errors.Is(err, ErrTooManyResponses):
+		return reasonTooManyResponses
+	case errors.Is(err, kasa.ErrMalformedResponse):
+		return reasonDecode
+	}
+	var derr *kasa.DeviceError
+	if errors.As(err, &derr) {
+		return reasonDeviceError
+	}
+	return reasonNetwork
+}
+
+// poll the device, and record the outcome in the device status. Polls to which
+// the device does not respond are retried as configured by the module.
+func (e *deviceExporter) poll(ctx context.Context, laddr *net.UDPAddr, now func() time.Time) error {
+	e.pollMu.Lock()
+	defer e.pollMu.Unlock()
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	start := now()
+	err := e.update(ctx, laddr)
+	for retry := 0; retry < e.module.Retries && errors.Is(err, kasa.ErrNoResponse) && ctx.Err() == nil; retry++ {
+		if e.instr != nil {
+			e.instr.retries.WithLabelValues(e.target, e.moduleName).Inc()
+		}
+		err = e.update(ctx, laddr)
+	}
+	took := now().Sub(start)
+	e.status.duration.Set(took.Seconds())
+	if e.instr != nil {
+		e.instr.observePoll(ctx, e, took)
+		if errors.Is(err, kasa.ErrMalformedResponse) {
+			e.instr.decodeFailures.WithLabelValues(e.target, e.moduleName).Inc()
+		}
+	}
+	e.polled = true
+	e.up = err == nil
+	if err != nil {
+		e.status.up.Set(0)
+		e.status.errors.WithLabelValues(errorReason(err)).Inc()
+		return err
+	}
+	e.lastPoll = now()
+	e.status.up.Set(1)
+	e.status.lastPoll.Set(float64(e.lastPoll.UnixNano()) / 1e9)
+	return nil
+}
+
+// gatherer for the device's cached metrics. Device metrics are omitted if the
+// most recent successful poll is older than staleAfter, in which case fresh is
+// false.
+func (e *deviceExporter) gatherer(now time.Time, staleAfter time.Duration) (g prometheus.Gatherer, fresh bool) {
+	e.pollMu.Lock()
+	defer e.pollMu.Unlock()
+	if e.lastPoll.IsZero() || now.Sub(e.lastPoll) > staleAfter {
+		e.up = false
+		e.status.up.Set(0)
+		return e.statusRegistry, false
+	}
+	return prometheus.Gatherers{e.registry, e.statusRegistry}, true
+}
+
+func (h *Handler) serveCached(w http.ResponseWriter, r *http.Request, de *deviceExporter) {
+	de.pollMu.Lock()
+	polled := de.polled
+	de.pollMu.Unlock()
+	if !polled {
+		// Targets are first polled when they are first scraped, so that there
+		// is something to serve.
+		_ = de.poll(withTraceID(r), h.laddr, h.now)
+	}
+	g, fresh := de.gatherer(h.now(), h.staleAfter)
+	if polled && fresh {
+		h.metrics.cacheHits.WithLabelValues(de.target, de.moduleName).Inc()
+	} else {
+		h.metrics.cacheMisses.WithLabelValues(de.target, de.moduleName).Inc()
+	}
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// pollAll known targets concurrently.
+func (h *Handler) pollAll(ctx context.Context) {
+	h.RLock()
+	var pinned []string
+	for t := range h.pinned {
+		pinned = append(pinned, t)
+	}
+	h.RUnlock()
+	// Pinned targets are polled from startup, or as soon as they are added by
+	// a new config, using their default module.
+	for _, t := range pinned {
+		_, _ = h.exporterFor(t, "")
+	}
+
+	h.Lock()
+	h.evictIdle(h.now())
+	exporters := make([]*deviceExporter, 0, len(h.exporters))
+	for _, de := range h.exporters {
+		exporters = append(exporters, de)
+	}
+	h.Unlock()
+
+	var wg sync.WaitGroup
+	for _, de := range exporters {
+		wg.Add(1)
+		go func(de *deviceExporter) {
+			defer wg.Done()
+			_ = de.poll(ctx, h.laddr, h.now)
+		}(de)
+	}
+	wg.Wait()
+
+	h.Lock()
+	h.ready = true
+	h.Unlock()
+}
+
+// Run background polling until the context is canceled, writing metrics to any
+// sinks after each poll. Does nothing unless the Handler was created
+// WithBackgroundPolling; without it, any sinks are an error
+// (ErrSinksWithoutPolling), as they would never be written.
+func (h *Handler) Run(ctx context.Context) error {
+	if h.pollInterval <= 0 {
+		if len(h.sinks) > 0 {
+			return ErrSinksWithoutPolling
+		}
+		return nil
+	}
+	t := time.NewTicker(h.pollInterval)
+	defer t.Stop()
+	for {
+		h.pollAll(ctx)
+		h.writeSinks(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func scrape(t *testing.T, h http.Handler, target string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scrape?target="+target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape: got status %v: %v", rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func TestBackgroundPolling(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":       "ADSL Modem",
+		"deviceId":    "modem",
+		"relay_state": 1,
+	})
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	h := New(WithBackgroundPolling(time.Minute, 0), WithTargets(d.Addr()))
+	h.now = func() time.Time { return at }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = h.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Wait for the initial background poll of the configured target.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		de, err := h.exporterFor(d.Addr(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		de.pollMu.Lock()
+		polled := de.polled
+		de.pollMu.Unlock()
+		if polled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("target was not polled in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Changes on the device are not visible until the next poll.
+	d.Update(func(s map[string]interface{}) { s["relay_state"] = 0 })
+	got := scrape(t, h, d.Addr())
+	for _, want := range []string{
+		"kasa_relay_state 1\n",
+		"kasa_up 1\n",
+		"kasa_last_poll_timestamp_seconds 1.50318096e+09\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("fresh scrape: want %q in:\n%v", want, got)
+		}
+	}
+
+	// Once the last successful poll is stale, device metrics are not served.
+	at = at.Add(4 * time.Minute)
+	got = scrape(t, h, d.Addr())
+	if !strings.Contains(got, "kasa_up 0\n") {
+		t.Errorf("stale scrape: want kasa_up 0 in:\n%v", got)
+	}
+	if strings.Contains(got, "kasa_relay_state") {
+		t.Errorf("stale scrape: want no device metrics in:\n%v", got)
+	}
+
+	if got := testutil.ToFloat64(h.metrics.cacheHits.WithLabelValues(d.Addr(), "default")); got != 1 {
+		t.Errorf("cache hits: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.cacheMisses.WithLabelValues(d.Addr(), "default")); got != 1 {
+		t.Errorf("cache misses: got %v, want 1", got)
+	}
+	if !h.Ready() {
+		t.Error("Ready(): got false after background poll, want true")
+	}
+}
@@ -0,0 +1,202 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Pushgateway is a Sink which pushes the metrics of each target to a
+// Prometheus Pushgateway, grouped by job and instance. Each push replaces the
+// metrics previously pushed for the target.
+type Pushgateway struct {
+	// URL of the Pushgateway, for example http://pushgateway:9091.
+	URL string
+	// Job with which pushed metrics are grouped.
+	Job string
+	// Client used to push. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// contextDoer sends push requests with a context, which the push package does
+// not support directly.
+type contextDoer struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func (d contextDoer) Do(r *http.Request) (*http.Response, error) {
+	return d.client.Do(r.WithContext(d.ctx))
+}
+
+// Write implements Sink.
+func (p *Pushgateway) Write(ctx context.Context, target string, at time.Time, mfs []*dto.MetricFamily) error {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	g := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return mfs, nil })
+	return push.New(p.URL, p.Job).
+		Gatherer(g).
+		Grouping("instance", target).
+		Client(contextDoer{ctx: ctx, client: client}).
+		Push()
+}
+
+// RemoteWrite is a Sink which sends the metrics of each target to a Prometheus
+// remote write endpoint, labeled with job and instance.
+type RemoteWrite struct {
+	// URL of the remote write endpoint, for example
+	// http://prometheus:9090/api/v1/write.
+	URL string
+	// Job with which samples are labeled.
+	Job string
+	// Client used to send samples. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Write implements Sink.
+func (rw *RemoteWrite) Write(ctx context.Context, target string, at time.Time, mfs []*dto.MetricFamily) error {
+	client := rw.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	extra := []*dto.LabelPair{
+		{Name: stringPtr("job"), Value: stringPtr(rw.Job)},
+		{Name: stringPtr("instance"), Value: stringPtr(target)},
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(flatten(mfs, extra), at))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write to %v: unexpected status %v: %s", rw.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// sample is a single value of a time series, flattened from a metric family.
+type sample struct {
+	// labels, including __name__, sorted by name.
+	labels [][2]string
+	value  float64
+}
+
+// flatten metric families into samples, as Prometheus would store them when
+// scraped. Histograms and summaries become several series.
+func flatten(mfs []*dto.MetricFamily, extra []*dto.LabelPair) []sample {
+	var samples []sample
+	add := func(name string, pairs []*dto.LabelPair, value float64, more ...string) {
+		labels := [][2]string{{"__name__", name}}
+		for _, lps := range [][]*dto.LabelPair{extra, pairs} {
+			for _, lp := range lps {
+				if lp.GetValue() == "" {
+					continue
+				}
+				labels = append(labels, [2]string{lp.GetName(), lp.GetValue()})
+			}
+		}
+		for i := 0; i+1 < len(more); i += 2 {
+			labels = append(labels, [2]string{more[i], more[i+1]})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+		samples = append(samples, sample{labels: labels, value: value})
+	}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			lps := m.GetLabel()
+			switch {
+			case m.Gauge != nil:
+				add(name, lps, m.Gauge.GetValue())
+			case m.Counter != nil:
+				add(name, lps, m.Counter.GetValue())
+			case m.Untyped != nil:
+				add(name, lps, m.Untyped.GetValue())
+			case m.Summary != nil:
+				for _, q := range m.Summary.Quantile {
+					add(name, lps, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", lps, m.Summary.GetSampleSum())
+				add(name+"_count", lps, float64(m.Summary.GetSampleCount()))
+			case m.Histogram != nil:
+				for _, b := range m.Histogram.Bucket {
+					add(name+"_bucket", lps, float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add(name+"_bucket", lps, float64(m.Histogram.GetSampleCount()), "le", "+Inf")
+				add(name+"_sum", lps, m.Histogram.GetSampleSum())
+				add(name+"_count", lps, float64(m.Histogram.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest as a remote write protobuf WriteRequest, with every
+// sample at the given time. The message is small enough that it is encoded by
+// hand rather than pulling in the Prometheus prompb package:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []sample, at time.Time) []byte {
+	ts := at.UnixNano() / int64(time.Millisecond)
+	var req []byte
+	for _, s := range samples {
+		var series []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var smp []byte
+		smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+		smp = protowire.AppendFixed64(smp, math.Float64bits(s.value))
+		smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+		smp = protowire.AppendVarint(smp, uint64(ts))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, smp)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
@@ -0,0 +1,251 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// recordingSink remembers the metric names written for each target.
+type recordingSink struct {
+	mu     sync.Mutex
+	writes map[string][]string
+}
+
+func (s *recordingSink) Write(ctx context.Context, target string, at time.Time, mfs []*dto.MetricFamily) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writes == nil {
+		s.writes = make(map[string][]string)
+	}
+	for _, mf := range mfs {
+		s.writes[target] = append(s.writes[target], mf.GetName())
+	}
+	return nil
+}
+
+func TestHandlerWriteSinks(t *testing.T) {
+	d := newFakeDevice(t, map[string]interface{}{
+		"alias":       "ADSL Modem",
+		"relay_state": 1,
+	})
+	var sink recordingSink
+	h := New(WithBackgroundPolling(time.Minute, 0), WithTargets(d.Addr()), WithSink("test", &sink))
+	h.pollAll(context.Background())
+	h.writeSinks(context.Background())
+
+	got := sink.writes[d.Addr()]
+	for _, want := range []string{"kasa_relay_state", "kasa_up"} {
+		found := false
+		for _, name := range got {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("writeSinks(): want %v written, got %v", want, got)
+		}
+	}
+}
+
+func TestHandlerRunSinksWithoutPolling(t *testing.T) {
+	h := New(WithSink("test", &recordingSink{}))
+	if err := h.Run(context.Background()); !errors.Is(err, ErrSinksWithoutPolling) {
+		t.Errorf("Run(): got error %v, want %v", err, ErrSinksWithoutPolling)
+	}
+}
+
+func testMetricFamilies(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	m := newDeviceMetrics()
+	m.relayState.Set(1)
+	g, err := gatherMetrics(m.relayState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestPushgateway(t *testing.T) {
+	var gotPath, gotMethod, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotMethod = r.URL.Path, r.Method
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	p := &Pushgateway{URL: srv.URL, Job: "kasa"}
+	if err := p.Write(context.Background(), "10.42.0.10:9999", time.Now(), testMetricFamilies(t)); err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	if gotMethod != http.MethodPut {
+		t.Errorf("Write(): got method %v, want PUT", gotMethod)
+	}
+	if want := "/metrics/job/kasa/instance/10.42.0.10:9999"; gotPath != want {
+		t.Errorf("Write(): got path %q, want %q", gotPath, want)
+	}
+	if !strings.Contains(gotBody, "kasa_relay_state") {
+		t.Errorf("Write(): want kasa_relay_state pushed")
+	}
+}
+
+// decodeWriteRequest into series, each formatted as labels followed by value
+// and timestamp.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	fields := func(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte, u uint64)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			switch typ {
+			case protowire.BytesType:
+				v, n := protowire.ConsumeBytes(b)
+				if n < 0 {
+					t.Fatal(protowire.ParseError(n))
+				}
+				f(num, typ, v, 0)
+				b = b[n:]
+			case protowire.Fixed64Type:
+				u, n := protowire.ConsumeFixed64(b)
+				f(num, typ, nil, u)
+				b = b[n:]
+			case protowire.VarintType:
+				u, n := protowire.ConsumeVarint(b)
+				f(num, typ, nil, u)
+				b = b[n:]
+			default:
+				t.Fatalf("unexpected wire type %v", typ)
+			}
+		}
+	}
+	var series []string
+	fields(b, func(_ protowire.Number, _ protowire.Type, ts []byte, _ uint64) {
+		var labels []string
+		var value string
+		fields(ts, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, val string
+				fields(v, func(num protowire.Number, _ protowire.Type, s []byte, _ uint64) {
+					if num == 1 {
+						name = string(s)
+					} else {
+						val = string(s)
+					}
+				})
+				labels = append(labels, name+"="+val)
+			case 2:
+				fields(v, func(num protowire.Number, _ protowire.Type, _ []byte, u uint64) {
+					if num == 1 {
+						value += formatFloat(math.Float64frombits(u))
+					} else {
+						value += "@" + strconv.FormatUint(u, 10)
+					}
+				})
+			}
+		})
+		sort.Strings(labels)
+		series = append(series, strings.Join(labels, ",")+" "+value)
+	})
+	return series
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var got []string
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		compressed, _ := ioutil.ReadAll(r.Body)
+		b, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("snappy.Decode(): %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = decodeWriteRequest(t, b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	rw := &RemoteWrite{URL: srv.URL, Job: "kasa"}
+	if err := rw.Write(context.Background(), "10.42.0.10:9999", at, testMetricFamilies(t)); err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	want := []string{"__name__=kasa_relay_state,instance=10.42.0.10:9999,job=kasa 1@1503180960000"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Write(): mismatch (-want +got):\n%v", diff)
+	}
+	if got := headers.Get("Content-Encoding"); got != "snappy" {
+		t.Errorf("Write(): got Content-Encoding %q, want snappy", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	rw.URL = failing.URL
+	if err := rw.Write(context.Background(), "10.42.0.10:9999", at, testMetricFamilies(t)); err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("Write(): got error %v, want error including response", err)
+	}
+}
+
+func TestFlattenHistogram(t *testing.T) {
+	m := newHandlerMetrics()
+	m.pollDuration.WithLabelValues("10.42.0.10:9999", "default").Observe(0.3)
+	mfs, err := gatherMetrics(m.pollDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range flatten(mfs, nil) {
+		var labels []string
+		for _, l := range s.labels {
+			if l[0] == "le" || l[0] == "__name__" {
+				labels = append(labels, l[1])
+			}
+		}
+		if labels[0] == "kasa_exporter_poll_duration_seconds_bucket" && labels[1] != "0.25" && labels[1] != "0.5" && labels[1] != "+Inf" {
+			continue
+		}
+		got = append(got, strings.Join(labels, " ")+" "+formatFloat(s.value))
+	}
+	want := []string{
+		"kasa_exporter_poll_duration_seconds_bucket 0.25 0",
+		"kasa_exporter_poll_duration_seconds_bucket 0.5 1",
+		"kasa_exporter_poll_duration_seconds_bucket +Inf 1",
+		"kasa_exporter_poll_duration_seconds_sum 0.3",
+		"kasa_exporter_poll_duration_seconds_count 1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("flatten(): mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func gatherMetrics(cs ...prometheus.Collector) ([]*dto.MetricFamily, error) {
+	r := prometheus.NewRegistry()
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return r.Gather()
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A Sink receives the metrics of each target after every background poll. It
+// is used where the exporter can not be scraped, so that metrics must be sent
+// elsewhere instead.
+type Sink interface {
+	// Write the metrics of the target, as polled at the given time.
+	Write(ctx context.Context, target string, at time.Time, mfs []*dto.MetricFamily) error
+}
+
+// ErrSinksWithoutPolling is returned by Run for a Handler with sinks but without
+// background polling, which would never write to them.
+var ErrSinksWithoutPolling = errors.New("sinks are only written by background polling")
+
+type namedSink struct {
+	name string
+	sink Sink
+}
+
+// WithSink causes the Handler to write the metrics of every known target to
+// the sink after each background poll. Failed writes are counted by sink name
+// in kasa_exporter_sink_errors_total. Sinks are only written when the Handler
+// is created WithBackgroundPolling; otherwise Run returns
+// ErrSinksWithoutPolling.
+func WithSink(name string, sink Sink) Option {
+	return func(h *Handler) {
+		h.sinks = append(h.sinks, namedSink{name: name, sink: sink})
+	}
+}
+
+// writeSinks writes the metrics of every known target to every sink.
+func (h *Handler) writeSinks(ctx context.Context) {
+	if len(h.sinks) == 0 {
+		return
+	}
+	h.RLock()
+	exporters := make([]*deviceExporter, 0, len(h.exporters))
+	for _, de := range h.exporters {
+		exporters = append(exporters, de)
+	}
+	h.RUnlock()
+	sort.Slice(exporters, func(i, j int) bool { return exporters[i].target < exporters[j].target })
+
+	now := h.now()
+	var wg sync.WaitGroup
+	for _, de := range exporters {
+		g, _ := de.gatherer(now, h.staleAfter)
+		mfs, err := g.Gather()
+		if err != nil {
+			continue
+		}
+		for _, s := range h.sinks {
+			wg.Add(1)
+			go func(s namedSink, target string) {
+				defer wg.Done()
+				if err := s.sink.Write(ctx, target, now, mfs); err != nil {
+					h.metrics.sinkErrors.WithLabelValues(s.name).Inc()
+				}
+			}(s, de.target)
+		}
+	}
+	wg.Wait()
+}
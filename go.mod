@@ -3,14 +3,22 @@ module github.com/cfunkhouser/kasa
 go 1.16
 
 require (
+	github.com/brutella/hc v1.2.4
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/go-kit/kit v0.10.0
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.4
 	github.com/google/go-cmp v0.5.5
 	github.com/mitchellh/mapstructure v1.4.1
 	github.com/prometheus/client_golang v1.10.0
-	github.com/prometheus/common v0.23.0 // indirect
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.23.0
+	github.com/prometheus/exporter-toolkit v0.5.1
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/sys v0.0.0-20210503173754-0981d6026fa6 // indirect
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.4.0
 )
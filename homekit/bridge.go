@@ -0,0 +1,311 @@
+// Package homekit exposes Kasa devices as HomeKit accessories behind a bridge.
+// Plugs are outlets, wall switches are switches and smart bulbs are
+// lightbulbs. Accessory state is kept in sync with the devices by polling.
+package homekit
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// DefaultTimeout of requests to devices.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultName of the bridge accessory.
+const DefaultName = "Kasa Bridge"
+
+// device exposed as an accessory.
+type device struct {
+	acc   *accessory.Accessory
+	raddr *net.UDPAddr
+	// bulb devices are controlled with light states, others with their relay.
+	bulb bool
+
+	on         *characteristic.On
+	brightness *characteristic.Brightness
+	hue        *characteristic.Hue
+	saturation *characteristic.Saturation
+}
+
+// Bridge of Kasa devices to HomeKit.
+type Bridge struct {
+	inv         *inventory.Inventory
+	bridge      *accessory.Bridge
+	laddr       *net.UDPAddr
+	timeout     time.Duration
+	handleError func(error)
+
+	mu sync.Mutex
+	// devices exposed, by inventory.Key.
+	devices map[string]*device
+	// ignored devices found after Setup, by inventory.Key.
+	ignored map[string]bool
+}
+
+type Option func(*Bridge)
+
+// WithLocalAddr from which requests are sent to devices.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(b *Bridge) {
+		b.laddr = laddr
+	}
+}
+
+// WithTimeout of requests to devices. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(b *Bridge) {
+		b.timeout = timeout
+	}
+}
+
+// WithErrorHandler called with errors polling and controlling devices, none of
+// which stop the Bridge.
+func WithErrorHandler(handle func(error)) Option {
+	return func(b *Bridge) {
+		b.handleError = handle
+	}
+}
+
+// WithName of the bridge accessory, as shown in the Home app. Defaults to
+// DefaultName.
+func WithName(name string) Option {
+	return func(b *Bridge) {
+		b.bridge.Info.Name.SetValue(name)
+	}
+}
+
+// New Bridge of devices found using discover.
+func New(discover inventory.DiscoverFunc, opts ...Option) *Bridge {
+	b := &Bridge{
+		inv: inventory.New(discover, inventory.DiffOptions{}),
+		bridge: accessory.NewBridge(accessory.Info{
+			Name:         DefaultName,
+			Manufacturer: "cfunkhouser/kasa",
+			ID:           1,
+		}),
+		timeout:     DefaultTimeout,
+		handleError: func(error) {},
+		devices:     make(map[string]*device),
+		ignored:     make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Accessory of the bridge itself.
+func (b *Bridge) Accessory() *accessory.Accessory {
+	return b.bridge.Accessory
+}
+
+// Setup polls devices once, and returns an accessory for each supported
+// device found, sorted by inventory.Key. HomeKit bridges cannot add
+// accessories once started, so devices found later are ignored until the
+// bridge is set up again. Power strips are not supported.
+func (b *Bridge) Setup(ctx context.Context) ([]*accessory.Accessory, error) {
+	if _, err := b.inv.Poll(ctx); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var accs []*accessory.Accessory
+	for _, info := range b.inv.Devices() {
+		key := inventory.Key(info)
+		if len(info.Children) > 0 || info.RemoteAddress == nil {
+			b.ignored[key] = true
+			continue
+		}
+		d := b.newDevice(key, info)
+		b.devices[key] = d
+		accs = append(accs, d.acc)
+	}
+	return accs, nil
+}
+
+// accessoryID derived from the device's key, so that it is stable across
+// restarts and HomeKit keeps its room and scene assignments. ID 1 is the
+// bridge.
+func accessoryID(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	id := h.Sum64()
+	if id <= 1 {
+		id += 2
+	}
+	return id
+}
+
+// isSwitch reports whether the device is a wall switch, rather than a plug.
+func isSwitch(info *kasa.SystemInformation) bool {
+	return strings.HasPrefix(info.Model, "HS2") || strings.HasPrefix(info.Model, "KS")
+}
+
+func (b *Bridge) newDevice(key string, info *kasa.SystemInformation) *device {
+	ai := accessory.Info{
+		Name:             info.Alias,
+		SerialNumber:     info.DeviceID,
+		Manufacturer:     "TP-Link",
+		Model:            info.Model,
+		FirmwareRevision: info.SoftwareVersion,
+		ID:               accessoryID(key),
+	}
+	d := &device{raddr: info.RemoteAddress}
+	switch {
+	case info.LightState != nil:
+		d.bulb = true
+		d.acc = accessory.New(ai, accessory.TypeLightbulb)
+		svc := service.NewLightbulb()
+		d.on = svc.On
+		if info.IsDimmable != 0 || info.IsColor != 0 || info.IsVariableColorTemp != 0 {
+			d.brightness = characteristic.NewBrightness()
+			svc.AddCharacteristic(d.brightness.Characteristic)
+		}
+		if info.IsColor != 0 {
+			d.hue = characteristic.NewHue()
+			svc.AddCharacteristic(d.hue.Characteristic)
+			d.saturation = characteristic.NewSaturation()
+			svc.AddCharacteristic(d.saturation.Characteristic)
+		}
+		d.acc.AddService(svc.Service)
+	case isSwitch(info):
+		d.acc = accessory.New(ai, accessory.TypeSwitch)
+		svc := service.NewSwitch()
+		d.on = svc.On
+		d.acc.AddService(svc.Service)
+	default:
+		d.acc = accessory.New(ai, accessory.TypeOutlet)
+		svc := service.NewOutlet()
+		svc.OutletInUse.SetValue(true)
+		d.on = svc.On
+		d.acc.AddService(svc.Service)
+	}
+	d.update(info)
+
+	d.on.OnValueRemoteUpdate(func(on bool) {
+		if !d.bulb {
+			b.control(key, func(ctx context.Context, raddr *net.UDPAddr) error {
+				return kasa.SetRelayStateChecked(ctx, raddr, b.laddr, on)
+			})
+			return
+		}
+		b.light(key, kasa.LightState{OnOff: intPtr(boolInt(on))})
+	})
+	if d.brightness != nil {
+		d.brightness.OnValueRemoteUpdate(func(v int) {
+			b.light(key, kasa.LightState{OnOff: intPtr(1), Brightness: intPtr(v)})
+		})
+	}
+	if d.hue != nil {
+		// A color replaces any color temperature.
+		d.hue.OnValueRemoteUpdate(func(v float64) {
+			b.light(key, kasa.LightState{OnOff: intPtr(1), Hue: intPtr(int(v)), ColorTemp: intPtr(0)})
+		})
+		d.saturation.OnValueRemoteUpdate(func(v float64) {
+			b.light(key, kasa.LightState{OnOff: intPtr(1), Saturation: intPtr(int(v)), ColorTemp: intPtr(0)})
+		})
+	}
+	return d
+}
+
+func intPtr(v int) *int { return &v }
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// update the accessory's characteristics from the device's state. Values set
+// here are not sent back to the device.
+func (d *device) update(info *kasa.SystemInformation) {
+	if info.RemoteAddress != nil {
+		d.raddr = info.RemoteAddress
+	}
+	if !d.bulb {
+		d.on.SetValue(info.RelayState == 1)
+		return
+	}
+	ls := info.LightState
+	if ls == nil {
+		return
+	}
+	d.on.SetValue(ls.OnOff != nil && *ls.OnOff != 0)
+	if d.brightness != nil && ls.Brightness != nil {
+		d.brightness.SetValue(*ls.Brightness)
+	}
+	if d.hue != nil && ls.Hue != nil {
+		d.hue.SetValue(float64(*ls.Hue))
+	}
+	if d.saturation != nil && ls.Saturation != nil {
+		d.saturation.SetValue(float64(*ls.Saturation))
+	}
+}
+
+// control the device with the key, as requested by a HomeKit client.
+func (b *Bridge) control(key string, f func(ctx context.Context, raddr *net.UDPAddr) error) {
+	b.mu.Lock()
+	d, has := b.devices[key]
+	var raddr *net.UDPAddr
+	if has {
+		raddr = d.raddr
+	}
+	b.mu.Unlock()
+	if raddr == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	if err := f(ctx, raddr); err != nil {
+		b.handleError(fmt.Errorf("%v: %w", d.acc.Info.Name.GetValue(), err))
+	}
+}
+
+func (b *Bridge) light(key string, state kasa.LightState) {
+	b.control(key, func(ctx context.Context, raddr *net.UDPAddr) error {
+		return kasa.SetLightStateChecked(ctx, raddr, b.laddr, state)
+	})
+}
+
+// Run polls every interval until the context is canceled, updating the
+// accessories with the state of their devices.
+func (b *Bridge) Run(ctx context.Context, interval time.Duration) error {
+	return b.inv.Run(ctx, interval, func(_ []inventory.Event, err error) {
+		if err != nil {
+			b.handleError(err)
+			return
+		}
+		b.sync()
+	})
+}
+
+// sync accessories with the most recent poll.
+func (b *Bridge) sync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, info := range b.inv.Devices() {
+		key := inventory.Key(info)
+		d, has := b.devices[key]
+		if !has {
+			if !b.ignored[key] {
+				b.ignored[key] = true
+				b.handleError(fmt.Errorf("found %q (%v) after setup; restart to add it", info.Alias, key))
+			}
+			continue
+		}
+		d.update(info)
+	}
+}
@@ -0,0 +1,294 @@
+package homekit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func intp(v int) *int { return &v }
+
+// sysinfo of a device, without the defaults of kasatest.
+func sysinfo(id, alias, model string) kasatest.Option {
+	return kasatest.WithSysinfo(map[string]interface{}{
+		"err_code": 0,
+		"deviceId": id,
+		"alias":    alias,
+		"model":    model,
+	})
+}
+
+// testDevices starts an outlet, a wall switch, a smart bulb and a power strip,
+// by device ID.
+func testDevices(t *testing.T) map[string]*kasatest.Device {
+	t.Helper()
+	modem := kasatest.Start(t, sysinfo("modem", "ADSL Modem", "HS110(US)"), kasatest.WithRelay(true))
+	modem.Update(func(s map[string]interface{}) { s["sw_ver"] = "1.2.5" })
+	return map[string]*kasatest.Device{
+		"modem": modem,
+		"hall":  kasatest.Start(t, sysinfo("hall", "Hall Light", "HS200(US)"), kasatest.WithRelay(false)),
+		"lamp": kasatest.Start(t, sysinfo("lamp", "Lamp", ""), kasatest.WithLight(kasa.LightState{
+			OnOff: intp(1), Brightness: intp(40), Hue: intp(120), Saturation: intp(75),
+		})),
+		"strip": kasatest.Start(t, sysinfo("strip", "Power Strip", ""), kasatest.WithChildren(kasa.ChildInformation{ID: "strip00", Alias: "Desk"})),
+	}
+}
+
+// discover the devices.
+func discover(devices map[string]*kasatest.Device) inventory.DiscoverFunc {
+	var ds []*kasatest.Device
+	for _, d := range devices {
+		ds = append(ds, d)
+	}
+	return kasatest.Discover(ds...)
+}
+
+func testBridge(t *testing.T, devices map[string]*kasatest.Device) (*Bridge, map[string]*accessory.Accessory) {
+	t.Helper()
+	b := New(discover(devices), WithTimeout(kasatest.Timeout), WithErrorHandler(func(err error) {
+		t.Logf("bridge error: %v", err)
+	}))
+	accs, err := b.Setup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*accessory.Accessory)
+	for _, a := range accs {
+		byName[a.Info.Name.GetValue()] = a
+	}
+	return b, byName
+}
+
+func TestBridgeSetup(t *testing.T) {
+	_, accs := testBridge(t, testDevices(t))
+	type summary struct {
+		Type     accessory.AccessoryType
+		ID       uint64
+		Model    string
+		Firmware string
+		Chars    []string
+	}
+	got := make(map[string]summary)
+	for name, a := range accs {
+		s := summary{
+			Type:     a.Type,
+			ID:       a.ID,
+			Model:    a.Info.Model.GetValue(),
+			Firmware: a.Info.FirmwareRevision.GetValue(),
+		}
+		// The last service is the device's, after the accessory information.
+		svc := a.Services[len(a.Services)-1]
+		for _, c := range svc.Characteristics {
+			s.Chars = append(s.Chars, fmt.Sprintf("%v=%v", c.Type, c.Value))
+		}
+		got[name] = s
+	}
+	want := map[string]summary{
+		"ADSL Modem": {
+			Type:     accessory.TypeOutlet,
+			ID:       accessoryID("modem"),
+			Model:    "HS110(US)",
+			Firmware: "1.2.5",
+			Chars:    []string{characteristic.TypeOn + "=true", characteristic.TypeOutletInUse + "=true"},
+		},
+		"Hall Light": {
+			Type:     accessory.TypeSwitch,
+			ID:       accessoryID("hall"),
+			Model:    "HS200(US)",
+			Firmware: "undefined",
+			Chars:    []string{characteristic.TypeOn + "=false"},
+		},
+		"Lamp": {
+			Type:     accessory.TypeLightbulb,
+			ID:       accessoryID("lamp"),
+			Model:    "KL130(US)",
+			Firmware: "undefined",
+			Chars: []string{
+				characteristic.TypeOn + "=true",
+				characteristic.TypeBrightness + "=40",
+				characteristic.TypeHue + "=120",
+				characteristic.TypeSaturation + "=75",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("accessories mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// remote updates a characteristic as a HomeKit client would.
+func remote(t *testing.T, c *characteristic.Characteristic, v interface{}) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c.UpdateValueFromConnection(v, server)
+}
+
+func find(t *testing.T, a *accessory.Accessory, typ string) *characteristic.Characteristic {
+	t.Helper()
+	for _, svc := range a.Services {
+		for _, c := range svc.Characteristics {
+			if c.Type == typ {
+				return c
+			}
+		}
+	}
+	t.Fatalf("%v has no characteristic %v", a.Info.Name.GetValue(), typ)
+	return nil
+}
+
+func TestBridgeControl(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		acc       string
+		device    string
+		char      string
+		value     interface{}
+		wantRelay []bool
+		wantLight []kasa.LightState
+	}{
+		"outlet off": {
+			acc:       "ADSL Modem",
+			device:    "modem",
+			char:      characteristic.TypeOn,
+			value:     false,
+			wantRelay: []bool{false},
+		},
+		"switch on": {
+			acc:       "Hall Light",
+			device:    "hall",
+			char:      characteristic.TypeOn,
+			value:     true,
+			wantRelay: []bool{true},
+		},
+		"bulb off": {
+			acc:       "Lamp",
+			device:    "lamp",
+			char:      characteristic.TypeOn,
+			value:     false,
+			wantLight: []kasa.LightState{{OnOff: intp(0)}},
+		},
+		"bulb brightness": {
+			acc:       "Lamp",
+			device:    "lamp",
+			char:      characteristic.TypeBrightness,
+			value:     80,
+			wantLight: []kasa.LightState{{OnOff: intp(1), Brightness: intp(80)}},
+		},
+		"bulb hue": {
+			acc:       "Lamp",
+			device:    "lamp",
+			char:      characteristic.TypeHue,
+			value:     240.0,
+			wantLight: []kasa.LightState{{OnOff: intp(1), Hue: intp(240), ColorTemp: intp(0)}},
+		},
+		"bulb saturation": {
+			acc:       "Lamp",
+			device:    "lamp",
+			char:      characteristic.TypeSaturation,
+			value:     10.0,
+			wantLight: []kasa.LightState{{OnOff: intp(1), Saturation: intp(10), ColorTemp: intp(0)}},
+		},
+		"unchanged": {
+			acc:    "ADSL Modem",
+			device: "modem",
+			char:   characteristic.TypeOn,
+			value:  true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			devices := testDevices(t)
+			_, accs := testBridge(t, devices)
+			remote(t, find(t, accs[tc.acc], tc.char), tc.value)
+			d := devices[tc.device]
+			if diff := cmp.Diff(tc.wantRelay, d.RelayChanges()); diff != "" {
+				t.Errorf("relay changes mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantLight, d.LightChanges()); diff != "" {
+				t.Errorf("light changes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBridgeSync(t *testing.T) {
+	devices := testDevices(t)
+	// The hall light is found at a new address after the first poll.
+	moved := kasatest.Start(t, sysinfo("hall", "Hall Light", "HS200(US)"), kasatest.WithRelay(true),
+		kasatest.WithFaults(kasatest.Faults{Drop: true}))
+	devices["moved"] = moved
+	b, accs := testBridge(t, devices)
+	devices["modem"].Update(func(s map[string]interface{}) { s["relay_state"] = 0 })
+	devices["lamp"].SetLight(kasa.LightState{OnOff: intp(1), Brightness: intp(90), Hue: intp(120), Saturation: intp(75)})
+	devices["hall"].SetFaults(kasatest.Faults{Drop: true})
+	moved.SetFaults(kasatest.Faults{})
+
+	if _, err := b.inv.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b.sync()
+
+	if got := find(t, accs["ADSL Modem"], characteristic.TypeOn).GetValue(); got != false {
+		t.Errorf("modem on: got %v, want false", got)
+	}
+	if got := find(t, accs["Lamp"], characteristic.TypeBrightness).GetValue(); got != 90 {
+		t.Errorf("lamp brightness: got %v, want 90", got)
+	}
+	if got := find(t, accs["Hall Light"], characteristic.TypeOn).GetValue(); got != true {
+		t.Errorf("hall light on: got %v, want true", got)
+	}
+	for id, d := range devices {
+		if changes := d.RelayChanges(); len(changes) > 0 {
+			t.Errorf("sync sent relay changes to %v: %v", id, changes)
+		}
+		if changes := d.LightChanges(); len(changes) > 0 {
+			t.Errorf("sync sent light changes to %v: %v", id, changes)
+		}
+	}
+	// Changes are sent to the device's new address.
+	remote(t, find(t, accs["Hall Light"], characteristic.TypeOn), false)
+	if diff := cmp.Diff([]bool{false}, moved.RelayChanges()); diff != "" {
+		t.Errorf("relay changes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBridgeIgnoresLateDevices(t *testing.T) {
+	devices := testDevices(t)
+	kettle := kasatest.Start(t, sysinfo("kettle", "Kettle", "HS103(US)"), kasatest.WithFaults(kasatest.Faults{Drop: true}))
+	devices["kettle"] = kettle
+	var errs []error
+	b := New(discover(devices), WithTimeout(kasatest.Timeout), WithErrorHandler(func(err error) { errs = append(errs, err) }))
+	if _, err := b.Setup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	kettle.SetFaults(kasatest.Faults{})
+	for i := 0; i < 2; i++ {
+		if _, err := b.inv.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		b.sync()
+	}
+	if len(errs) != 1 {
+		t.Errorf("got errors %v, want one for the kettle", errs)
+	}
+}
+
+func TestAccessoryIDStable(t *testing.T) {
+	if accessoryID("modem") != accessoryID("modem") || accessoryID("modem") == accessoryID("lamp") {
+		t.Error("accessoryID(): want stable, distinct IDs")
+	}
+	for _, key := range []string{"", "modem", "10.24.6.14:9999"} {
+		if id := accessoryID(key); id <= 1 {
+			t.Errorf("accessoryID(%q) = %v, which conflicts with the bridge", key, id)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Package relay power-cycles the relays of Kasa devices.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+// Sleep for d, or until the context is done.
+func Sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// Cycle a relay off and back on, using set to set its state, and sleep to wait
+// off before turning it back on. The relay is turned back on even if the
+// context is done while it is off, rather than being left off.
+//
+// When the device does not answer the request to turn off, it may have turned
+// off all the same, so the cycle continues. The error returned then includes
+// both that of turning off and any of turning back on.
+func Cycle(ctx context.Context, off time.Duration, set func(ctx context.Context, on bool) error, sleep func(ctx context.Context, d time.Duration)) error {
+	offErr := set(ctx, false)
+	if offErr != nil && !unanswered(offErr) {
+		return offErr
+	}
+	sleep(ctx, off)
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+	err := set(ctx, true)
+	switch {
+	case offErr == nil:
+		return err
+	case err == nil:
+		return fmt.Errorf("%w, turned back on", offErr)
+	}
+	return fmt.Errorf("%w, turning back on: %v", offErr, err)
+}
+
+// unanswered reports whether err leaves unknown whether a request succeeded.
+func unanswered(err error) bool {
+	return errors.Is(err, kasa.ErrNoResponse) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+func TestCycle(t *testing.T) {
+	errBusy := errors.New("device busy")
+	errUnanswered := fmt.Errorf("%w from 10.24.6.14:9999", kasa.ErrNoResponse)
+	for tn, tc := range map[string]struct {
+		// offErr and onErr are returned when setting the relay off and on.
+		offErr, onErr error
+		// cancel the context while the relay is off.
+		cancel    bool
+		wantCalls []string
+		wantErr   error
+		// wantMsg is the error's message, if any.
+		wantMsg string
+	}{
+		"cycled": {
+			wantCalls: []string{"off", "sleep 15s", "on"},
+		},
+		"off fails": {
+			offErr:    errBusy,
+			wantCalls: []string{"off"},
+			wantErr:   errBusy,
+		},
+		"on fails": {
+			onErr:     errBusy,
+			wantCalls: []string{"off", "sleep 15s", "on"},
+			wantErr:   errBusy,
+		},
+		"off unanswered": {
+			offErr:    errUnanswered,
+			wantCalls: []string{"off", "sleep 15s", "on"},
+			wantErr:   kasa.ErrNoResponse,
+			wantMsg:   "no response from 10.24.6.14:9999, turned back on",
+		},
+		"off timed out": {
+			offErr:    context.DeadlineExceeded,
+			wantCalls: []string{"off", "sleep 15s", "on"},
+			wantErr:   context.DeadlineExceeded,
+		},
+		"off unanswered and on fails": {
+			offErr:    errUnanswered,
+			onErr:     errBusy,
+			wantCalls: []string{"off", "sleep 15s", "on"},
+			wantErr:   kasa.ErrNoResponse,
+			wantMsg:   "no response from 10.24.6.14:9999, turning back on: device busy",
+		},
+		"canceled while off": {
+			cancel:    true,
+			wantCalls: []string{"off", "sleep 15s", "on"},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var calls []string
+			set := func(ctx context.Context, on bool) error {
+				if ctx.Err() != nil {
+					t.Errorf("set(%v): context is done", on)
+				}
+				if on {
+					calls = append(calls, "on")
+					return tc.onErr
+				}
+				calls = append(calls, "off")
+				return tc.offErr
+			}
+			sleep := func(_ context.Context, d time.Duration) {
+				calls = append(calls, fmt.Sprintf("sleep %v", d))
+				if tc.cancel {
+					cancel()
+				}
+			}
+			err := Cycle(ctx, 15*time.Second, set, sleep)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Cycle(): got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantMsg != "" && (err == nil || err.Error() != tc.wantMsg) {
+				t.Errorf("Cycle(): got error %v, want %q", err, tc.wantMsg)
+			}
+			if diff := cmp.Diff(tc.wantCalls, calls); diff != "" {
+				t.Errorf("calls mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	Sleep(ctx, time.Hour)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Sleep() with a done context took %v", took)
+	}
+}
@@ -0,0 +1,139 @@
+package inventory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+// EventType describes the kind of change observed between two polls.
+type EventType string
+
+// Supported event types.
+const (
+	DeviceAppeared    EventType = "appeared"
+	DeviceDisappeared EventType = "disappeared"
+	AddressChanged    EventType = "address_changed"
+	AliasChanged      EventType = "alias_changed"
+	RelayChanged      EventType = "relay_changed"
+	RSSIDropped       EventType = "rssi_dropped"
+)
+
+// Event describing a change to a single device between two polls.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	DeviceID string    `json:"device_id"`
+	Alias    string    `json:"alias"`
+	Address  string    `json:"address"`
+	Old      string    `json:"old,omitempty"`
+	New      string    `json:"new,omitempty"`
+
+	// Info is the most recent system information for the device. For
+	// DeviceDisappeared events, this is the last known information.
+	Info *kasa.SystemInformation `json:"-"`
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%v %v (%v) %v", e.Time.UTC().Format(time.RFC3339), e.Alias, e.Address, e.Type)
+	if e.Old != "" || e.New != "" {
+		s += fmt.Sprintf(": %v -> %v", e.Old, e.New)
+	}
+	return s
+}
+
+// DefaultRSSIDrop is the drop in RSSI, in dBm, which triggers an RSSIDropped
+// event when DiffOptions does not specify one.
+const DefaultRSSIDrop = 10
+
+// DefaultMissedPolls is the number of polls in a row a device misses before
+// an Inventory reports it as having disappeared, when DiffOptions does not
+// specify one.
+const DefaultMissedPolls = 2
+
+// DiffOptions tune which changes are reported by Diff.
+type DiffOptions struct {
+	// RSSIDrop is the minimum decrease in RSSI between polls which is reported.
+	// If zero, DefaultRSSIDrop is used.
+	RSSIDrop int
+	// MissedPolls is the number of polls in a row a device must miss before
+	// an Inventory reports it as having disappeared, so that a single dropped
+	// response is not reported as the device disappearing and reappearing.
+	// Until then, the device is kept with its last known information. If
+	// zero, DefaultMissedPolls is used. Diff itself compares only two
+	// snapshots, so reports any device missing from the second.
+	MissedPolls int
+}
+
+func newEvent(t time.Time, typ EventType, info *kasa.SystemInformation) Event {
+	e := Event{
+		Time:     t,
+		Type:     typ,
+		DeviceID: info.DeviceID,
+		Alias:    info.Alias,
+		Info:     info,
+	}
+	if info.RemoteAddress != nil {
+		e.Address = info.RemoteAddress.String()
+	}
+	return e
+}
+
+func relayString(state int) string {
+	if state == 1 {
+		return "on"
+	}
+	return "off"
+}
+
+func addrString(info *kasa.SystemInformation) string {
+	if info.RemoteAddress == nil {
+		return ""
+	}
+	return info.RemoteAddress.String()
+}
+
+// Diff two snapshots taken at successive polls, returning events in a stable
+// order. The time t is attached to every event.
+func Diff(t time.Time, prev, cur Snapshot, opts DiffOptions) []Event {
+	rssiDrop := opts.RSSIDrop
+	if rssiDrop == 0 {
+		rssiDrop = DefaultRSSIDrop
+	}
+	var events []Event
+	for _, k := range prev.Keys() {
+		if _, has := cur[k]; !has {
+			events = append(events, newEvent(t, DeviceDisappeared, prev[k]))
+		}
+	}
+	for _, k := range cur.Keys() {
+		c := cur[k]
+		p, has := prev[k]
+		if !has {
+			events = append(events, newEvent(t, DeviceAppeared, c))
+			continue
+		}
+		if pa, ca := addrString(p), addrString(c); pa != ca {
+			e := newEvent(t, AddressChanged, c)
+			e.Old, e.New = pa, ca
+			events = append(events, e)
+		}
+		if p.Alias != c.Alias {
+			e := newEvent(t, AliasChanged, c)
+			e.Old, e.New = p.Alias, c.Alias
+			events = append(events, e)
+		}
+		if p.RelayState != c.RelayState {
+			e := newEvent(t, RelayChanged, c)
+			e.Old, e.New = relayString(p.RelayState), relayString(c.RelayState)
+			events = append(events, e)
+		}
+		if p.RSSI-c.RSSI >= rssiDrop {
+			e := newEvent(t, RSSIDropped, c)
+			e.Old, e.New = fmt.Sprint(p.RSSI), fmt.Sprint(c.RSSI)
+			events = append(events, e)
+		}
+	}
+	return events
+}
@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+func TestDiff(t *testing.T) {
+	at := time.Date(2017, time.August, 19, 22, 16, 0, 0, time.UTC)
+	modem := &kasa.SystemInformation{
+		RemoteAddress: &net.UDPAddr{IP: net.ParseIP("10.24.6.14"), Port: 9999},
+		Alias:         "ADSL Modem",
+		DeviceID:      "modem",
+		RelayState:    1,
+		RSSI:          -51,
+	}
+	modified := func(f func(*kasa.SystemInformation)) *kasa.SystemInformation {
+		c := *modem
+		f(&c)
+		return &c
+	}
+	for tn, tc := range map[string]struct {
+		prev, cur []*kasa.SystemInformation
+		want      []Event
+	}{
+		"no change": {
+			prev: []*kasa.SystemInformation{modem},
+			cur:  []*kasa.SystemInformation{modem},
+		},
+		"appeared": {
+			cur: []*kasa.SystemInformation{modem},
+			want: []Event{
+				{Time: at, Type: DeviceAppeared, DeviceID: "modem", Alias: "ADSL Modem", Address: "10.24.6.14:9999"},
+			},
+		},
+		"disappeared": {
+			prev: []*kasa.SystemInformation{modem},
+			want: []Event{
+				{Time: at, Type: DeviceDisappeared, DeviceID: "modem", Alias: "ADSL Modem", Address: "10.24.6.14:9999"},
+			},
+		},
+		"relay and alias": {
+			prev: []*kasa.SystemInformation{modem},
+			cur: []*kasa.SystemInformation{modified(func(i *kasa.SystemInformation) {
+				i.Alias = "Modem"
+				i.RelayState = 0
+			})},
+			want: []Event{
+				{Time: at, Type: AliasChanged, DeviceID: "modem", Alias: "Modem", Address: "10.24.6.14:9999", Old: "ADSL Modem", New: "Modem"},
+				{Time: at, Type: RelayChanged, DeviceID: "modem", Alias: "Modem", Address: "10.24.6.14:9999", Old: "on", New: "off"},
+			},
+		},
+		"small rssi drop": {
+			prev: []*kasa.SystemInformation{modem},
+			cur: []*kasa.SystemInformation{modified(func(i *kasa.SystemInformation) {
+				i.RSSI = -55
+			})},
+		},
+		"large rssi drop": {
+			prev: []*kasa.SystemInformation{modem},
+			cur: []*kasa.SystemInformation{modified(func(i *kasa.SystemInformation) {
+				i.RSSI = -70
+			})},
+			want: []Event{
+				{Time: at, Type: RSSIDropped, DeviceID: "modem", Alias: "ADSL Modem", Address: "10.24.6.14:9999", Old: "-51", New: "-70"},
+			},
+		},
+		"address": {
+			prev: []*kasa.SystemInformation{modem},
+			cur: []*kasa.SystemInformation{modified(func(i *kasa.SystemInformation) {
+				i.RemoteAddress = &net.UDPAddr{IP: net.ParseIP("10.24.6.20"), Port: 9999}
+			})},
+			want: []Event{
+				{Time: at, Type: AddressChanged, DeviceID: "modem", Alias: "ADSL Modem", Address: "10.24.6.20:9999", Old: "10.24.6.14:9999", New: "10.24.6.20:9999"},
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			got := Diff(at, NewSnapshot(tc.prev), NewSnapshot(tc.cur), DiffOptions{})
+			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreFields(Event{}, "Info")); diff != "" {
+				t.Errorf("Diff(): mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
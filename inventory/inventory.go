@@ -0,0 +1,189 @@
+// Package inventory keeps track of Kasa devices on the network over successive
+// polls, and reports how they change between polls.
+package inventory
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+// DiscoverFunc returns the current system information for a set of devices.
+type DiscoverFunc func(ctx context.Context) ([]*kasa.SystemInformation, error)
+
+// Broadcast discovers devices by sending a single get_sysinfo request to a
+// broadcast address.
+func Broadcast(baddr, laddr *net.UDPAddr) DiscoverFunc {
+	return func(ctx context.Context) ([]*kasa.SystemInformation, error) {
+		return kasa.GetSystemInformation(ctx, baddr, laddr, false)
+	}
+}
+
+// Static discovers a fixed list of devices, polling each concurrently. Devices
+// which do not respond are omitted from the results.
+func Static(daddrs []*net.UDPAddr, laddr *net.UDPAddr) DiscoverFunc {
+	return func(ctx context.Context) ([]*kasa.SystemInformation, error) {
+		results := make([][]*kasa.SystemInformation, len(daddrs))
+		var wg sync.WaitGroup
+		for i, daddr := range daddrs {
+			wg.Add(1)
+			go func(i int, daddr *net.UDPAddr) {
+				defer wg.Done()
+				infos, err := kasa.GetSystemInformation(ctx, daddr, laddr, true)
+				if err == nil {
+					results[i] = infos
+				}
+			}(i, daddr)
+		}
+		wg.Wait()
+		var infos []*kasa.SystemInformation
+		for _, r := range results {
+			infos = append(infos, r...)
+		}
+		return infos, nil
+	}
+}
+
+// Key identifying a device across polls. This is the device ID where known,
+// and the remote address otherwise.
+func Key(info *kasa.SystemInformation) string {
+	if info.DeviceID != "" {
+		return info.DeviceID
+	}
+	if info.RemoteAddress != nil {
+		return info.RemoteAddress.String()
+	}
+	return ""
+}
+
+// Snapshot of devices at a point in time, keyed by Key.
+type Snapshot map[string]*kasa.SystemInformation
+
+// NewSnapshot from a set of system information responses.
+func NewSnapshot(infos []*kasa.SystemInformation) Snapshot {
+	s := make(Snapshot, len(infos))
+	for _, info := range infos {
+		s[Key(info)] = info
+	}
+	return s
+}
+
+// Keys in the snapshot, sorted.
+func (s Snapshot) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Inventory of devices, updated by polling.
+type Inventory struct {
+	discover DiscoverFunc
+	opts     DiffOptions
+	now      func() time.Time
+
+	mu       sync.RWMutex
+	current  Snapshot
+	lastPoll time.Time
+	// missed is the number of polls in a row missed by each device in
+	// current which did not respond to the most recent poll.
+	missed map[string]int
+}
+
+// New Inventory which finds devices using discover.
+func New(discover DiscoverFunc, opts DiffOptions) *Inventory {
+	return &Inventory{
+		discover: discover,
+		opts:     opts,
+		now:      time.Now,
+		missed:   make(map[string]int),
+	}
+}
+
+// Poll devices once, update the inventory, and return events describing any
+// changes since the previous poll. The first poll reports every device as
+// having appeared. Devices are reported as having disappeared once they have
+// missed the number of polls in a row given by DiffOptions.MissedPolls.
+func (inv *Inventory) Poll(ctx context.Context) ([]Event, error) {
+	infos, err := inv.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cur := NewSnapshot(infos)
+	now := inv.now()
+	missedPolls := inv.opts.MissedPolls
+	if missedPolls == 0 {
+		missedPolls = DefaultMissedPolls
+	}
+
+	inv.mu.Lock()
+	prev := inv.current
+	for k, info := range prev {
+		if _, has := cur[k]; has {
+			delete(inv.missed, k)
+			continue
+		}
+		if inv.missed[k]++; inv.missed[k] < missedPolls {
+			cur[k] = info
+			continue
+		}
+		delete(inv.missed, k)
+	}
+	inv.current = cur
+	inv.lastPoll = now
+	inv.mu.Unlock()
+
+	return Diff(now, prev, cur, inv.opts), nil
+}
+
+// Run polls every interval until the context is canceled, calling handle with
+// any events from each poll. Poll errors are passed to handle as well, and do
+// not stop polling.
+func (inv *Inventory) Run(ctx context.Context, interval time.Duration, handle func([]Event, error)) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		events, err := inv.Poll(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		handle(events, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Devices from the most recent poll, sorted by Key.
+func (inv *Inventory) Devices() []*kasa.SystemInformation {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	var infos []*kasa.SystemInformation
+	for _, k := range inv.current.Keys() {
+		infos = append(infos, inv.current[k])
+	}
+	return infos
+}
+
+// Get the device with the given Key from the most recent poll.
+func (inv *Inventory) Get(key string) (*kasa.SystemInformation, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	info, has := inv.current[key]
+	return info, has
+}
+
+// LastPoll returns the time of the most recent successful poll.
+func (inv *Inventory) LastPoll() time.Time {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	return inv.lastPoll
+}
@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+func TestInventoryMissedPolls(t *testing.T) {
+	modem := &kasa.SystemInformation{
+		RemoteAddress: &net.UDPAddr{IP: net.ParseIP("10.24.6.14"), Port: 9999},
+		Alias:         "ADSL Modem",
+		DeviceID:      "modem",
+	}
+	// responded is whether the modem responds to each poll.
+	for tn, tc := range map[string]struct {
+		missedPolls int
+		responded   []bool
+		want        [][]EventType
+	}{
+		"dropped response": {
+			responded: []bool{true, false, true},
+			want:      [][]EventType{{DeviceAppeared}, nil, nil},
+		},
+		"disappeared": {
+			responded: []bool{true, false, false, false, true},
+			want:      [][]EventType{{DeviceAppeared}, nil, {DeviceDisappeared}, nil, {DeviceAppeared}},
+		},
+		"missed count resets": {
+			responded: []bool{true, false, true, false, true},
+			want:      [][]EventType{{DeviceAppeared}, nil, nil, nil, nil},
+		},
+		"first miss": {
+			missedPolls: 1,
+			responded:   []bool{true, false, true},
+			want:        [][]EventType{{DeviceAppeared}, {DeviceDisappeared}, {DeviceAppeared}},
+		},
+		"three misses": {
+			missedPolls: 3,
+			responded:   []bool{true, false, false, false},
+			want:        [][]EventType{{DeviceAppeared}, nil, nil, {DeviceDisappeared}},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			poll := 0
+			inv := New(func(context.Context) ([]*kasa.SystemInformation, error) {
+				if tc.responded[poll] {
+					return []*kasa.SystemInformation{modem}, nil
+				}
+				return nil, nil
+			}, DiffOptions{MissedPolls: tc.missedPolls})
+			inv.now = func() time.Time { return time.Time{} }
+			var got [][]EventType
+			for poll = range tc.responded {
+				events, err := inv.Poll(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				var types []EventType
+				for _, e := range events {
+					types = append(types, e.Type)
+				}
+				got = append(got, types)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -59,11 +59,13 @@ func ParseAddr(addr string) (*net.UDPAddr, error) {
 	}, nil
 }
 
-// APIMessage wraps requests to and responses from Kasa devices. Does not
-// currently support emeter details.
+// APIMessage wraps requests to and responses from Kasa devices.
 type APIMessage struct {
-	RemoteAddress *net.UDPAddr           `json:"-"`
-	System        map[string]interface{} `json:"system"`
+	RemoteAddress   *net.UDPAddr           `json:"-"`
+	System          map[string]interface{} `json:"system,omitempty"`
+	Emeter          map[string]interface{} `json:"emeter,omitempty"`
+	Time            map[string]interface{} `json:"time,omitempty"`
+	LightingService map[string]interface{} `json:"smartlife.iot.smartbulb.lightingservice,omitempty"`
 }
 
 // Encode an API message into the wire format expected by Kasa devices. This is
@@ -90,7 +92,27 @@ func (p *APIMessage) GetModule(module string) (map[string]interface{}, bool) {
 	if p == nil {
 		return nil, false
 	}
-	r, has := p.System[module]
+	return getModule(p.System, module)
+}
+
+// GetEmeterModule from the APIMessage. This is the same as GetModule, but for
+// the emeter object of the Kasa API message, for example get_realtime.
+func (p *APIMessage) GetEmeterModule(module string) (map[string]interface{}, bool) {
+	if p == nil {
+		return nil, false
+	}
+	return getModule(p.Emeter, module)
+}
+
+// getModule from an object of an APIMessage. Devices which do not support the
+// object report an error in place of the whole object, for example
+// {"emeter":{"err_code":-1,"err_msg":"module not support"}}, which is returned
+// as the response of each of its modules.
+func getModule(obj map[string]interface{}, module string) (map[string]interface{}, bool) {
+	if _, failed := obj["err_code"]; failed {
+		return obj, true
+	}
+	r, has := obj[module]
 	if !has {
 		return nil, false
 	}
@@ -98,17 +120,57 @@ func (p *APIMessage) GetModule(module string) (map[string]interface{}, bool) {
 	return mod, ok
 }
 
+// GetTimeModule from the APIMessage. This is the same as GetModule, but for the
+// time object of the Kasa API message, for example get_time.
+func (p *APIMessage) GetTimeModule(module string) (map[string]interface{}, bool) {
+	if p == nil {
+		return nil, false
+	}
+	return getModule(p.Time, module)
+}
+
 // DecodeAPIMessage from the "encrypted" Kasa wire format.
 func DecodeAPIMessage(raw []byte, message *APIMessage) error {
 	return json.Unmarshal(decrypt(raw), message)
 }
 
-// receive attempts to read APIMessages from a UDP connection.
+// ErrMalformedResponse is returned when a response from a Kasa device can not
+// be decoded.
+var ErrMalformedResponse = errors.New("malformed response")
+
+// malformedError is a response to a request which can not be decoded. It
+// matches both ErrMalformedResponse and the sentinel error for the request.
+type malformedError struct {
+	op     error
+	detail string
+}
+
+func (e *malformedError) Error() string {
+	return fmt.Sprintf("%v: %v: %v", e.op, ErrMalformedResponse, e.detail)
+}
+
+func (e *malformedError) Is(target error) bool {
+	return target == ErrMalformedResponse || target == e.op
+}
+
+// malformed returns an error for a response to op which can not be decoded.
+func malformed(op error, detail interface{}) error {
+	return &malformedError{op: op, detail: fmt.Sprint(detail)}
+}
+
+// receive attempts to read APIMessages from a UDP connection, until no message
+// has arrived for a second or the context deadline passes. If messages were
+// received but none could be decoded, ErrMalformedResponse is returned.
 func receive(ctx context.Context, conn *net.UDPConn) ([]*APIMessage, error) {
 	var replies []*APIMessage
+	var decodeErr error
 	buf := make([]byte, 2048)
 	for {
-		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		deadline := time.Now().Add(time.Second)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
 			return replies, err
 		}
 
@@ -116,6 +178,9 @@ func receive(ctx context.Context, conn *net.UDPConn) ([]*APIMessage, error) {
 		if err != nil {
 			if nerr, ok := err.(net.Error); ok {
 				if nerr.Timeout() {
+					if len(replies) == 0 && decodeErr != nil {
+						return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, decodeErr)
+					}
 					return replies, nil
 				}
 			}
@@ -124,6 +189,7 @@ func receive(ctx context.Context, conn *net.UDPConn) ([]*APIMessage, error) {
 
 		var reply APIMessage
 		if err := DecodeAPIMessage(buf[:n], &reply); err != nil {
+			decodeErr = err
 			continue
 		}
 		reply.RemoteAddress = raddr
@@ -144,6 +210,7 @@ func Send(ctx context.Context, message *APIMessage, raddr, laddr *net.UDPAddr, e
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
 	if _, err = conn.WriteToUDP(msg, raddr); err != nil {
 		return nil, err
 	}
@@ -153,6 +220,38 @@ func Send(ctx context.Context, message *APIMessage, raddr, laddr *net.UDPAddr, e
 	return receive(ctx, conn)
 }
 
+// ErrNoResponse is returned when a device does not respond to a request before
+// the deadline.
+var ErrNoResponse = errors.New("no response")
+
+// DeviceError is an error code reported by a Kasa device in response to a
+// request. Op is the sentinel error for the failed request, for example
+// ErrGetSysinfoFailed, and is matched by errors.Is.
+type DeviceError struct {
+	Op      error
+	Code    int
+	Message string
+}
+
+func (e *DeviceError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%v: error code %v: %v", e.Op, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%v: error code %v", e.Op, e.Code)
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Op
+}
+
+// deviceErr returns a DeviceError for a non-zero error code, otherwise nil.
+func deviceErr(op error, code int, msg string) error {
+	if code == 0 {
+		return nil
+	}
+	return &DeviceError{Op: op, Code: code, Message: msg}
+}
+
 // ErrGetSysinfoFailed is returned by a Kasa device when get_sysinfo fails.
 var ErrGetSysinfoFailed = errors.New("get_sysinfo failed")
 
@@ -184,30 +283,42 @@ type SystemInformation struct {
 	Status          string `json:"status,omitempty" mapstructure:"status"`
 	Updating        int    `json:"updating,omitempty" mapstructure:"updating"`
 
+	// Capabilities of smart bulbs.
+	IsDimmable          int `json:"is_dimmable,omitempty" mapstructure:"is_dimmable"`
+	IsColor             int `json:"is_color,omitempty" mapstructure:"is_color"`
+	IsVariableColorTemp int `json:"is_variable_color_temp,omitempty" mapstructure:"is_variable_color_temp"`
+
+	// Children are the individually controlled outlets of power strips.
+	Children []ChildInformation `json:"children,omitempty" mapstructure:"children"`
+	// LightState is reported by smart bulbs.
+	LightState *LightState `json:"light_state,omitempty" mapstructure:"light_state"`
+
 	NextAction *struct {
 		Type int `json:"type,omitempty"`
 	} `json:"next_action,omitempty"`
 }
 
+// ChildInformation describes an outlet of a Kasa power strip.
+type ChildInformation struct {
+	ID     string `json:"id,omitempty" mapstructure:"id"`
+	Alias  string `json:"alias,omitempty" mapstructure:"alias"`
+	State  int    `json:"state,omitempty" mapstructure:"state"`
+	OnTime int    `json:"on_time,omitempty" mapstructure:"on_time"`
+}
+
 // Err converts any error details in a get_sysinfo response to a Go error.
 func (p SystemInformation) Err() error {
-	if code := p.ErrorCode; code != 0 {
-		if em := p.Error; em != "" {
-			return fmt.Errorf("%w: error code %v: %v", ErrGetSysinfoFailed, code, em)
-		}
-		return fmt.Errorf("%w: error code %v", ErrGetSysinfoFailed, code)
-	}
-	return nil
+	return deviceErr(ErrGetSysinfoFailed, p.ErrorCode, p.Error)
 }
 
 // FromAPIMessage populates a SystemInformation from an APIMessage.
 func (i *SystemInformation) FromAPIMessage(msg *APIMessage) error {
 	mr, ok := msg.GetModule("get_sysinfo")
 	if !ok {
-		return fmt.Errorf("%w: response did not contain get_sysinfo payload", ErrGetSysinfoFailed)
+		return malformed(ErrGetSysinfoFailed, "response did not contain get_sysinfo payload")
 	}
 	if err := mapstructure.Decode(mr, i); err != nil {
-		return err
+		return malformed(ErrGetSysinfoFailed, err)
 	}
 	i.RemoteAddress = msg.RemoteAddress
 	return nil
@@ -239,6 +350,22 @@ func GetSystemInformation(ctx context.Context, raddr, laddr *net.UDPAddr, allOrN
 	return r, nil
 }
 
+// GetDeviceSystemInformation sends a get_sysinfo request to a single device,
+// and returns its response, or any error it reports.
+func GetDeviceSystemInformation(ctx context.Context, raddr, laddr *net.UDPAddr) (*SystemInformation, error) {
+	infos, err := GetSystemInformation(ctx, raddr, laddr, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("%w from %v", ErrNoResponse, raddr)
+	}
+	if err := infos[0].Err(); err != nil {
+		return nil, err
+	}
+	return infos[0], nil
+}
+
 type setRelayStateRequest struct {
 	State bool `json:"state" mapstructure:"state"`
 }
@@ -255,3 +382,237 @@ func SetRelayState(ctx context.Context, raddr, laddr *net.UDPAddr, state bool) e
 	_, err := Send(ctx, message, raddr, laddr, false)
 	return err
 }
+
+// ErrSetRelayStateFailed is returned by a Kasa device when set_relay_state
+// fails.
+var ErrSetRelayStateFailed = errors.New("set_relay_state failed")
+
+// SetRelayStateChecked is like SetRelayState, but waits for the device to
+// respond, and returns any error it reports.
+func SetRelayStateChecked(ctx context.Context, raddr, laddr *net.UDPAddr, state bool) error {
+	message := &APIMessage{
+		System: map[string]interface{}{
+			"set_relay_state": setRelayStateRequest{
+				State: state,
+			},
+		},
+	}
+	replies, err := Send(ctx, message, raddr, laddr, true)
+	if err != nil {
+		return err
+	}
+	if len(replies) == 0 {
+		return fmt.Errorf("%w from %v", ErrNoResponse, raddr)
+	}
+	mr, ok := replies[0].GetModule("set_relay_state")
+	if !ok {
+		return malformed(ErrSetRelayStateFailed, "response did not contain set_relay_state payload")
+	}
+	return responseErr(ErrSetRelayStateFailed, mr)
+}
+
+// responseErr returns the error reported in the response to a request which
+// returns nothing else.
+func responseErr(op error, mr map[string]interface{}) error {
+	var r struct {
+		ErrorCode int    `mapstructure:"err_code"`
+		Error     string `mapstructure:"err_msg"`
+	}
+	if err := mapstructure.Decode(mr, &r); err != nil {
+		return malformed(op, err)
+	}
+	return deviceErr(op, r.ErrorCode, r.Error)
+}
+
+// LightState describes the desired state of a Kasa smart bulb. Nil fields are
+// left unchanged by the device.
+type LightState struct {
+	OnOff            *int `json:"on_off,omitempty" mapstructure:"on_off"`
+	Brightness       *int `json:"brightness,omitempty" mapstructure:"brightness"`
+	Hue              *int `json:"hue,omitempty" mapstructure:"hue"`
+	Saturation       *int `json:"saturation,omitempty" mapstructure:"saturation"`
+	ColorTemp        *int `json:"color_temp,omitempty" mapstructure:"color_temp"`
+	TransitionPeriod *int `json:"transition_period,omitempty" mapstructure:"transition_period"`
+}
+
+// SetLightState on the smart bulb at the specified address.
+func SetLightState(ctx context.Context, raddr, laddr *net.UDPAddr, state LightState) error {
+	message := &APIMessage{
+		LightingService: map[string]interface{}{
+			"transition_light_state": state,
+		},
+	}
+	_, err := Send(ctx, message, raddr, laddr, false)
+	return err
+}
+
+// ErrSetLightStateFailed is returned by a Kasa smart bulb when
+// transition_light_state fails.
+var ErrSetLightStateFailed = errors.New("transition_light_state failed")
+
+// SetLightStateChecked is like SetLightState, but waits for the bulb to
+// respond, and returns any error it reports.
+func SetLightStateChecked(ctx context.Context, raddr, laddr *net.UDPAddr, state LightState) error {
+	message := &APIMessage{
+		LightingService: map[string]interface{}{
+			"transition_light_state": state,
+		},
+	}
+	replies, err := Send(ctx, message, raddr, laddr, true)
+	if err != nil {
+		return err
+	}
+	if len(replies) == 0 {
+		return fmt.Errorf("%w from %v", ErrNoResponse, raddr)
+	}
+	mr, ok := getModule(replies[0].LightingService, "transition_light_state")
+	if !ok {
+		return malformed(ErrSetLightStateFailed, "response did not contain transition_light_state payload")
+	}
+	return responseErr(ErrSetLightStateFailed, mr)
+}
+
+// ErrGetRealtimeFailed is returned by a Kasa device when emeter get_realtime
+// fails, including when the device has no emeter.
+var ErrGetRealtimeFailed = errors.New("get_realtime failed")
+
+// EmeterRealtime gives structure to the response to emeter get_realtime
+// requests. Devices report readings in different units depending on their
+// firmware; they are normalized to amperes, volts, watts and watt-hours.
+type EmeterRealtime struct {
+	RemoteAddress *net.UDPAddr `json:"-"`
+
+	ErrorCode int    `json:"err_code,omitempty"`
+	Error     string `json:"err_msg,omitempty"`
+
+	Current float64 `json:"current"`
+	Voltage float64 `json:"voltage"`
+	Power   float64 `json:"power"`
+	Total   float64 `json:"total_wh"`
+}
+
+// Err converts any error details in a get_realtime response to a Go error.
+func (e EmeterRealtime) Err() error {
+	return deviceErr(ErrGetRealtimeFailed, e.ErrorCode, e.Error)
+}
+
+// emeterReadings as reported by the device, in either of the units used by
+// different firmware versions.
+type emeterReadings struct {
+	ErrorCode int    `mapstructure:"err_code"`
+	Error     string `mapstructure:"err_msg"`
+
+	Current *float64 `mapstructure:"current"`
+	Voltage *float64 `mapstructure:"voltage"`
+	Power   *float64 `mapstructure:"power"`
+	Total   *float64 `mapstructure:"total"`
+
+	CurrentMA *float64 `mapstructure:"current_ma"`
+	VoltageMV *float64 `mapstructure:"voltage_mv"`
+	PowerMW   *float64 `mapstructure:"power_mw"`
+	TotalWH   *float64 `mapstructure:"total_wh"`
+}
+
+// pick v if the device reported it, otherwise the scaled alternative.
+func pick(v *float64, scaled *float64, scale float64) float64 {
+	if v != nil {
+		return *v
+	}
+	if scaled != nil {
+		return *scaled * scale
+	}
+	return 0
+}
+
+// FromAPIMessage populates an EmeterRealtime from an APIMessage.
+func (e *EmeterRealtime) FromAPIMessage(msg *APIMessage) error {
+	mr, ok := msg.GetEmeterModule("get_realtime")
+	if !ok {
+		return malformed(ErrGetRealtimeFailed, "response did not contain get_realtime payload")
+	}
+	var r emeterReadings
+	if err := mapstructure.Decode(mr, &r); err != nil {
+		return malformed(ErrGetRealtimeFailed, err)
+	}
+	*e = EmeterRealtime{
+		RemoteAddress: msg.RemoteAddress,
+		ErrorCode:     r.ErrorCode,
+		Error:         r.Error,
+		Current:       pick(r.Current, r.CurrentMA, 1e-3),
+		Voltage:       pick(r.Voltage, r.VoltageMV, 1e-3),
+		Power:         pick(r.Power, r.PowerMW, 1e-3),
+		Total:         pick(r.TotalWH, r.Total, 1e3),
+	}
+	return nil
+}
+
+// GetEmeterRealtime sends an emeter get_realtime request to a single device,
+// and returns its response.
+func GetEmeterRealtime(ctx context.Context, raddr, laddr *net.UDPAddr) (*EmeterRealtime, error) {
+	message := &APIMessage{
+		Emeter: map[string]interface{}{
+			"get_realtime": nil,
+		},
+	}
+	replies, err := Send(ctx, message, raddr, laddr, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) == 0 {
+		return nil, fmt.Errorf("%w from %v", ErrNoResponse, raddr)
+	}
+	var e EmeterRealtime
+	if err := e.FromAPIMessage(replies[0]); err != nil {
+		return nil, err
+	}
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ErrGetTimeFailed is returned by a Kasa device when time get_time fails,
+// including when the device does not support the time module.
+var ErrGetTimeFailed = errors.New("get_time failed")
+
+// DeviceTime gives structure to the response to time get_time requests. Kasa
+// devices report their local wall clock time, without a time zone. Devices
+// which have just booted report a default time until they reach an NTP server.
+type DeviceTime struct {
+	RemoteAddress *net.UDPAddr `json:"-"`
+
+	ErrorCode int    `json:"err_code,omitempty" mapstructure:"err_code"`
+	Error     string `json:"err_msg,omitempty" mapstructure:"err_msg"`
+
+	Year   int `json:"year" mapstructure:"year"`
+	Month  int `json:"month" mapstructure:"month"`
+	Day    int `json:"mday" mapstructure:"mday"`
+	Hour   int `json:"hour" mapstructure:"hour"`
+	Minute int `json:"min" mapstructure:"min"`
+	Second int `json:"sec" mapstructure:"sec"`
+}
+
+// Err converts any error details in a get_time response to a Go error.
+func (t DeviceTime) Err() error {
+	return deviceErr(ErrGetTimeFailed, t.ErrorCode, t.Error)
+}
+
+// Time reported by the device. Since the device does not report its time zone,
+// the result is in UTC, and only meaningful compared to other times reported
+// by the same device.
+func (t DeviceTime) Time() time.Time {
+	return time.Date(t.Year, time.Month(t.Month), t.Day, t.Hour, t.Minute, t.Second, 0, time.UTC)
+}
+
+// FromAPIMessage populates a DeviceTime from an APIMessage.
+func (t *DeviceTime) FromAPIMessage(msg *APIMessage) error {
+	mr, ok := msg.GetTimeModule("get_time")
+	if !ok {
+		return malformed(ErrGetTimeFailed, "response did not contain get_time payload")
+	}
+	if err := mapstructure.Decode(mr, t); err != nil {
+		return malformed(ErrGetTimeFailed, err)
+	}
+	t.RemoteAddress = msg.RemoteAddress
+	return nil
+}
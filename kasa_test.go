@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -317,6 +318,19 @@ func TestAPIMessageGetModule(t *testing.T) {
 				},
 			},
 		},
+		"object not supported": {
+			want: map[string]interface{}{
+				"err_code": -1,
+				"err_msg":  "module not support",
+			},
+			wantOK: true,
+			msg: &APIMessage{
+				System: map[string]interface{}{
+					"err_code": -1,
+					"err_msg":  "module not support",
+				},
+			},
+		},
 	} {
 		t.Run(tn, func(t *testing.T) {
 			got, ok := tc.msg.GetModule("test_module")
@@ -451,6 +465,16 @@ func TestSystemInformationFromAPIMessage(t *testing.T) {
 				},
 			},
 		},
+		"undecodable get_sysinfo module": {
+			wantErr: true,
+			msg: &APIMessage{
+				System: map[string]interface{}{
+					"get_sysinfo": map[string]interface{}{
+						"relay_state": "on",
+					},
+				},
+			},
+		},
 		"valid": {
 			want: SystemInformation{
 				RemoteAddress: &net.UDPAddr{
@@ -476,12 +500,198 @@ func TestSystemInformationFromAPIMessage(t *testing.T) {
 	} {
 		t.Run(tn, func(t *testing.T) {
 			var got SystemInformation
-			if err := got.FromAPIMessage(tc.msg); (err != nil) != tc.wantErr {
+			err := got.FromAPIMessage(tc.msg)
+			if (err != nil) != tc.wantErr {
 				t.Errorf("FromAPIMessage(): got unexpected error: %v", err)
 			}
+			if err != nil && (!errors.Is(err, ErrGetSysinfoFailed) || !errors.Is(err, ErrMalformedResponse)) {
+				t.Errorf("FromAPIMessage(): got %v, want ErrGetSysinfoFailed and ErrMalformedResponse", err)
+			}
+			if tc.wantErr {
+				return
+			}
 			if diff := cmp.Diff(tc.want, got); diff != "" {
 				t.Errorf("FromAPIMessage(): mismatch (-got +want):\n%v", diff)
 			}
 		})
 	}
 }
+
+func TestSystemInformationFromAPIMessageChildren(t *testing.T) {
+	brightness := 40
+	onOff := 1
+	msg := &APIMessage{
+		System: map[string]interface{}{
+			"get_sysinfo": map[string]interface{}{
+				"alias": "Power Strip",
+				"children": []interface{}{
+					map[string]interface{}{"id": "00", "alias": "Lamp", "state": 1, "on_time": 120},
+					map[string]interface{}{"id": "01", "alias": "Fan", "state": 0},
+				},
+				"light_state": map[string]interface{}{"on_off": 1, "brightness": 40},
+			},
+		},
+	}
+	want := SystemInformation{
+		Alias: "Power Strip",
+		Children: []ChildInformation{
+			{ID: "00", Alias: "Lamp", State: 1, OnTime: 120},
+			{ID: "01", Alias: "Fan"},
+		},
+		LightState: &LightState{OnOff: &onOff, Brightness: &brightness},
+	}
+	var got SystemInformation
+	if err := got.FromAPIMessage(msg); err != nil {
+		t.Fatalf("FromAPIMessage(): got unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FromAPIMessage(): mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestEmeterRealtimeFromAPIMessage(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		want    EmeterRealtime
+		wantErr bool
+		msg     *APIMessage
+	}{
+		"no get_realtime module": {
+			wantErr: true,
+			msg: &APIMessage{
+				System: map[string]interface{}{
+					"get_sysinfo": map[string]interface{}{},
+				},
+			},
+		},
+		"v1 units": {
+			want: EmeterRealtime{Current: 0.5, Voltage: 120, Power: 60, Total: 1500},
+			msg: &APIMessage{
+				Emeter: map[string]interface{}{
+					"get_realtime": map[string]interface{}{
+						"current": 0.5,
+						"voltage": 120,
+						"power":   60,
+						"total":   1.5,
+					},
+				},
+			},
+		},
+		"v2 units": {
+			want: EmeterRealtime{Current: 0.5, Voltage: 120, Power: 60, Total: 1500},
+			msg: &APIMessage{
+				Emeter: map[string]interface{}{
+					"get_realtime": map[string]interface{}{
+						"current_ma": 500,
+						"voltage_mv": 120000,
+						"power_mw":   60000,
+						"total_wh":   1500,
+					},
+				},
+			},
+		},
+		"not supported": {
+			want: EmeterRealtime{ErrorCode: -1, Error: "module not support"},
+			msg: &APIMessage{
+				Emeter: map[string]interface{}{
+					"get_realtime": map[string]interface{}{
+						"err_code": -1,
+						"err_msg":  "module not support",
+					},
+				},
+			},
+		},
+		// As reported by plugs without an energy meter, such as the HS100.
+		"module not supported": {
+			want: EmeterRealtime{ErrorCode: -1, Error: "module not support"},
+			msg: &APIMessage{
+				Emeter: map[string]interface{}{
+					"err_code": -1,
+					"err_msg":  "module not support",
+				},
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			var got EmeterRealtime
+			if err := got.FromAPIMessage(tc.msg); (err != nil) != tc.wantErr {
+				t.Errorf("FromAPIMessage(): got unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("FromAPIMessage(): mismatch (-want +got):\n%v", diff)
+			}
+			var de *DeviceError
+			if err := got.Err(); got.ErrorCode != 0 && (!errors.As(err, &de) || !errors.Is(err, ErrGetRealtimeFailed)) {
+				t.Errorf("Err(): got %v, want a DeviceError for get_realtime", err)
+			}
+		})
+	}
+}
+
+func TestDeviceTimeFromAPIMessage(t *testing.T) {
+	msg := &APIMessage{
+		Time: map[string]interface{}{
+			"get_time": map[string]interface{}{
+				"year": 2017, "month": 8, "mday": 19, "hour": 22, "min": 16, "sec": 5,
+			},
+		},
+	}
+	var got DeviceTime
+	if err := got.FromAPIMessage(msg); err != nil {
+		t.Fatalf("FromAPIMessage(): got unexpected error: %v", err)
+	}
+	if err := got.Err(); err != nil {
+		t.Errorf("Err(): got unexpected error: %v", err)
+	}
+	if want := time.Date(2017, time.August, 19, 22, 16, 5, 0, time.UTC); !got.Time().Equal(want) {
+		t.Errorf("Time(): got %v, want %v", got.Time(), want)
+	}
+
+	for _, unsupported := range []map[string]interface{}{
+		{"get_time": map[string]interface{}{"err_code": -1, "err_msg": "module not support"}},
+		{"err_code": -1, "err_msg": "module not support"},
+	} {
+		msg.Time = unsupported
+		got = DeviceTime{}
+		if err := got.FromAPIMessage(msg); err != nil {
+			t.Fatalf("FromAPIMessage(%v): got unexpected error: %v", unsupported, err)
+		}
+		if err := got.Err(); !errors.Is(err, ErrGetTimeFailed) {
+			t.Errorf("Err() of %v: got %v, want ErrGetTimeFailed", unsupported, err)
+		}
+	}
+}
+
+func TestResponseErr(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		mr   map[string]interface{}
+		want *DeviceError
+	}{
+		"success": {
+			mr: map[string]interface{}{"err_code": 0},
+		},
+		"error code": {
+			mr:   map[string]interface{}{"err_code": -2, "err_msg": "member not support"},
+			want: &DeviceError{Op: ErrSetRelayStateFailed, Code: -2, Message: "member not support"},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			err := responseErr(ErrSetRelayStateFailed, tc.mr)
+			if tc.want == nil {
+				if err != nil {
+					t.Errorf("responseErr(): got unexpected error: %v", err)
+				}
+				return
+			}
+			var got *DeviceError
+			if !errors.As(err, &got) {
+				t.Fatalf("responseErr(): got %v, want a DeviceError", err)
+			}
+			if !errors.Is(err, ErrSetRelayStateFailed) {
+				t.Errorf("responseErr(): returned error is not a ErrSetRelayStateFailed")
+			}
+			if diff := cmp.Diff(*tc.want, *got, cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+				t.Errorf("responseErr(): mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,666 @@
+// Package kasatest runs fake Kasa devices on local UDP and TCP ports, for tests
+// and demos of code speaking the Kasa protocol. Devices answer the system,
+// emeter, time and smart bulb lighting modules much as real devices do, and
+// can be made to misbehave by injecting Faults.
+package kasatest
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+// Modules answered by a Device, unless disabled with WithoutModule.
+const (
+	ModuleSystem   = "system"
+	ModuleEmeter   = "emeter"
+	ModuleTime     = "time"
+	ModuleLighting = "smartlife.iot.smartbulb.lightingservice"
+)
+
+// Error codes reported by Kasa devices.
+const (
+	ErrCodeModuleNotSupported = -1
+	ErrCodeMethodNotSupported = -2
+	ErrCodeInvalidArgument    = -3
+)
+
+// Timeout suited to requests made to Devices. Kasa clients wait for a second of
+// silence after the last response unless the deadline is sooner, and local
+// devices respond at once, so a short deadline keeps tests quick.
+const Timeout = 100 * time.Millisecond
+
+// maxTCPRequest is the largest TCP request accepted, in bytes.
+const maxTCPRequest = 64 << 10
+
+// Emeter readings reported by a Device, in amperes, volts, watts and
+// watt-hours.
+type Emeter struct {
+	Current float64
+	Voltage float64
+	Power   float64
+	Total   float64
+}
+
+// reading in the units of recent firmware.
+func (e Emeter) reading() map[string]interface{} {
+	return map[string]interface{}{
+		"current_ma": e.Current * 1e3,
+		"voltage_mv": e.Voltage * 1e3,
+		"power_mw":   e.Power * 1e3,
+		"total_wh":   e.Total,
+		"err_code":   0,
+	}
+}
+
+// Faults injected into a Device's responses.
+type Faults struct {
+	// Drop requests without responding.
+	Drop bool
+	// Delay each response.
+	Delay time.Duration
+	// ErrorCode reported, with ErrorMessage, in place of the result of every
+	// method called.
+	ErrorCode    int
+	ErrorMessage string
+	// Malformed responses which are not valid JSON.
+	Malformed bool
+}
+
+// Device is a fake Kasa device.
+type Device struct {
+	udp      *net.UDPConn
+	tcp      net.Listener
+	clock    func() time.Time
+	disabled map[string]bool
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	sysinfo  map[string]interface{}
+	emeter   *Emeter
+	light    *kasa.LightState
+	faults   Faults
+	requests []*kasa.APIMessage
+}
+
+type Option func(*Device)
+
+// WithSysinfo replaces the device's default system information, as returned
+// by get_sysinfo. Options which follow modify it.
+func WithSysinfo(sysinfo map[string]interface{}) Option {
+	return func(d *Device) {
+		d.sysinfo = make(map[string]interface{}, len(sysinfo))
+		for k, v := range sysinfo {
+			d.sysinfo[k] = v
+		}
+	}
+}
+
+// WithAlias of the device.
+func WithAlias(alias string) Option {
+	return func(d *Device) {
+		d.sysinfo["alias"] = alias
+	}
+}
+
+// WithDeviceID of the device.
+func WithDeviceID(id string) Option {
+	return func(d *Device) {
+		d.sysinfo["deviceId"] = id
+	}
+}
+
+// WithRelay state of the device.
+func WithRelay(on bool) Option {
+	return func(d *Device) {
+		d.sysinfo["relay_state"] = boolInt(on)
+	}
+}
+
+// WithEmeter makes the device a plug with an energy meter reporting e.
+func WithEmeter(e Emeter) Option {
+	return func(d *Device) {
+		d.emeter = &e
+		d.sysinfo["feature"] = "TIM:ENE"
+		d.sysinfo["model"] = "HS110(US)"
+	}
+}
+
+// WithChildren makes the device a power strip with the outlets.
+func WithChildren(children ...kasa.ChildInformation) Option {
+	return func(d *Device) {
+		d.sysinfo["children"] = children
+		d.sysinfo["child_num"] = len(children)
+		d.sysinfo["model"] = "HS300(US)"
+		delete(d.sysinfo, "relay_state")
+	}
+}
+
+// WithLight makes the device a color smart bulb in the state.
+func WithLight(state kasa.LightState) Option {
+	return func(d *Device) {
+		d.light = &state
+		d.sysinfo["model"] = "KL130(US)"
+		d.sysinfo["mic_type"] = "IOT.SMARTBULB"
+		d.sysinfo["is_dimmable"] = 1
+		d.sysinfo["is_color"] = 1
+		d.sysinfo["is_variable_color_temp"] = 1
+		delete(d.sysinfo, "relay_state")
+		delete(d.sysinfo, "type")
+	}
+}
+
+// WithFaults injected from the start.
+func WithFaults(f Faults) Option {
+	return func(d *Device) {
+		d.faults = f
+	}
+}
+
+// WithoutModule makes the device report every method of the module as not
+// supported, as real devices do for modules they lack.
+func WithoutModule(module string) Option {
+	return func(d *Device) {
+		d.disabled[module] = true
+	}
+}
+
+// WithClock reporting the device's time. Defaults to time.Now.
+func WithClock(clock func() time.Time) Option {
+	return func(d *Device) {
+		d.clock = clock
+	}
+}
+
+var deviceCount uint32
+
+func defaultSysinfo() map[string]interface{} {
+	n := atomic.AddUint32(&deviceCount, 1)
+	return map[string]interface{}{
+		"err_code":    0,
+		"active_mode": "none",
+		"alias":       fmt.Sprintf("Fake Plug %v", n),
+		"deviceId":    fmt.Sprintf("8006%036X", n),
+		"dev_name":    "Smart Wi-Fi Plug",
+		"feature":     "TIM",
+		"hwId":        "7777DDB6F6F7E5B1EB8A95C2C9B63C7F",
+		"hw_ver":      "2.0",
+		"led_off":     0,
+		"mac":         fmt.Sprintf("E4:C3:2A:%02X:%02X:%02X", byte(n>>16), byte(n>>8), byte(n)),
+		"model":       "HS103(US)",
+		"oemId":       "211C91F3C6FB6E2D8B6F6F2C8B9E8F3A",
+		"on_time":     0,
+		"relay_state": 0,
+		"rssi":        -50,
+		"sw_ver":      "1.0.3 Build 201015 Rel.142523",
+		"type":        "IOT.SMARTPLUGSWITCH",
+		"updating":    0,
+	}
+}
+
+// New Device listening on UDP and TCP ports of the same number on 127.0.0.1.
+// The device must be closed when no longer needed.
+func New(opts ...Option) (*Device, error) {
+	return Listen("127.0.0.1:0", opts...)
+}
+
+// Listen on the UDP and TCP address, which is an ip:port, for example
+// 0.0.0.0:9999 to serve a demo on the standard port. If the port is 0, a UDP
+// port is chosen for which the TCP port of the same number is free.
+func Listen(addr string, opts ...Option) (*Device, error) {
+	d := &Device{
+		clock:    time.Now,
+		disabled: make(map[string]bool),
+		sysinfo:  defaultSysinfo(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	uaddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; d.tcp == nil; attempt++ {
+		udp, err := net.ListenUDP("udp4", uaddr)
+		if err != nil {
+			return nil, err
+		}
+		bound := udp.LocalAddr().(*net.UDPAddr)
+		tcp, err := net.Listen("tcp4", (&net.TCPAddr{IP: bound.IP, Port: bound.Port}).String())
+		if err != nil {
+			udp.Close()
+			if uaddr.Port != 0 || attempt >= 10 {
+				return nil, err
+			}
+			continue
+		}
+		d.udp, d.tcp = udp, tcp
+	}
+	d.wg.Add(2)
+	go d.serveUDP()
+	go d.serveTCP()
+	return d, nil
+}
+
+// Start a Device for the duration of the test, which fails if it cannot.
+func Start(t testing.TB, opts ...Option) *Device {
+	t.Helper()
+	d, err := New(opts...)
+	if err != nil {
+		t.Fatalf("starting fake Kasa device: %v", err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// Close the device's listeners, and wait for responses in flight.
+func (d *Device) Close() {
+	d.udp.Close()
+	d.tcp.Close()
+	d.wg.Wait()
+}
+
+// Addr of the device, as an ip:port.
+func (d *Device) Addr() string {
+	return d.udp.LocalAddr().String()
+}
+
+// UDPAddr of the device.
+func (d *Device) UDPAddr() *net.UDPAddr {
+	return d.udp.LocalAddr().(*net.UDPAddr)
+}
+
+// TCPAddr of the device.
+func (d *Device) TCPAddr() *net.TCPAddr {
+	return d.tcp.Addr().(*net.TCPAddr)
+}
+
+// Update the device's system information.
+func (d *Device) Update(f func(sysinfo map[string]interface{})) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f(d.sysinfo)
+}
+
+// Relay reports whether the device's relay is on.
+func (d *Device) Relay() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, _ := toInt(d.sysinfo["relay_state"])
+	return v != 0
+}
+
+// Light state of a smart bulb.
+func (d *Device) Light() kasa.LightState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.light == nil {
+		return kasa.LightState{}
+	}
+	return *d.light
+}
+
+// SetLight state of a smart bulb, as if changed by other means.
+func (d *Device) SetLight(state kasa.LightState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.light = &state
+}
+
+// SetEmeter readings reported by the device.
+func (d *Device) SetEmeter(e Emeter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.emeter = &e
+}
+
+// SetFaults injected into subsequent responses.
+func (d *Device) SetFaults(f Faults) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.faults = f
+}
+
+// Requests received by the device, in order.
+func (d *Device) Requests() []*kasa.APIMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*kasa.APIMessage(nil), d.requests...)
+}
+
+// RelayChanges requested of the device with set_relay_state, in order.
+func (d *Device) RelayChanges() []bool {
+	var changes []bool
+	for _, req := range d.Requests() {
+		var arg struct {
+			State interface{} `json:"state"`
+		}
+		if decodeArg(req.System["set_relay_state"], &arg) != nil {
+			continue
+		}
+		if state, ok := toInt(arg.State); ok {
+			changes = append(changes, state != 0)
+		}
+	}
+	return changes
+}
+
+// LightChanges requested of the device with transition_light_state, in order.
+func (d *Device) LightChanges() []kasa.LightState {
+	var changes []kasa.LightState
+	for _, req := range d.Requests() {
+		var s kasa.LightState
+		if decodeArg(req.LightingService["transition_light_state"], &s) == nil {
+			changes = append(changes, s)
+		}
+	}
+	return changes
+}
+
+// Discover the devices, as inventory.Static does, polling each concurrently
+// and omitting those which do not respond within Timeout. The result can be
+// used as an inventory.DiscoverFunc.
+func Discover(devices ...*Device) func(ctx context.Context) ([]*kasa.SystemInformation, error) {
+	return func(ctx context.Context) ([]*kasa.SystemInformation, error) {
+		ctx, cancel := context.WithTimeout(ctx, Timeout)
+		defer cancel()
+		results := make([][]*kasa.SystemInformation, len(devices))
+		var wg sync.WaitGroup
+		for i, d := range devices {
+			wg.Add(1)
+			go func(i int, d *Device) {
+				defer wg.Done()
+				infos, err := kasa.GetSystemInformation(ctx, d.UDPAddr(), nil, true)
+				if err == nil {
+					results[i] = infos
+				}
+			}(i, d)
+		}
+		wg.Wait()
+		var infos []*kasa.SystemInformation
+		for _, r := range results {
+			infos = append(infos, r...)
+		}
+		return infos, nil
+	}
+}
+
+func (d *Device) serveUDP() {
+	defer d.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, raddr, err := d.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		reply, delay, ok := d.respond(buf[:n])
+		if !ok {
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			time.Sleep(delay)
+			_, _ = d.udp.WriteToUDP(reply, raddr)
+		}()
+	}
+}
+
+func (d *Device) serveTCP() {
+	defer d.wg.Done()
+	for {
+		conn, err := d.tcp.Accept()
+		if err != nil {
+			return
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			defer conn.Close()
+			d.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers requests on a TCP connection, each of which is prefixed
+// with its length as a big-endian uint32, as are the responses.
+func (d *Device) serveConn(conn net.Conn) {
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n > maxTCPRequest {
+			return
+		}
+		req := make([]byte, n)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		reply, delay, ok := d.respond(req)
+		if !ok {
+			continue
+		}
+		time.Sleep(delay)
+		binary.BigEndian.PutUint32(header[:], uint32(len(reply)))
+		if _, err := conn.Write(append(header[:], reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// respond to an encrypted request, returning the encrypted reply and how long
+// to delay it, or false if there is none.
+func (d *Device) respond(raw []byte) ([]byte, time.Duration, bool) {
+	var req kasa.APIMessage
+	if err := kasa.DecodeAPIMessage(raw, &req); err != nil {
+		return nil, 0, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, &req)
+	if d.faults.Drop {
+		return nil, 0, false
+	}
+	reply := d.handle(&req)
+	msg, err := reply.Encode()
+	if err != nil {
+		return nil, 0, false
+	}
+	if d.faults.Malformed {
+		// The XOR cipher encrypts byte by byte, so a truncated response
+		// decrypts to truncated JSON.
+		msg = msg[:len(msg)/2]
+	}
+	return msg, d.faults.Delay, true
+}
+
+// errResult of a method, which is only the error code on success.
+func errResult(code int, msg string) map[string]interface{} {
+	r := map[string]interface{}{"err_code": code}
+	if msg != "" {
+		r["err_msg"] = msg
+	}
+	return r
+}
+
+// handle the request with d.mu held.
+func (d *Device) handle(req *kasa.APIMessage) *kasa.APIMessage {
+	var reply kasa.APIMessage
+	if req.System != nil {
+		reply.System = d.module(ModuleSystem, req.System, d.system)
+	}
+	if req.Emeter != nil {
+		reply.Emeter = d.module(ModuleEmeter, req.Emeter, d.emeterMethod)
+	}
+	if req.Time != nil {
+		reply.Time = d.module(ModuleTime, req.Time, d.timeMethod)
+	}
+	if req.LightingService != nil {
+		reply.LightingService = d.module(ModuleLighting, req.LightingService, d.lighting)
+	}
+	return &reply
+}
+
+// module answers each method called in a module. Like real devices, an
+// unsupported module is answered with a single error in place of the results
+// of its methods.
+func (d *Device) module(module string, methods map[string]interface{}, call func(method string, arg interface{}) map[string]interface{}) map[string]interface{} {
+	if d.disabled[module] ||
+		(module == ModuleEmeter && d.emeter == nil) ||
+		(module == ModuleLighting && d.light == nil) {
+		return errResult(ErrCodeModuleNotSupported, "module not support")
+	}
+	results := make(map[string]interface{}, len(methods))
+	for method, arg := range methods {
+		if d.faults.ErrorCode != 0 {
+			results[method] = errResult(d.faults.ErrorCode, d.faults.ErrorMessage)
+			continue
+		}
+		results[method] = call(method, arg)
+	}
+	return results
+}
+
+func (d *Device) system(method string, arg interface{}) map[string]interface{} {
+	switch method {
+	case "get_sysinfo":
+		info := make(map[string]interface{}, len(d.sysinfo)+1)
+		for k, v := range d.sysinfo {
+			info[k] = v
+		}
+		if d.light != nil {
+			info["light_state"] = *d.light
+		}
+		return info
+	case "set_relay_state":
+		var req struct {
+			State interface{} `json:"state"`
+		}
+		if err := decodeArg(arg, &req); err != nil {
+			return errResult(ErrCodeInvalidArgument, "invalid argument")
+		}
+		state, ok := toInt(req.State)
+		if !ok {
+			return errResult(ErrCodeInvalidArgument, "invalid argument")
+		}
+		d.sysinfo["relay_state"] = boolInt(state != 0)
+		d.sysinfo["on_time"] = 0
+		return errResult(0, "")
+	case "set_dev_alias":
+		var req struct {
+			Alias string `json:"alias"`
+		}
+		if err := decodeArg(arg, &req); err != nil || req.Alias == "" {
+			return errResult(ErrCodeInvalidArgument, "invalid argument")
+		}
+		d.sysinfo["alias"] = req.Alias
+		return errResult(0, "")
+	}
+	return errResult(ErrCodeMethodNotSupported, "member not support")
+}
+
+func (d *Device) emeterMethod(method string, _ interface{}) map[string]interface{} {
+	if method == "get_realtime" {
+		return d.emeter.reading()
+	}
+	return errResult(ErrCodeMethodNotSupported, "member not support")
+}
+
+func (d *Device) timeMethod(method string, _ interface{}) map[string]interface{} {
+	if method != "get_time" {
+		return errResult(ErrCodeMethodNotSupported, "member not support")
+	}
+	t := d.clock()
+	return map[string]interface{}{
+		"year":     t.Year(),
+		"month":    int(t.Month()),
+		"mday":     t.Day(),
+		"hour":     t.Hour(),
+		"min":      t.Minute(),
+		"sec":      t.Second(),
+		"err_code": 0,
+	}
+}
+
+func (d *Device) lighting(method string, arg interface{}) map[string]interface{} {
+	switch method {
+	case "get_light_state":
+	case "transition_light_state":
+		var s kasa.LightState
+		if err := decodeArg(arg, &s); err != nil {
+			return errResult(ErrCodeInvalidArgument, "invalid argument")
+		}
+		merge(d.light, s)
+	default:
+		return errResult(ErrCodeMethodNotSupported, "member not support")
+	}
+	var state map[string]interface{}
+	if err := decodeArg(d.light, &state); err != nil {
+		return errResult(ErrCodeInvalidArgument, err.Error())
+	}
+	state["err_code"] = 0
+	return state
+}
+
+// merge the fields set in s into the light state.
+func merge(light *kasa.LightState, s kasa.LightState) {
+	for _, f := range []struct {
+		dst **int
+		src *int
+	}{
+		{&light.OnOff, s.OnOff},
+		{&light.Brightness, s.Brightness},
+		{&light.Hue, s.Hue},
+		{&light.Saturation, s.Saturation},
+		{&light.ColorTemp, s.ColorTemp},
+	} {
+		if f.src != nil {
+			v := *f.src
+			*f.dst = &v
+		}
+	}
+}
+
+// decodeArg into v, by way of JSON, as the device would see it on the wire.
+func decodeArg(arg, v interface{}) error {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	if string(b) == "null" {
+		return errors.New("missing argument")
+	}
+	return json.Unmarshal(b, v)
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// toInt from a JSON number or bool.
+func toInt(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case bool:
+		return boolInt(v), true
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,411 @@
+package kasatest
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+)
+
+func intp(v int) *int { return &v }
+
+// send a request to the device over UDP, returning the raw reply or nil if
+// none arrives within the timeout.
+func send(t *testing.T, d *Device, req *kasa.APIMessage, timeout time.Duration) []byte {
+	t.Helper()
+	msg, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialUDP("udp4", nil, d.UDPAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+// exchange a request with the device over UDP, returning the decoded reply or
+// nil if none arrives within the timeout.
+func exchange(t *testing.T, d *Device, req *kasa.APIMessage, timeout time.Duration) map[string]interface{} {
+	t.Helper()
+	raw := send(t, d, req, timeout)
+	if raw == nil {
+		return nil
+	}
+	return decode(t, raw)
+}
+
+func decode(t *testing.T, raw []byte) map[string]interface{} {
+	t.Helper()
+	var reply kasa.APIMessage
+	if err := kasa.DecodeAPIMessage(raw, &reply); err != nil {
+		t.Fatalf("decoding reply: %v", err)
+	}
+	// Round trip through JSON to compare replies as a client would see them.
+	b, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func system(method string, arg interface{}) *kasa.APIMessage {
+	return &kasa.APIMessage{System: map[string]interface{}{method: arg}}
+}
+
+func TestDevice(t *testing.T) {
+	clock := func() time.Time { return time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC) }
+	sysinfo := map[string]interface{}{"alias": "Kettle", "relay_state": 0}
+	for tn, tc := range map[string]struct {
+		opts      []Option
+		req       *kasa.APIMessage
+		want      map[string]interface{}
+		wantRelay bool
+		wantLight kasa.LightState
+	}{
+		"get_sysinfo": {
+			opts: []Option{WithSysinfo(sysinfo), WithRelay(true)},
+			req:  system("get_sysinfo", nil),
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"get_sysinfo": map[string]interface{}{"alias": "Kettle", "relay_state": 1.0},
+				},
+			},
+			wantRelay: true,
+		},
+		"set_relay_state bool": {
+			opts: []Option{WithSysinfo(sysinfo)},
+			req:  system("set_relay_state", map[string]interface{}{"state": true}),
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"set_relay_state": map[string]interface{}{"err_code": 0.0},
+				},
+			},
+			wantRelay: true,
+		},
+		"set_relay_state int": {
+			opts: []Option{WithSysinfo(sysinfo), WithRelay(true)},
+			req:  system("set_relay_state", map[string]interface{}{"state": 0}),
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"set_relay_state": map[string]interface{}{"err_code": 0.0},
+				},
+			},
+		},
+		"set_relay_state invalid": {
+			opts: []Option{WithSysinfo(sysinfo)},
+			req:  system("set_relay_state", map[string]interface{}{"state": "on"}),
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"set_relay_state": map[string]interface{}{"err_code": -3.0, "err_msg": "invalid argument"},
+				},
+			},
+		},
+		"unknown method": {
+			opts: []Option{WithSysinfo(sysinfo)},
+			req:  system("reboot", nil),
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"reboot": map[string]interface{}{"err_code": -2.0, "err_msg": "member not support"},
+				},
+			},
+		},
+		"get_realtime": {
+			opts: []Option{WithEmeter(Emeter{Current: 0.5, Voltage: 120.5, Power: 60, Total: 1234})},
+			req:  &kasa.APIMessage{Emeter: map[string]interface{}{"get_realtime": nil}},
+			want: map[string]interface{}{
+				"emeter": map[string]interface{}{
+					"get_realtime": map[string]interface{}{
+						"current_ma": 500.0,
+						"voltage_mv": 120500.0,
+						"power_mw":   60000.0,
+						"total_wh":   1234.0,
+						"err_code":   0.0,
+					},
+				},
+			},
+		},
+		"get_realtime without emeter": {
+			req: &kasa.APIMessage{Emeter: map[string]interface{}{"get_realtime": nil}},
+			want: map[string]interface{}{
+				"emeter": map[string]interface{}{
+					"err_code": -1.0, "err_msg": "module not support",
+				},
+			},
+		},
+		"get_time": {
+			opts: []Option{WithClock(clock)},
+			req:  &kasa.APIMessage{Time: map[string]interface{}{"get_time": nil}},
+			want: map[string]interface{}{
+				"time": map[string]interface{}{
+					"get_time": map[string]interface{}{
+						"year": 2021.0, "month": 3.0, "mday": 14.0,
+						"hour": 15.0, "min": 9.0, "sec": 26.0,
+						"err_code": 0.0,
+					},
+				},
+			},
+		},
+		"without time": {
+			opts: []Option{WithoutModule(ModuleTime)},
+			req:  &kasa.APIMessage{Time: map[string]interface{}{"get_time": nil}},
+			want: map[string]interface{}{
+				"time": map[string]interface{}{
+					"err_code": -1.0, "err_msg": "module not support",
+				},
+			},
+		},
+		"several modules": {
+			opts: []Option{WithSysinfo(sysinfo), WithClock(clock), WithoutModule(ModuleTime)},
+			req: &kasa.APIMessage{
+				System: map[string]interface{}{"set_dev_alias": map[string]interface{}{"alias": "Toaster"}},
+				Time:   map[string]interface{}{"get_time": nil},
+			},
+			want: map[string]interface{}{
+				"system": map[string]interface{}{
+					"set_dev_alias": map[string]interface{}{"err_code": 0.0},
+				},
+				"time": map[string]interface{}{
+					"err_code": -1.0, "err_msg": "module not support",
+				},
+			},
+		},
+		"transition_light_state": {
+			opts: []Option{WithLight(kasa.LightState{OnOff: intp(1), Brightness: intp(40)})},
+			req: &kasa.APIMessage{LightingService: map[string]interface{}{
+				"transition_light_state": kasa.LightState{Brightness: intp(80), Hue: intp(120)},
+			}},
+			want: map[string]interface{}{
+				ModuleLighting: map[string]interface{}{
+					"transition_light_state": map[string]interface{}{
+						"on_off":     1.0,
+						"brightness": 80.0,
+						"hue":        120.0,
+						"err_code":   0.0,
+					},
+				},
+			},
+			wantLight: kasa.LightState{OnOff: intp(1), Brightness: intp(80), Hue: intp(120)},
+		},
+		"light without bulb": {
+			req: &kasa.APIMessage{LightingService: map[string]interface{}{
+				"transition_light_state": kasa.LightState{OnOff: intp(1)},
+			}},
+			want: map[string]interface{}{
+				ModuleLighting: map[string]interface{}{
+					"err_code": -1.0, "err_msg": "module not support",
+				},
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			d := Start(t, tc.opts...)
+			got := exchange(t, d, tc.req, time.Second)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("reply mismatch (-want +got):\n%s", diff)
+			}
+			if got := d.Relay(); got != tc.wantRelay {
+				t.Errorf("Relay(): got %v, want %v", got, tc.wantRelay)
+			}
+			if diff := cmp.Diff(tc.wantLight, d.Light()); diff != "" {
+				t.Errorf("Light() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeviceDefaults(t *testing.T) {
+	a, b := Start(t), Start(t)
+	info := func(d *Device) kasa.SystemInformation {
+		var reply kasa.APIMessage
+		if err := kasa.DecodeAPIMessage(send(t, d, system("get_sysinfo", nil), time.Second), &reply); err != nil {
+			t.Fatal(err)
+		}
+		var si kasa.SystemInformation
+		if err := si.FromAPIMessage(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return si
+	}
+	ia, ib := info(a), info(b)
+	if ia.DeviceID == ib.DeviceID || ia.MAC == ib.MAC || ia.Alias == ib.Alias {
+		t.Errorf("devices are not distinct: %+v and %+v", ia, ib)
+	}
+	if ia.Model == "" || ia.SoftwareVersion == "" || ia.Err() != nil {
+		t.Errorf("incomplete default system information: %+v", ia)
+	}
+}
+
+func TestDeviceTCP(t *testing.T) {
+	d := Start(t, WithAlias("Kettle"))
+	if d.TCPAddr().Port != d.UDPAddr().Port {
+		t.Errorf("TCP port %v differs from UDP port %v", d.TCPAddr().Port, d.UDPAddr().Port)
+	}
+	conn, err := net.DialTCP("tcp4", nil, d.TCPAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// Several requests are answered on the same connection.
+	for _, alias := range []string{"Toaster", "Kettle"} {
+		var got map[string]interface{}
+		for _, req := range []*kasa.APIMessage{
+			system("set_dev_alias", map[string]interface{}{"alias": alias}),
+			system("get_sysinfo", nil),
+		} {
+			msg, err := req.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := make([]byte, 4, 4+len(msg))
+			binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+			if _, err := conn.Write(append(frame, msg...)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(conn, frame[:4]); err != nil {
+				t.Fatal(err)
+			}
+			reply := make([]byte, binary.BigEndian.Uint32(frame[:4]))
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				t.Fatal(err)
+			}
+			got = decode(t, reply)
+		}
+		info := got["system"].(map[string]interface{})["get_sysinfo"].(map[string]interface{})
+		if info["alias"] != alias {
+			t.Errorf("alias: got %q, want %q", info["alias"], alias)
+		}
+	}
+}
+
+func TestDeviceFaults(t *testing.T) {
+	req := system("set_relay_state", map[string]interface{}{"state": 1})
+	t.Run("drop", func(t *testing.T) {
+		d := Start(t, WithFaults(Faults{Drop: true}))
+		if got := exchange(t, d, req, 200*time.Millisecond); got != nil {
+			t.Errorf("got reply %v, want none", got)
+		}
+		if d.Relay() {
+			t.Error("dropped request changed the relay")
+		}
+		d.SetFaults(Faults{})
+		if got := exchange(t, d, req, time.Second); got == nil {
+			t.Error("got no reply after clearing faults")
+		}
+	})
+	t.Run("delay", func(t *testing.T) {
+		d := Start(t, WithFaults(Faults{Delay: 300 * time.Millisecond}))
+		if got := exchange(t, d, req, 100*time.Millisecond); got != nil {
+			t.Errorf("got reply %v before the delay", got)
+		}
+		d = Start(t, WithFaults(Faults{Delay: 100 * time.Millisecond}))
+		if got := exchange(t, d, req, time.Second); got == nil {
+			t.Error("got no delayed reply")
+		}
+	})
+	t.Run("error code", func(t *testing.T) {
+		d := Start(t, WithFaults(Faults{ErrorCode: -10, ErrorMessage: "device busy"}))
+		want := map[string]interface{}{
+			"system": map[string]interface{}{
+				"set_relay_state": map[string]interface{}{"err_code": -10.0, "err_msg": "device busy"},
+			},
+		}
+		if diff := cmp.Diff(want, exchange(t, d, req, time.Second)); diff != "" {
+			t.Errorf("reply mismatch (-want +got):\n%s", diff)
+		}
+		if d.Relay() {
+			t.Error("failed request changed the relay")
+		}
+	})
+	t.Run("malformed", func(t *testing.T) {
+		d := Start(t, WithFaults(Faults{Malformed: true}))
+		raw := send(t, d, req, time.Second)
+		if raw == nil {
+			t.Fatal("got no reply")
+		}
+		var reply kasa.APIMessage
+		if err := kasa.DecodeAPIMessage(raw, &reply); err == nil {
+			t.Errorf("decoded malformed reply %+v", reply)
+		}
+	})
+}
+
+func TestDeviceRequests(t *testing.T) {
+	d := Start(t, WithFaults(Faults{Drop: true}))
+	reqs := []*kasa.APIMessage{
+		system("get_sysinfo", nil),
+		{Emeter: map[string]interface{}{"get_realtime": nil}},
+	}
+	for _, req := range reqs {
+		exchange(t, d, req, 50*time.Millisecond)
+	}
+	want := []*kasa.APIMessage{
+		{System: map[string]interface{}{"get_sysinfo": nil}},
+		{Emeter: map[string]interface{}{"get_realtime": nil}},
+	}
+	if diff := cmp.Diff(want, d.Requests()); diff != "" {
+		t.Errorf("Requests() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeviceChanges(t *testing.T) {
+	d := Start(t, WithFaults(Faults{Drop: true}))
+	for _, req := range []*kasa.APIMessage{
+		system("set_relay_state", map[string]interface{}{"state": 0}),
+		system("get_sysinfo", nil),
+		{LightingService: map[string]interface{}{"transition_light_state": kasa.LightState{OnOff: intp(1), Brightness: intp(80)}}},
+		system("set_relay_state", map[string]interface{}{"state": true}),
+	} {
+		exchange(t, d, req, 50*time.Millisecond)
+	}
+	if diff := cmp.Diff([]bool{false, true}, d.RelayChanges()); diff != "" {
+		t.Errorf("RelayChanges() mismatch (-want +got):\n%s", diff)
+	}
+	want := []kasa.LightState{{OnOff: intp(1), Brightness: intp(80)}}
+	if diff := cmp.Diff(want, d.LightChanges()); diff != "" {
+		t.Errorf("LightChanges() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	a := Start(t, WithDeviceID("kettle"))
+	b := Start(t, WithDeviceID("toaster"), WithFaults(Faults{Drop: true}))
+	discover := Discover(a, b)
+	infos, err := discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].DeviceID != "kettle" || infos[0].RemoteAddress.String() != a.Addr() {
+		t.Errorf("Discover(): got %+v, want only the kettle at %v", infos, a.Addr())
+	}
+	b.SetFaults(Faults{})
+	if infos, err = discover(context.Background()); err != nil || len(infos) != 2 {
+		t.Errorf("Discover(): got %v devices (err: %v), want 2", len(infos), err)
+	}
+}
@@ -0,0 +1,359 @@
+// Package mqtt bridges Kasa devices to an MQTT broker. Device state is
+// published to per-device topics, commands are accepted on per-device command
+// topics, and devices are announced to Home Assistant using MQTT discovery.
+//
+// For a device with ID 8006..., and the default topic prefix, the bridge uses:
+//
+//	kasa/8006.../state             JSON state of the device, retained
+//	kasa/8006.../light             Home Assistant JSON light state of bulbs
+//	kasa/8006.../availability      "online" or "offline", retained
+//	kasa/8006.../relay/set         "ON" or "OFF" switches the relay
+//	kasa/8006.../light/set         Home Assistant JSON light command for bulbs
+//
+// The availability of the bridge itself is kept on kasa/bridge/availability
+// by the Paho client's last will.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// Client of an MQTT broker.
+type Client interface {
+	// Publish the payload to the topic.
+	Publish(topic string, retained bool, payload []byte) error
+	// Subscribe to topics matching the filter, which may contain wildcards.
+	Subscribe(filter string, handle func(topic string, payload []byte)) error
+}
+
+// Default topic prefixes.
+const (
+	DefaultTopicPrefix     = "kasa"
+	DefaultDiscoveryPrefix = "homeassistant"
+)
+
+// AvailabilityTopic of the bridge using the topic prefix.
+func AvailabilityTopic(prefix string) string {
+	return prefix + "/bridge/availability"
+}
+
+// DefaultTimeout of requests to devices.
+const DefaultTimeout = 5 * time.Second
+
+// Bridge between Kasa devices and an MQTT broker.
+type Bridge struct {
+	client          Client
+	inv             *inventory.Inventory
+	laddr           *net.UDPAddr
+	prefix          string
+	discoveryPrefix string
+	timeout         time.Duration
+	handleError     func(error)
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+type Option func(*Bridge)
+
+// WithLocalAddr from which requests are sent to devices.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(b *Bridge) {
+		b.laddr = laddr
+	}
+}
+
+// WithTimeout of each request to a device. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(b *Bridge) {
+		b.timeout = timeout
+	}
+}
+
+// WithTopicPrefix under which device topics are published. Defaults to
+// DefaultTopicPrefix.
+func WithTopicPrefix(prefix string) Option {
+	return func(b *Bridge) {
+		b.prefix = prefix
+	}
+}
+
+// WithDiscoveryPrefix under which Home Assistant discovery config is
+// published. Defaults to DefaultDiscoveryPrefix. An empty prefix disables
+// discovery.
+func WithDiscoveryPrefix(prefix string) Option {
+	return func(b *Bridge) {
+		b.discoveryPrefix = prefix
+	}
+}
+
+// WithErrorHandler called with errors polling devices, publishing, and
+// handling commands, none of which stop the bridge.
+func WithErrorHandler(handle func(error)) Option {
+	return func(b *Bridge) {
+		b.handleError = handle
+	}
+}
+
+// New Bridge publishing to client the devices found using discover.
+func New(client Client, discover inventory.DiscoverFunc, opts ...Option) *Bridge {
+	b := &Bridge{
+		client:          client,
+		inv:             inventory.New(discover, inventory.DiffOptions{}),
+		prefix:          DefaultTopicPrefix,
+		discoveryPrefix: DefaultDiscoveryPrefix,
+		timeout:         DefaultTimeout,
+		handleError:     func(error) {},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Run the bridge, polling devices every interval, until the context is
+// canceled.
+func (b *Bridge) Run(ctx context.Context, interval time.Duration) error {
+	if err := b.subscribe(ctx); err != nil {
+		return err
+	}
+	return b.inv.Run(ctx, interval, func(events []inventory.Event, err error) {
+		if err != nil {
+			b.handleError(err)
+			return
+		}
+		b.update(ctx, events)
+	})
+}
+
+// subscribe to command topics. Commands run with the context.
+func (b *Bridge) subscribe(ctx context.Context) error {
+	b.mu.Lock()
+	b.ctx = ctx
+	b.mu.Unlock()
+	if err := b.client.Subscribe(b.prefix+"/+/relay/set", b.command(b.relayCommand)); err != nil {
+		return err
+	}
+	return b.client.Subscribe(b.prefix+"/+/light/set", b.command(b.lightCommand))
+}
+
+// update topics after a poll.
+func (b *Bridge) update(ctx context.Context, events []inventory.Event) {
+	for _, e := range events {
+		switch e.Type {
+		case inventory.DeviceAppeared:
+			b.announce(e.Info)
+			b.publish(b.deviceTopic(inventory.Key(e.Info), "availability"), true, []byte("online"))
+		case inventory.DeviceDisappeared:
+			b.publish(b.deviceTopic(inventory.Key(e.Info), "availability"), true, []byte("offline"))
+		case inventory.AliasChanged:
+			// The name shown by Home Assistant comes from discovery.
+			b.announce(e.Info)
+		}
+	}
+	for _, info := range b.inv.Devices() {
+		b.publishState(ctx, info)
+	}
+}
+
+func (b *Bridge) deviceTopic(key string, parts ...string) string {
+	return strings.Join(append([]string{b.prefix, key}, parts...), "/")
+}
+
+func (b *Bridge) publish(topic string, retained bool, payload []byte) {
+	if err := b.client.Publish(topic, retained, payload); err != nil {
+		b.handleError(fmt.Errorf("publishing to %v: %w", topic, err))
+	}
+}
+
+func (b *Bridge) publishJSON(topic string, retained bool, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		b.handleError(fmt.Errorf("publishing to %v: %w", topic, err))
+		return
+	}
+	b.publish(topic, retained, payload)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func isBulb(info *kasa.SystemInformation) bool {
+	return info.LightState != nil
+}
+
+func hasEmeter(info *kasa.SystemInformation) bool {
+	return strings.Contains(info.Feature, "ENE")
+}
+
+// State published for every device.
+type State struct {
+	Relay   string `json:"relay"`
+	Alias   string `json:"alias"`
+	Model   string `json:"model"`
+	Address string `json:"address"`
+	RSSI    int    `json:"rssi"`
+	OnTime  int    `json:"on_time"`
+
+	// Readings of devices with an energy meter, in watts, volts, amperes and
+	// watt-hours.
+	Power   *float64 `json:"power,omitempty"`
+	Voltage *float64 `json:"voltage,omitempty"`
+	Current *float64 `json:"current,omitempty"`
+	Energy  *float64 `json:"energy,omitempty"`
+
+	// State of smart bulbs. Brightness and saturation are percentages, color
+	// temperature is in kelvin and hue in degrees.
+	Brightness *int `json:"brightness,omitempty"`
+	ColorTemp  *int `json:"color_temp,omitempty"`
+	Hue        *int `json:"hue,omitempty"`
+	Saturation *int `json:"saturation,omitempty"`
+}
+
+// NewState of the device. Emeter readings are included if e is not nil.
+func NewState(info *kasa.SystemInformation, e *kasa.EmeterRealtime) State {
+	s := State{
+		Relay:  onOff(info.RelayState != 0),
+		Alias:  info.Alias,
+		Model:  info.Model,
+		RSSI:   info.RSSI,
+		OnTime: info.OnTime,
+	}
+	if info.RemoteAddress != nil {
+		s.Address = info.RemoteAddress.String()
+	}
+	if e != nil {
+		s.Power, s.Voltage, s.Current, s.Energy = &e.Power, &e.Voltage, &e.Current, &e.Total
+	}
+	if ls := info.LightState; ls != nil {
+		s.Relay = onOff(ls.OnOff != nil && *ls.OnOff != 0)
+		s.Brightness, s.ColorTemp, s.Hue, s.Saturation = ls.Brightness, ls.ColorTemp, ls.Hue, ls.Saturation
+	}
+	return s
+}
+
+// publishState of the device, reading its emeter if it has one.
+func (b *Bridge) publishState(ctx context.Context, info *kasa.SystemInformation) {
+	key := inventory.Key(info)
+	var e *kasa.EmeterRealtime
+	if hasEmeter(info) && info.RemoteAddress != nil {
+		var err error
+		if e, err = b.getEmeter(ctx, info.RemoteAddress); err != nil {
+			b.handleError(fmt.Errorf("reading emeter of %v: %w", key, err))
+			e = nil
+		}
+	}
+	b.publishJSON(b.deviceTopic(key, "state"), true, NewState(info, e))
+	if isBulb(info) {
+		b.publishJSON(b.deviceTopic(key, "light"), true, newLightState(info.LightState))
+	}
+}
+
+// command handles messages on a command topic of a device, asynchronously so
+// that the MQTT client is not blocked while the device is contacted.
+func (b *Bridge) command(run func(ctx context.Context, info *kasa.SystemInformation, payload []byte) error) func(string, []byte) {
+	return func(topic string, payload []byte) {
+		parts := strings.Split(strings.TrimPrefix(topic, b.prefix+"/"), "/")
+		key := parts[0]
+		info, has := b.inv.Get(key)
+		if !has || info.RemoteAddress == nil {
+			b.handleError(fmt.Errorf("command on %v: %w", topic, ErrUnknownDevice))
+			return
+		}
+		b.mu.Lock()
+		ctx := b.ctx
+		b.mu.Unlock()
+		go func() {
+			if err := run(ctx, info, payload); err != nil {
+				b.handleError(fmt.Errorf("command on %v: %w", topic, err))
+				return
+			}
+			// Publish the new state right away, rather than at the next poll.
+			updated, err := b.getSysinfo(ctx, info.RemoteAddress)
+			if err != nil {
+				b.handleError(fmt.Errorf("refreshing %v: %w", key, err))
+				return
+			}
+			b.publishState(ctx, updated)
+		}()
+	}
+}
+
+// Requests to devices, each bounded by the bridge's timeout.
+
+func (b *Bridge) getSysinfo(ctx context.Context, raddr *net.UDPAddr) (*kasa.SystemInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	return kasa.GetDeviceSystemInformation(ctx, raddr, b.laddr)
+}
+
+func (b *Bridge) setRelayState(ctx context.Context, raddr *net.UDPAddr, state bool) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	return kasa.SetRelayStateChecked(ctx, raddr, b.laddr, state)
+}
+
+func (b *Bridge) setLightState(ctx context.Context, raddr *net.UDPAddr, state kasa.LightState) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	return kasa.SetLightStateChecked(ctx, raddr, b.laddr, state)
+}
+
+func (b *Bridge) getEmeter(ctx context.Context, raddr *net.UDPAddr) (*kasa.EmeterRealtime, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	return kasa.GetEmeterRealtime(ctx, raddr, b.laddr)
+}
+
+var (
+	ErrUnknownDevice = errors.New("unknown device")
+	ErrBadCommand    = errors.New("bad command")
+)
+
+func (b *Bridge) relayCommand(ctx context.Context, info *kasa.SystemInformation, payload []byte) error {
+	var on bool
+	switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+	case "ON", "1", "TRUE":
+		on = true
+	case "OFF", "0", "FALSE":
+	default:
+		return fmt.Errorf("%w: relay state %q, want ON or OFF", ErrBadCommand, payload)
+	}
+	if isBulb(info) {
+		v := 0
+		if on {
+			v = 1
+		}
+		return b.setLightState(ctx, info.RemoteAddress, kasa.LightState{OnOff: &v})
+	}
+	return b.setRelayState(ctx, info.RemoteAddress, on)
+}
+
+func (b *Bridge) lightCommand(ctx context.Context, info *kasa.SystemInformation, payload []byte) error {
+	if !isBulb(info) {
+		return fmt.Errorf("%w: %v is not a smart bulb", ErrBadCommand, inventory.Key(info))
+	}
+	var cmd lightCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadCommand, err)
+	}
+	state, err := cmd.lightState()
+	if err != nil {
+		return err
+	}
+	return b.setLightState(ctx, info.RemoteAddress, state)
+}
@@ -0,0 +1,503 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+// fakeBroker is an in-memory Client which keeps the last payload published to
+// each topic.
+type fakeBroker struct {
+	mu        sync.Mutex
+	published map[string]string
+	retained  map[string]bool
+	subs      map[string]func(string, []byte)
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		published: make(map[string]string),
+		retained:  make(map[string]bool),
+		subs:      make(map[string]func(string, []byte)),
+	}
+}
+
+func (f *fakeBroker) Publish(topic string, retained bool, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published[topic] = string(payload)
+	f.retained[topic] = retained
+	return nil
+}
+
+func (f *fakeBroker) Subscribe(filter string, handle func(string, []byte)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[filter] = handle
+	return nil
+}
+
+// matches reports whether the topic matches a filter using the single level
+// wildcard.
+func matches(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	if len(fs) != len(ts) {
+		return false
+	}
+	for i := range fs {
+		if fs[i] != "+" && fs[i] != ts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver a message to matching subscribers.
+func (f *fakeBroker) deliver(topic, payload string) {
+	f.mu.Lock()
+	var handlers []func(string, []byte)
+	for filter, h := range f.subs {
+		if matches(filter, topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	f.mu.Unlock()
+	for _, h := range handlers {
+		h(topic, []byte(payload))
+	}
+}
+
+func (f *fakeBroker) get(topic string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, has := f.published[topic]
+	return p, has
+}
+
+// await a payload on the topic satisfying ok, which commands publish
+// asynchronously.
+func (f *fakeBroker) await(t *testing.T, topic string, ok func(string) bool) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if p, has := f.get(topic); has && ok(p) {
+			return p
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p, _ := f.get(topic)
+	t.Fatalf("timed out waiting on %v, last payload: %q", topic, p)
+	return ""
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// testDevices starts a plug with an energy meter, and a smart bulb.
+func testDevices(t *testing.T) (modem, lamp *kasatest.Device) {
+	t.Helper()
+	modem = kasatest.Start(t,
+		kasatest.WithDeviceID("modem"),
+		kasatest.WithAlias("ADSL Modem"),
+		kasatest.WithRelay(true),
+		kasatest.WithEmeter(kasatest.Emeter{Current: 0.25, Voltage: 120.5, Power: 7.5, Total: 1234}))
+	modem.Update(func(s map[string]interface{}) {
+		s["mac"] = "50:C7:BF:00:00:01"
+		s["sw_ver"] = "1.2.5"
+		s["rssi"] = -51
+		s["on_time"] = 3600
+	})
+	lamp = kasatest.Start(t,
+		kasatest.WithDeviceID("lamp"),
+		kasatest.WithAlias("Lamp"),
+		kasatest.WithLight(kasa.LightState{OnOff: intPtr(1), Brightness: intPtr(40), ColorTemp: intPtr(2700)}))
+	lamp.Update(func(s map[string]interface{}) {
+		s["mac"] = "50:C7:BF:00:00:03"
+		s["sw_ver"] = "1.0.12"
+		s["rssi"] = -62
+	})
+	return modem, lamp
+}
+
+// devices started by testDevices, for startBridge.
+func devices(modem, lamp *kasatest.Device) []*kasatest.Device {
+	return []*kasatest.Device{modem, lamp}
+}
+
+func startBridge(t *testing.T, devices []*kasatest.Device, opts ...Option) (*fakeBroker, *Bridge) {
+	t.Helper()
+	broker := newFakeBroker()
+	b := New(broker, kasatest.Discover(devices...), append([]Option{
+		WithTimeout(kasatest.Timeout),
+		WithErrorHandler(func(err error) {
+			t.Errorf("bridge error: %v", err)
+		}),
+	}, opts...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := b.subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	events, err := b.inv.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.update(ctx, events)
+	return broker, b
+}
+
+func decode(t *testing.T, payload string) map[string]interface{} {
+	t.Helper()
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &got); err != nil {
+		t.Fatalf("decoding %q: %v", payload, err)
+	}
+	return got
+}
+
+func TestBridgeState(t *testing.T) {
+	modem, lamp := testDevices(t)
+	broker, _ := startBridge(t, devices(modem, lamp))
+	for tn, tc := range map[string]struct {
+		topic string
+		want  map[string]interface{}
+	}{
+		"plug": {
+			topic: "kasa/modem/state",
+			want: map[string]interface{}{
+				"relay":   "ON",
+				"alias":   "ADSL Modem",
+				"model":   "HS110(US)",
+				"address": modem.Addr(),
+				"rssi":    -51.0,
+				"on_time": 3600.0,
+				"power":   7.5,
+				"voltage": 120.5,
+				"current": 0.25,
+				"energy":  1234.0,
+			},
+		},
+		"bulb": {
+			topic: "kasa/lamp/state",
+			want: map[string]interface{}{
+				"relay":      "ON",
+				"alias":      "Lamp",
+				"model":      "KL130(US)",
+				"address":    lamp.Addr(),
+				"rssi":       -62.0,
+				"on_time":    0.0,
+				"brightness": 40.0,
+				"color_temp": 2700.0,
+			},
+		},
+		"bulb light": {
+			topic: "kasa/lamp/light",
+			want: map[string]interface{}{
+				"state":      "ON",
+				"brightness": 40.0,
+				"color_mode": "color_temp",
+				"color_temp": 370.0,
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			payload, has := broker.get(tc.topic)
+			if !has {
+				t.Fatalf("nothing published to %v", tc.topic)
+			}
+			if diff := cmp.Diff(tc.want, decode(t, payload)); diff != "" {
+				t.Errorf("state mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	for _, topic := range []string{"kasa/modem/availability", "kasa/lamp/availability"} {
+		if got, _ := broker.get(topic); got != "online" {
+			t.Errorf("%v: got %q, want online", topic, got)
+		}
+	}
+}
+
+func TestBridgeDisappeared(t *testing.T) {
+	modem, lamp := testDevices(t)
+	// Reading the state of the modem fails while it is missing.
+	broker, b := startBridge(t, devices(modem, lamp), WithErrorHandler(func(error) {}))
+	modem.SetFaults(kasatest.Faults{Drop: true})
+	// A single missed poll may be a dropped response, so the modem stays
+	// available until it has missed another.
+	for _, want := range []string{"online", "offline"} {
+		events, err := b.inv.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.update(context.Background(), events)
+		if got, _ := broker.get("kasa/modem/availability"); got != want {
+			t.Errorf("got availability %q, want %v", got, want)
+		}
+	}
+}
+
+func TestBridgeDiscovery(t *testing.T) {
+	broker, _ := startBridge(t, devices(testDevices(t)))
+	availability := []interface{}{
+		map[string]interface{}{"topic": "kasa/bridge/availability"},
+	}
+	device := func(id, name, model, sw string) map[string]interface{} {
+		d := map[string]interface{}{
+			"identifiers":  []interface{}{id},
+			"name":         name,
+			"manufacturer": "TP-Link",
+			"model":        model,
+		}
+		if sw != "" {
+			d["sw_version"] = sw
+		}
+		return d
+	}
+	modem := device("kasa_modem", "ADSL Modem", "HS110(US)", "1.2.5")
+	modem["connections"] = []interface{}{[]interface{}{"mac", "50:c7:bf:00:00:01"}}
+	lamp := device("kasa_lamp", "Lamp", "KL130(US)", "1.0.12")
+	lamp["connections"] = []interface{}{[]interface{}{"mac", "50:c7:bf:00:00:03"}}
+	modemAvailability := append(availability, map[string]interface{}{"topic": "kasa/modem/availability"})
+
+	for tn, tc := range map[string]struct {
+		topic string
+		want  map[string]interface{}
+	}{
+		"switch": {
+			topic: "homeassistant/switch/kasa_modem/relay/config",
+			want: map[string]interface{}{
+				"name":              "ADSL Modem",
+				"unique_id":         "kasa_modem_relay",
+				"state_topic":       "kasa/modem/state",
+				"command_topic":     "kasa/modem/relay/set",
+				"availability":      modemAvailability,
+				"availability_mode": "all",
+				"value_template":    "{{ value_json.relay }}",
+				"payload_on":        "ON",
+				"payload_off":       "OFF",
+				"device":            modem,
+			},
+		},
+		"power sensor": {
+			topic: "homeassistant/sensor/kasa_modem/power/config",
+			want: map[string]interface{}{
+				"name":                "ADSL Modem Power",
+				"unique_id":           "kasa_modem_power",
+				"state_topic":         "kasa/modem/state",
+				"availability":        modemAvailability,
+				"availability_mode":   "all",
+				"value_template":      "{{ value_json.power }}",
+				"device_class":        "power",
+				"state_class":         "measurement",
+				"unit_of_measurement": "W",
+				"device":              modem,
+			},
+		},
+		"energy sensor": {
+			topic: "homeassistant/sensor/kasa_modem/energy/config",
+			want: map[string]interface{}{
+				"name":                "ADSL Modem Energy",
+				"unique_id":           "kasa_modem_energy",
+				"state_topic":         "kasa/modem/state",
+				"availability":        modemAvailability,
+				"availability_mode":   "all",
+				"value_template":      "{{ value_json.energy }}",
+				"device_class":        "energy",
+				"state_class":         "total_increasing",
+				"unit_of_measurement": "Wh",
+				"device":              modem,
+			},
+		},
+		"light": {
+			topic: "homeassistant/light/kasa_lamp/light/config",
+			want: map[string]interface{}{
+				"name":                  "Lamp",
+				"unique_id":             "kasa_lamp_light",
+				"state_topic":           "kasa/lamp/light",
+				"command_topic":         "kasa/lamp/light/set",
+				"availability":          append(availability, map[string]interface{}{"topic": "kasa/lamp/availability"}),
+				"availability_mode":     "all",
+				"schema":                "json",
+				"brightness":            true,
+				"brightness_scale":      100.0,
+				"color_mode":            true,
+				"supported_color_modes": []interface{}{"color_temp", "hs"},
+				"min_mireds":            111.0,
+				"max_mireds":            400.0,
+				"device":                lamp,
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			payload, has := broker.get(tc.topic)
+			if !has {
+				t.Fatalf("nothing published to %v", tc.topic)
+			}
+			if !broker.retained[tc.topic] {
+				t.Errorf("discovery config is not retained")
+			}
+			if diff := cmp.Diff(tc.want, decode(t, payload)); diff != "" {
+				t.Errorf("config mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if _, has := broker.get("homeassistant/switch/kasa_lamp/relay/config"); has {
+		t.Errorf("bulb announced as a switch")
+	}
+}
+
+func TestBridgeDiscoveryDisabled(t *testing.T) {
+	broker, _ := startBridge(t, devices(testDevices(t)), WithDiscoveryPrefix(""), WithTopicPrefix("home/kasa"))
+	for topic := range broker.published {
+		if strings.HasPrefix(topic, DefaultDiscoveryPrefix) {
+			t.Errorf("published discovery config to %v", topic)
+		}
+	}
+	if _, has := broker.get("home/kasa/modem/state"); !has {
+		t.Errorf("state not published with topic prefix")
+	}
+}
+
+func TestBridgeRelayCommand(t *testing.T) {
+	broker, _ := startBridge(t, devices(testDevices(t)))
+	broker.deliver("kasa/modem/relay/set", "OFF")
+	broker.await(t, "kasa/modem/state", func(p string) bool {
+		return strings.Contains(p, `"relay":"OFF"`)
+	})
+	broker.deliver("kasa/modem/relay/set", "on")
+	broker.await(t, "kasa/modem/state", func(p string) bool {
+		return strings.Contains(p, `"relay":"ON"`)
+	})
+}
+
+func TestBridgeLightCommand(t *testing.T) {
+	modem, lamp := testDevices(t)
+	broker, _ := startBridge(t, devices(modem, lamp))
+	broker.deliver("kasa/lamp/light/set", `{"state": "ON", "brightness": 80, "transition": 1.5}`)
+	broker.await(t, "kasa/lamp/light", func(p string) bool {
+		return strings.Contains(p, `"brightness":80`)
+	})
+	broker.deliver("kasa/lamp/relay/set", "OFF")
+	broker.await(t, "kasa/lamp/light", func(p string) bool {
+		return strings.Contains(p, `"state":"OFF"`)
+	})
+	want := []kasa.LightState{
+		{OnOff: intPtr(1), Brightness: intPtr(80), TransitionPeriod: intPtr(1500)},
+		{OnOff: intPtr(0)},
+	}
+	if diff := cmp.Diff(want, lamp.LightChanges()); diff != "" {
+		t.Errorf("light states mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBridgeBadCommand(t *testing.T) {
+	broker := newFakeBroker()
+	errs := make(chan error, 1)
+	b := New(broker, kasatest.Discover(devices(testDevices(t))...), WithErrorHandler(func(err error) { errs <- err }))
+	ctx := context.Background()
+	if err := b.subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.inv.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for tn, tc := range map[string]struct {
+		topic, payload string
+		want           error
+	}{
+		"unknown device":  {"kasa/nope/relay/set", "ON", ErrUnknownDevice},
+		"bad relay state": {"kasa/modem/relay/set", "sideways", ErrBadCommand},
+		"light on a plug": {"kasa/modem/light/set", `{"state": "ON"}`, ErrBadCommand},
+		"bad light json":  {"kasa/lamp/light/set", `{`, ErrBadCommand},
+		"bad light state": {"kasa/lamp/light/set", `{"state": "dim"}`, ErrBadCommand},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			broker.deliver(tc.topic, tc.payload)
+			select {
+			case err := <-errs:
+				if !errors.Is(err, tc.want) {
+					t.Errorf("got error %v, want %v", err, tc.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for error")
+			}
+		})
+	}
+}
+
+func TestBridgeCommandRejected(t *testing.T) {
+	modem, lamp := testDevices(t)
+	broker := newFakeBroker()
+	errs := make(chan error, 1)
+	b := New(broker, kasatest.Discover(modem, lamp), WithTimeout(kasatest.Timeout), WithErrorHandler(func(err error) { errs <- err }))
+	ctx := context.Background()
+	if err := b.subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.inv.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for tn, tc := range map[string]struct {
+		device         *kasatest.Device
+		topic, payload string
+		want           error
+	}{
+		"relay": {modem, "kasa/modem/relay/set", "OFF", kasa.ErrSetRelayStateFailed},
+		"light": {lamp, "kasa/lamp/light/set", `{"state": "OFF"}`, kasa.ErrSetLightStateFailed},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			tc.device.SetFaults(kasatest.Faults{ErrorCode: -10, ErrorMessage: "device busy"})
+			broker.deliver(tc.topic, tc.payload)
+			select {
+			case err := <-errs:
+				if !errors.Is(err, tc.want) {
+					t.Errorf("got error %v, want %v", err, tc.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for error")
+			}
+		})
+	}
+}
+
+func TestLightCommandState(t *testing.T) {
+	h, s := 120, 80
+	for tn, tc := range map[string]struct {
+		cmd  lightCommand
+		want kasa.LightState
+	}{
+		"off": {
+			cmd:  lightCommand{State: "OFF"},
+			want: kasa.LightState{OnOff: intPtr(0)},
+		},
+		"color temp": {
+			cmd:  lightCommand{State: "ON", ColorTemp: intPtr(370)},
+			want: kasa.LightState{OnOff: intPtr(1), ColorTemp: intPtr(2703)},
+		},
+		"color": {
+			cmd:  lightCommand{Color: &haColor{H: &h, S: &s}},
+			want: kasa.LightState{Hue: intPtr(120), Saturation: intPtr(80), ColorTemp: intPtr(0)},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			got, err := tc.cmd.lightState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("lightState mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
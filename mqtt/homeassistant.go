@@ -0,0 +1,259 @@
+package mqtt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// haDevice groups the entities of a Kasa device in Home Assistant.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Connections  []haPair `json:"connections,omitempty"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+type haPair [2]string
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haEntity is Home Assistant MQTT discovery config. Fields are shared by the
+// switch, light and sensor components, and omitted where unused.
+type haEntity struct {
+	Name              string           `json:"name"`
+	UniqueID          string           `json:"unique_id"`
+	StateTopic        string           `json:"state_topic"`
+	CommandTopic      string           `json:"command_topic,omitempty"`
+	Availability      []haAvailability `json:"availability"`
+	AvailabilityMode  string           `json:"availability_mode"`
+	ValueTemplate     string           `json:"value_template,omitempty"`
+	PayloadOn         string           `json:"payload_on,omitempty"`
+	PayloadOff        string           `json:"payload_off,omitempty"`
+	DeviceClass       string           `json:"device_class,omitempty"`
+	StateClass        string           `json:"state_class,omitempty"`
+	UnitOfMeasurement string           `json:"unit_of_measurement,omitempty"`
+	Device            haDevice         `json:"device"`
+
+	// Light component, using the JSON schema.
+	Schema              string   `json:"schema,omitempty"`
+	Brightness          bool     `json:"brightness,omitempty"`
+	BrightnessScale     int      `json:"brightness_scale,omitempty"`
+	ColorMode           bool     `json:"color_mode,omitempty"`
+	SupportedColorModes []string `json:"supported_color_modes,omitempty"`
+	MinMireds           int      `json:"min_mireds,omitempty"`
+	MaxMireds           int      `json:"max_mireds,omitempty"`
+}
+
+var nodeIDUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// nodeID of the device in discovery topics, which allow fewer characters than
+// other topics.
+func nodeID(key string) string {
+	return "kasa_" + nodeIDUnsafe.ReplaceAllString(key, "_")
+}
+
+// emeterSensors exposed for devices with an energy meter.
+var emeterSensors = []struct {
+	field, name, deviceClass, stateClass, unit string
+}{
+	{"power", "Power", "power", "measurement", "W"},
+	{"voltage", "Voltage", "voltage", "measurement", "V"},
+	{"current", "Current", "current", "measurement", "A"},
+	{"energy", "Energy", "energy", "total_increasing", "Wh"},
+}
+
+// Home Assistant limits for bulbs which do not report their own.
+const (
+	minColorTempKelvin = 2500
+	maxColorTempKelvin = 9000
+)
+
+// announce the device to Home Assistant.
+func (b *Bridge) announce(info *kasa.SystemInformation) {
+	if b.discoveryPrefix == "" {
+		return
+	}
+	for topic, entity := range b.discoveryConfig(info) {
+		b.publishJSON(topic, true, entity)
+	}
+}
+
+// discoveryConfig for the device, keyed by discovery topic.
+func (b *Bridge) discoveryConfig(info *kasa.SystemInformation) map[string]haEntity {
+	key := inventory.Key(info)
+	node := nodeID(key)
+	device := haDevice{
+		Identifiers:  []string{node},
+		Name:         info.Alias,
+		Manufacturer: "TP-Link",
+		Model:        info.Model,
+		SWVersion:    info.SoftwareVersion,
+	}
+	if info.MAC != "" {
+		device.Connections = []haPair{{"mac", strings.ToLower(info.MAC)}}
+	}
+	// Entities are only available while both the bridge and the device are.
+	availability := []haAvailability{
+		{Topic: AvailabilityTopic(b.prefix)},
+		{Topic: b.deviceTopic(key, "availability")},
+	}
+	topic := func(component, object string) string {
+		return strings.Join([]string{b.discoveryPrefix, component, node, object, "config"}, "/")
+	}
+
+	configs := make(map[string]haEntity)
+	if isBulb(info) {
+		light := haEntity{
+			Name:                info.Alias,
+			UniqueID:            node + "_light",
+			StateTopic:          b.deviceTopic(key, "light"),
+			CommandTopic:        b.deviceTopic(key, "light", "set"),
+			Availability:        availability,
+			AvailabilityMode:    "all",
+			Device:              device,
+			Schema:              "json",
+			ColorMode:           true,
+			SupportedColorModes: []string{"onoff"},
+		}
+		if info.IsDimmable != 0 {
+			light.Brightness = true
+			light.BrightnessScale = 100
+			light.SupportedColorModes = []string{"brightness"}
+		}
+		if info.IsVariableColorTemp != 0 || info.IsColor != 0 {
+			light.Brightness = true
+			light.BrightnessScale = 100
+			light.SupportedColorModes = nil
+			if info.IsVariableColorTemp != 0 {
+				light.SupportedColorModes = append(light.SupportedColorModes, "color_temp")
+				light.MinMireds = kelvinToMireds(maxColorTempKelvin)
+				light.MaxMireds = kelvinToMireds(minColorTempKelvin)
+			}
+			if info.IsColor != 0 {
+				light.SupportedColorModes = append(light.SupportedColorModes, "hs")
+			}
+		}
+		configs[topic("light", "light")] = light
+		return configs
+	}
+
+	configs[topic("switch", "relay")] = haEntity{
+		Name:             info.Alias,
+		UniqueID:         node + "_relay",
+		StateTopic:       b.deviceTopic(key, "state"),
+		CommandTopic:     b.deviceTopic(key, "relay", "set"),
+		Availability:     availability,
+		AvailabilityMode: "all",
+		ValueTemplate:    "{{ value_json.relay }}",
+		PayloadOn:        "ON",
+		PayloadOff:       "OFF",
+		Device:           device,
+	}
+	if hasEmeter(info) {
+		for _, s := range emeterSensors {
+			configs[topic("sensor", s.field)] = haEntity{
+				Name:              fmt.Sprintf("%v %v", info.Alias, s.name),
+				UniqueID:          node + "_" + s.field,
+				StateTopic:        b.deviceTopic(key, "state"),
+				Availability:      availability,
+				AvailabilityMode:  "all",
+				ValueTemplate:     fmt.Sprintf("{{ value_json.%v }}", s.field),
+				DeviceClass:       s.deviceClass,
+				StateClass:        s.stateClass,
+				UnitOfMeasurement: s.unit,
+				Device:            device,
+			}
+		}
+	}
+	return configs
+}
+
+func kelvinToMireds(k int) int {
+	if k <= 0 {
+		return 0
+	}
+	return (1000000 + k/2) / k
+}
+
+type haColor struct {
+	H *int `json:"h,omitempty"`
+	S *int `json:"s,omitempty"`
+}
+
+// haLightState is the Home Assistant JSON schema light state.
+type haLightState struct {
+	State      string   `json:"state"`
+	Brightness *int     `json:"brightness,omitempty"`
+	ColorMode  string   `json:"color_mode,omitempty"`
+	ColorTemp  *int     `json:"color_temp,omitempty"`
+	Color      *haColor `json:"color,omitempty"`
+}
+
+func newLightState(ls *kasa.LightState) haLightState {
+	s := haLightState{
+		State:      onOff(ls.OnOff != nil && *ls.OnOff != 0),
+		Brightness: ls.Brightness,
+	}
+	switch {
+	case ls.ColorTemp != nil && *ls.ColorTemp > 0:
+		mireds := kelvinToMireds(*ls.ColorTemp)
+		s.ColorMode = "color_temp"
+		s.ColorTemp = &mireds
+	case ls.Hue != nil || ls.Saturation != nil:
+		s.ColorMode = "hs"
+		s.Color = &haColor{H: ls.Hue, S: ls.Saturation}
+	case ls.Brightness != nil:
+		s.ColorMode = "brightness"
+	default:
+		s.ColorMode = "onoff"
+	}
+	return s
+}
+
+// lightCommand is the Home Assistant JSON schema light command.
+type lightCommand struct {
+	State      string   `json:"state"`
+	Brightness *int     `json:"brightness"`
+	ColorTemp  *int     `json:"color_temp"`
+	Color      *haColor `json:"color"`
+	// Transition in seconds.
+	Transition *float64 `json:"transition"`
+}
+
+func (c lightCommand) lightState() (kasa.LightState, error) {
+	var ls kasa.LightState
+	switch strings.ToUpper(c.State) {
+	case "ON":
+		on := 1
+		ls.OnOff = &on
+	case "OFF":
+		off := 0
+		ls.OnOff = &off
+	case "":
+	default:
+		return ls, fmt.Errorf("%w: light state %q, want ON or OFF", ErrBadCommand, c.State)
+	}
+	ls.Brightness = c.Brightness
+	if c.ColorTemp != nil {
+		k := kelvinToMireds(*c.ColorTemp)
+		ls.ColorTemp = &k
+	}
+	if c.Color != nil {
+		// Bulbs only use hue and saturation when color temperature is zero.
+		zero := 0
+		ls.Hue, ls.Saturation, ls.ColorTemp = c.Color.H, c.Color.S, &zero
+	}
+	if c.Transition != nil {
+		ms := int(*c.Transition * 1000)
+		ls.TransitionPeriod = &ms
+	}
+	return ls, nil
+}
@@ -0,0 +1,108 @@
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// PahoConfig for connecting to a broker.
+type PahoConfig struct {
+	// Broker URL, for example tcp://localhost:1883 or ssl://broker:8883.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// AvailabilityTopic is set to "online" on connecting, and to "offline" by
+	// the broker when the connection is lost.
+	AvailabilityTopic string
+}
+
+// pahoTimeout bounds waiting for the broker to acknowledge a request.
+const pahoTimeout = 10 * time.Second
+
+// Paho is a Client using the Eclipse Paho MQTT library. It reconnects when the
+// connection to the broker is lost, restoring subscriptions.
+type Paho struct {
+	client       paho.Client
+	availability string
+
+	mu   sync.Mutex
+	subs map[string]paho.MessageHandler
+}
+
+// DialPaho connects to the broker.
+func DialPaho(cfg PahoConfig) (*Paho, error) {
+	p := &Paho{
+		availability: cfg.AvailabilityTopic,
+		subs:         make(map[string]paho.MessageHandler),
+	}
+	p.client = paho.NewClient(p.options(cfg))
+	if err := wait(p.client.Connect()); err != nil {
+		return nil, fmt.Errorf("connecting to %v: %w", cfg.Broker, err)
+	}
+	return p, nil
+}
+
+// options of the client connecting to the broker in cfg, which leaves the
+// availability topic "offline" as its last will.
+func (p *Paho) options(cfg PahoConfig) *paho.ClientOptions {
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(p.onConnect)
+	if cfg.AvailabilityTopic != "" {
+		opts.SetWill(cfg.AvailabilityTopic, "offline", 1, true)
+	}
+	return opts
+}
+
+func wait(t paho.Token) error {
+	if !t.WaitTimeout(pahoTimeout) {
+		return fmt.Errorf("no response from broker after %v", pahoTimeout)
+	}
+	return t.Error()
+}
+
+// onConnect announces availability and restores subscriptions, which are lost
+// with a clean session.
+func (p *Paho) onConnect(c paho.Client) {
+	if p.availability != "" {
+		c.Publish(p.availability, 1, true, "online")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for filter, handle := range p.subs {
+		c.Subscribe(filter, 1, handle)
+	}
+}
+
+// Publish implements Client.
+func (p *Paho) Publish(topic string, retained bool, payload []byte) error {
+	return wait(p.client.Publish(topic, 1, retained, payload))
+}
+
+// Subscribe implements Client.
+func (p *Paho) Subscribe(filter string, handle func(topic string, payload []byte)) error {
+	h := func(_ paho.Client, m paho.Message) {
+		handle(m.Topic(), m.Payload())
+	}
+	p.mu.Lock()
+	p.subs[filter] = h
+	p.mu.Unlock()
+	return wait(p.client.Subscribe(filter, 1, h))
+}
+
+// Close the connection. The broker is told the bridge is offline, since a
+// clean disconnect does not trigger the last will.
+func (p *Paho) Close() {
+	if p.availability != "" {
+		wait(p.client.Publish(p.availability, 1, true, "offline"))
+	}
+	p.client.Disconnect(250)
+}
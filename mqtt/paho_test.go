@@ -0,0 +1,262 @@
+package mqtt
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/go-cmp/cmp"
+)
+
+// token which has completed with err.
+type token struct{ err error }
+
+func (t token) Wait() bool                     { return true }
+func (t token) WaitTimeout(time.Duration) bool { return true }
+func (t token) Error() error                   { return t.err }
+
+func (t token) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// request made by the adapter to the paho client.
+type request struct {
+	Op       string
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  string
+}
+
+// fakePaho is a paho.Client which records requests, failing them with err.
+type fakePaho struct {
+	paho.Client // Unimplemented methods panic.
+
+	err error
+
+	mu       sync.Mutex
+	requests []request
+	handlers map[string]paho.MessageHandler
+}
+
+func (f *fakePaho) record(r request) paho.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+	return token{f.err}
+}
+
+func (f *fakePaho) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	var p string
+	switch payload := payload.(type) {
+	case string:
+		p = payload
+	case []byte:
+		p = string(payload)
+	}
+	return f.record(request{Op: "publish", Topic: topic, QoS: qos, Retained: retained, Payload: p})
+}
+
+func (f *fakePaho) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	f.mu.Lock()
+	if f.handlers == nil {
+		f.handlers = make(map[string]paho.MessageHandler)
+	}
+	f.handlers[topic] = callback
+	f.mu.Unlock()
+	return f.record(request{Op: "subscribe", Topic: topic, QoS: qos})
+}
+
+func (f *fakePaho) Disconnect(quiesce uint) {
+	f.record(request{Op: "disconnect"})
+}
+
+// take the requests made so far.
+func (f *fakePaho) take() []request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.requests
+	f.requests = nil
+	return r
+}
+
+// message received from the broker.
+type message struct {
+	paho.Message // Unimplemented methods panic.
+
+	topic   string
+	payload []byte
+}
+
+func (m message) Topic() string   { return m.topic }
+func (m message) Payload() []byte { return m.payload }
+
+func testPaho(availability string) (*Paho, *fakePaho) {
+	f := &fakePaho{}
+	return &Paho{
+		client:       f,
+		availability: availability,
+		subs:         make(map[string]paho.MessageHandler),
+	}, f
+}
+
+func TestPahoOptions(t *testing.T) {
+	type summary struct {
+		Servers      []string
+		ClientID     string
+		Username     string
+		Password     string
+		Reconnect    bool
+		WillEnabled  bool
+		WillTopic    string
+		WillPayload  string
+		WillQoS      byte
+		WillRetained bool
+	}
+	for tn, tc := range map[string]struct {
+		cfg  PahoConfig
+		want summary
+	}{
+		"with availability": {
+			cfg: PahoConfig{
+				Broker:            "tcp://broker:1883",
+				ClientID:          "kasa",
+				Username:          "user",
+				Password:          "secret",
+				AvailabilityTopic: "kasa/bridge/availability",
+			},
+			want: summary{
+				Servers:      []string{"tcp://broker:1883"},
+				ClientID:     "kasa",
+				Username:     "user",
+				Password:     "secret",
+				Reconnect:    true,
+				WillEnabled:  true,
+				WillTopic:    "kasa/bridge/availability",
+				WillPayload:  "offline",
+				WillQoS:      1,
+				WillRetained: true,
+			},
+		},
+		"without availability": {
+			cfg: PahoConfig{Broker: "ssl://broker:8883", ClientID: "kasa"},
+			want: summary{
+				Servers:   []string{"ssl://broker:8883"},
+				ClientID:  "kasa",
+				Reconnect: true,
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			p, _ := testPaho(tc.cfg.AvailabilityTopic)
+			opts := p.options(tc.cfg)
+			got := summary{
+				ClientID:     opts.ClientID,
+				Username:     opts.Username,
+				Password:     opts.Password,
+				Reconnect:    opts.AutoReconnect,
+				WillEnabled:  opts.WillEnabled,
+				WillTopic:    opts.WillTopic,
+				WillPayload:  string(opts.WillPayload),
+				WillQoS:      opts.WillQos,
+				WillRetained: opts.WillRetained,
+			}
+			for _, u := range opts.Servers {
+				got.Servers = append(got.Servers, u.String())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("options mismatch (-want +got):\n%s", diff)
+			}
+			if opts.OnConnect == nil {
+				t.Error("no OnConnect handler, so subscriptions are not restored")
+			}
+		})
+	}
+}
+
+func TestPahoPublish(t *testing.T) {
+	p, f := testPaho("")
+	if err := p.Publish("kasa/modem/state", true, []byte("ON")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish("kasa/modem/power", false, []byte("7.5")); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{
+		{Op: "publish", Topic: "kasa/modem/state", QoS: 1, Retained: true, Payload: "ON"},
+		{Op: "publish", Topic: "kasa/modem/power", QoS: 1, Payload: "7.5"},
+	}
+	if diff := cmp.Diff(want, f.take()); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	f.err = errors.New("not connected")
+	if err := p.Publish("kasa/modem/state", true, []byte("OFF")); !errors.Is(err, f.err) {
+		t.Errorf("Publish(): got error %v, want %v", err, f.err)
+	}
+}
+
+func TestPahoSubscribe(t *testing.T) {
+	p, f := testPaho("kasa/bridge/availability")
+	type received struct {
+		Topic, Payload string
+	}
+	var got []received
+	if err := p.Subscribe("kasa/+/set", func(topic string, payload []byte) {
+		got = append(got, received{topic, string(payload)})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]request{{Op: "subscribe", Topic: "kasa/+/set", QoS: 1}}, f.take()); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	f.handlers["kasa/+/set"](f, message{topic: "kasa/modem/set", payload: []byte("OFF")})
+	if diff := cmp.Diff([]received{{"kasa/modem/set", "OFF"}}, got); diff != "" {
+		t.Errorf("received mismatch (-want +got):\n%s", diff)
+	}
+
+	// Reconnecting announces availability and restores the subscription.
+	p.onConnect(f)
+	want := []request{
+		{Op: "publish", Topic: "kasa/bridge/availability", QoS: 1, Retained: true, Payload: "online"},
+		{Op: "subscribe", Topic: "kasa/+/set", QoS: 1},
+	}
+	if diff := cmp.Diff(want, f.take()); diff != "" {
+		t.Errorf("requests on connecting mismatch (-want +got):\n%s", diff)
+	}
+	f.handlers["kasa/+/set"](f, message{topic: "kasa/lamp/set", payload: []byte("ON")})
+	if diff := cmp.Diff([]received{{"kasa/modem/set", "OFF"}, {"kasa/lamp/set", "ON"}}, got); diff != "" {
+		t.Errorf("received after reconnecting mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPahoClose(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		availability string
+		want         []request
+	}{
+		"with availability": {
+			availability: "kasa/bridge/availability",
+			want: []request{
+				{Op: "publish", Topic: "kasa/bridge/availability", QoS: 1, Retained: true, Payload: "offline"},
+				{Op: "disconnect"},
+			},
+		},
+		"without availability": {
+			want: []request{{Op: "disconnect"}},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			p, f := testPaho(tc.availability)
+			p.Close()
+			if diff := cmp.Diff(tc.want, f.take()); diff != "" {
+				t.Errorf("requests mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+// Package notify sends webhook notifications of changes to Kasa devices, found
+// by polling devices and comparing successive polls.
+package notify
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// Defaults for a Notifier.
+const (
+	// DefaultDeviceTimeout of requests to devices.
+	DefaultDeviceTimeout = 5 * time.Second
+	// DefaultOfflineAfter is the number of polls in a row a device misses
+	// before it is offline.
+	DefaultOfflineAfter = 2
+)
+
+// EventType of a Notification.
+type EventType string
+
+// Events about which webhooks are notified.
+const (
+	// Offline devices stopped responding to polls, and have missed enough in
+	// a row. The notification's time is that of the first missed poll.
+	Offline EventType = "offline"
+	// Online devices responded again after being offline.
+	Online EventType = "online"
+	// Relay state changed, from Old to New.
+	Relay EventType = "relay"
+	// Power drawn rose above the webhook's threshold.
+	Power EventType = "power"
+	// Firmware version changed, from Old to New.
+	Firmware EventType = "firmware"
+)
+
+var knownEvents = map[EventType]bool{
+	Offline:  true,
+	Online:   true,
+	Relay:    true,
+	Power:    true,
+	Firmware: true,
+}
+
+// Notification sent to webhooks.
+type Notification struct {
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	DeviceID string    `json:"device_id"`
+	Alias    string    `json:"alias"`
+	Address  string    `json:"address"`
+	Old      string    `json:"old,omitempty"`
+	New      string    `json:"new,omitempty"`
+	// Power in watts, for power events.
+	Power float64 `json:"power,omitempty"`
+}
+
+func newNotification(t time.Time, typ EventType, info *kasa.SystemInformation) Notification {
+	n := Notification{
+		Time:     t,
+		Type:     typ,
+		DeviceID: info.DeviceID,
+		Alias:    info.Alias,
+	}
+	if info.RemoteAddress != nil {
+		n.Address = info.RemoteAddress.String()
+	}
+	return n
+}
+
+// Notifier polls devices, and notifies webhooks of changes.
+type Notifier struct {
+	inv          *inventory.Inventory
+	hooks        []Webhook
+	laddr        *net.UDPAddr
+	client       *http.Client
+	timeout      time.Duration
+	offlineAfter int
+	handleError  func(error)
+	now          func() time.Time
+
+	mu sync.Mutex
+	// firmware last seen of each device, by inventory.Key.
+	firmware map[string]string
+	// missing devices, which have missed too few polls to be offline, by
+	// inventory.Key.
+	missing map[string]*missingDevice
+	// offline devices, by inventory.Key.
+	offline map[string]bool
+	// above is whether each device was above each webhook's power threshold.
+	above []map[string]bool
+}
+
+// missingDevice has missed polls, the first of which was seen by Event.
+type missingDevice struct {
+	inventory.Event
+	polls int
+}
+
+type Option func(*Notifier)
+
+// WithLocalAddr from which requests are sent to devices.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(n *Notifier) {
+		n.laddr = laddr
+	}
+}
+
+// WithDeviceTimeout of each request to a device. Defaults to
+// DefaultDeviceTimeout.
+func WithDeviceTimeout(timeout time.Duration) Option {
+	return func(n *Notifier) {
+		n.timeout = timeout
+	}
+}
+
+// WithOfflineAfter is the number of polls in a row a device must miss before
+// webhooks are notified that it is offline, so that a single dropped response
+// is not reported as the device going offline and back online. Defaults to
+// DefaultOfflineAfter.
+func WithOfflineAfter(polls int) Option {
+	return func(n *Notifier) {
+		n.offlineAfter = polls
+	}
+}
+
+// WithHTTPClient used to deliver webhooks. Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(n *Notifier) {
+		n.client = client
+	}
+}
+
+// WithErrorHandler called with errors polling devices and delivering
+// webhooks, none of which stop the Notifier.
+func WithErrorHandler(handle func(error)) Option {
+	return func(n *Notifier) {
+		n.handleError = handle
+	}
+}
+
+// New Notifier of the webhooks about devices found using discover. Webhooks
+// should be valid.
+func New(discover inventory.DiscoverFunc, hooks []Webhook, opts ...Option) *Notifier {
+	n := &Notifier{
+		// Missed polls are counted by the Notifier, so that offline
+		// notifications carry the time of the first.
+		inv:          inventory.New(discover, inventory.DiffOptions{MissedPolls: 1}),
+		hooks:        hooks,
+		client:       http.DefaultClient,
+		timeout:      DefaultDeviceTimeout,
+		offlineAfter: DefaultOfflineAfter,
+		handleError:  func(error) {},
+		now:          time.Now,
+		firmware:     make(map[string]string),
+		missing:      make(map[string]*missingDevice),
+		offline:      make(map[string]bool),
+		above:        make([]map[string]bool, len(hooks)),
+	}
+	for i := range n.above {
+		n.above[i] = make(map[string]bool)
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Run polls every interval until the context is canceled. Webhooks notified
+// after a poll are delivered, including retries, before the next poll.
+func (n *Notifier) Run(ctx context.Context, interval time.Duration) error {
+	return n.inv.Run(ctx, interval, func(events []inventory.Event, err error) {
+		if err != nil {
+			n.handleError(err)
+			return
+		}
+		n.update(ctx, events)
+	})
+}
+
+// delivery of a notification to a single webhook.
+type delivery struct {
+	hook int
+	n    Notification
+}
+
+// update notifies webhooks of the changes seen by a poll.
+func (n *Notifier) update(ctx context.Context, events []inventory.Event) {
+	var notes []Notification
+	n.mu.Lock()
+	for _, e := range events {
+		key := inventory.Key(e.Info)
+		switch e.Type {
+		case inventory.DeviceDisappeared:
+			n.missing[key] = &missingDevice{Event: e}
+		case inventory.DeviceAppeared:
+			delete(n.missing, key)
+			if n.offline[key] {
+				delete(n.offline, key)
+				notes = append(notes, newNotification(e.Time, Online, e.Info))
+			}
+		case inventory.RelayChanged:
+			note := newNotification(e.Time, Relay, e.Info)
+			note.Old, note.New = e.Old, e.New
+			notes = append(notes, note)
+		}
+	}
+	notes = append(notes, n.missed()...)
+	now := n.now()
+	devices := n.inv.Devices()
+	for _, info := range devices {
+		// Firmware updates reboot the device, which may be missed by a poll,
+		// so the version is compared even across disappearances.
+		key := inventory.Key(info)
+		if old, seen := n.firmware[key]; seen && old != info.SoftwareVersion {
+			note := newNotification(now, Firmware, info)
+			note.Old, note.New = old, info.SoftwareVersion
+			notes = append(notes, note)
+		}
+		n.firmware[key] = info.SoftwareVersion
+	}
+	n.mu.Unlock()
+
+	var deliveries []delivery
+	for i := range n.hooks {
+		for _, note := range notes {
+			if n.hooks[i].wants(note) {
+				deliveries = append(deliveries, delivery{hook: i, n: note})
+			}
+		}
+	}
+	deliveries = append(deliveries, n.checkPower(ctx, now, devices)...)
+	n.deliver(ctx, deliveries)
+}
+
+// missed counts a poll missed by each missing device, returning notifications
+// of those which are now offline. It is called with mu held.
+func (n *Notifier) missed() []Notification {
+	var notes []Notification
+	for _, key := range sortedKeys(n.missing) {
+		m := n.missing[key]
+		if m.polls++; m.polls < n.offlineAfter {
+			continue
+		}
+		delete(n.missing, key)
+		n.offline[key] = true
+		notes = append(notes, newNotification(m.Time, Offline, m.Info))
+	}
+	return notes
+}
+
+func sortedKeys(m map[string]*missingDevice) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hasEmeter(info *kasa.SystemInformation) bool {
+	return strings.Contains(info.Feature, "ENE")
+}
+
+// checkPower of devices watched by webhooks with a power threshold, returning
+// notifications of devices which rose above a threshold since the last poll.
+func (n *Notifier) checkPower(ctx context.Context, now time.Time, devices []*kasa.SystemInformation) []delivery {
+	var deliveries []delivery
+	for _, info := range devices {
+		if !hasEmeter(info) || info.RemoteAddress == nil {
+			continue
+		}
+		note := newNotification(now, Power, info)
+		var hooks []int
+		for i, h := range n.hooks {
+			if h.PowerAbove > 0 && h.wants(note) {
+				hooks = append(hooks, i)
+			}
+		}
+		if len(hooks) == 0 {
+			continue
+		}
+		e, err := n.getEmeter(ctx, info.RemoteAddress)
+		if err != nil {
+			n.handleError(err)
+			continue
+		}
+		key := inventory.Key(info)
+		n.mu.Lock()
+		for _, i := range hooks {
+			above := e.Power > n.hooks[i].PowerAbove
+			if above && !n.above[i][key] {
+				note := note
+				note.Power = e.Power
+				note.New = strconv.FormatFloat(e.Power, 'f', -1, 64)
+				deliveries = append(deliveries, delivery{hook: i, n: note})
+			}
+			n.above[i][key] = above
+		}
+		n.mu.Unlock()
+	}
+	return deliveries
+}
+
+func (n *Notifier) getEmeter(ctx context.Context, raddr *net.UDPAddr) (*kasa.EmeterRealtime, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	return kasa.GetEmeterRealtime(ctx, raddr, n.laddr)
+}
+
+// deliver notifications concurrently, returning once all have succeeded or
+// failed.
+func (n *Notifier) deliver(ctx context.Context, deliveries []delivery) {
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func(d delivery) {
+			defer wg.Done()
+			if err := n.hooks[d.hook].deliver(ctx, n.client, d.n); err != nil {
+				n.handleError(err)
+			}
+		}(d)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,251 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+// receiver records requests made to an httptest server.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	// statuses returned by successive requests, then 200.
+	statuses []int
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, string(body))
+		if len(r.statuses) > 0 {
+			w.WriteHeader(r.statuses[0])
+			r.statuses = r.statuses[1:]
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// notifications received, decoded from the default body.
+func (r *receiver) notifications(t *testing.T) []Notification {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var got []Notification
+	for _, b := range r.bodies {
+		var n Notification
+		if err := json.Unmarshal([]byte(b), &n); err != nil {
+			t.Fatalf("decoding %q: %v", b, err)
+		}
+		got = append(got, n)
+	}
+	r.bodies = nil
+	return got
+}
+
+// testDevices starts a modem, with an energy meter, and a heater.
+func testDevices(t *testing.T) (modem, heater *kasatest.Device) {
+	t.Helper()
+	modem = kasatest.Start(t,
+		kasatest.WithDeviceID("modem"),
+		kasatest.WithAlias("ADSL Modem"),
+		kasatest.WithRelay(true),
+		kasatest.WithEmeter(kasatest.Emeter{Power: 9.5}))
+	modem.Update(func(s map[string]interface{}) { s["sw_ver"] = "1.2.5" })
+	heater = kasatest.Start(t, kasatest.WithDeviceID("heater"), kasatest.WithAlias("Heater"))
+	heater.Update(func(s map[string]interface{}) { s["sw_ver"] = "1.0.3" })
+	return modem, heater
+}
+
+var at = time.Date(2021, time.May, 8, 17, 2, 11, 0, time.UTC)
+
+func testNotifier(t *testing.T, devices []*kasatest.Device, hooks ...Webhook) (*Notifier, func()) {
+	t.Helper()
+	n := New(kasatest.Discover(devices...), hooks, WithDeviceTimeout(kasatest.Timeout), WithErrorHandler(func(err error) {
+		t.Errorf("notifier error: %v", err)
+	}))
+	n.now = func() time.Time { return at }
+	poll := func() {
+		t.Helper()
+		events, err := n.inv.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range events {
+			events[i].Time = at
+		}
+		n.update(context.Background(), events)
+	}
+	return n, poll
+}
+
+// byName replaces the address of each notification with the name of the
+// device, as addresses are chosen when devices start.
+func byName(notes []Notification, names map[string]string) []Notification {
+	for i := range notes {
+		notes[i].Address = names[notes[i].Address]
+	}
+	return notes
+}
+
+func TestNotifierEvents(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		hook   Webhook
+		change func(modem, heater *kasatest.Device)
+		want   []Notification
+	}{
+		"no change": {
+			change: func(_, _ *kasatest.Device) {},
+		},
+		"relay toggled": {
+			change: func(modem, _ *kasatest.Device) {
+				modem.Update(func(s map[string]interface{}) { s["relay_state"] = 0 })
+			},
+			want: []Notification{
+				{Time: at, Type: Relay, DeviceID: "modem", Alias: "ADSL Modem", Address: "modem", Old: "on", New: "off"},
+			},
+		},
+		"firmware changed": {
+			change: func(_, heater *kasatest.Device) {
+				heater.Update(func(s map[string]interface{}) { s["sw_ver"] = "1.0.4" })
+			},
+			want: []Notification{
+				{Time: at, Type: Firmware, DeviceID: "heater", Alias: "Heater", Address: "heater", Old: "1.0.3", New: "1.0.4"},
+			},
+		},
+		"power above threshold": {
+			hook: Webhook{PowerAbove: 10},
+			change: func(modem, _ *kasatest.Device) {
+				modem.SetEmeter(kasatest.Emeter{Power: 12.5})
+			},
+			want: []Notification{
+				{Time: at, Type: Power, DeviceID: "modem", Alias: "ADSL Modem", Address: "modem", New: "12.5", Power: 12.5},
+			},
+		},
+		"power below threshold": {
+			hook: Webhook{PowerAbove: 10},
+			change: func(modem, _ *kasatest.Device) {
+				modem.SetEmeter(kasatest.Emeter{Power: 9.9})
+			},
+		},
+		"filtered by device": {
+			hook: Webhook{Devices: []string{"ADSL Modem"}},
+			change: func(modem, heater *kasatest.Device) {
+				modem.Update(func(s map[string]interface{}) { s["relay_state"] = 0 })
+				heater.Update(func(s map[string]interface{}) { s["relay_state"] = 1 })
+			},
+			want: []Notification{
+				{Time: at, Type: Relay, DeviceID: "modem", Alias: "ADSL Modem", Address: "modem", Old: "on", New: "off"},
+			},
+		},
+		"filtered by event": {
+			hook: Webhook{Events: []EventType{Offline}},
+			change: func(modem, _ *kasatest.Device) {
+				modem.Update(func(s map[string]interface{}) { s["relay_state"] = 0 })
+			},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			r := newReceiver(t)
+			modem, heater := testDevices(t)
+			tc.hook.URL = r.URL
+			_, poll := testNotifier(t, []*kasatest.Device{modem, heater}, tc.hook)
+			poll()
+			if got := r.notifications(t); len(got) > 0 {
+				t.Errorf("first poll notified: %v", got)
+			}
+			tc.change(modem, heater)
+			poll()
+			names := map[string]string{modem.Addr(): "modem", heater.Addr(): "heater"}
+			if diff := cmp.Diff(tc.want, byName(r.notifications(t), names)); diff != "" {
+				t.Errorf("notifications mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNotifierOfflineOnline(t *testing.T) {
+	offline := Notification{Time: at, Type: Offline, DeviceID: "heater", Alias: "Heater", Address: "heater"}
+	online := Notification{Time: at, Type: Online, DeviceID: "heater", Alias: "Heater", Address: "heater"}
+	for tn, tc := range map[string]struct {
+		offlineAfter int
+		// responds is whether the heater responds to each poll after the
+		// first.
+		responds []bool
+		want     []Notification
+	}{
+		"one missed poll": {
+			offlineAfter: DefaultOfflineAfter,
+			responds:     []bool{false, true},
+		},
+		"missed polls in a row": {
+			offlineAfter: DefaultOfflineAfter,
+			responds:     []bool{false, false, false, true},
+			want:         []Notification{offline, online},
+		},
+		"missed polls apart": {
+			offlineAfter: DefaultOfflineAfter,
+			responds:     []bool{false, true, false, true},
+		},
+		"offline after one missed poll": {
+			offlineAfter: 1,
+			responds:     []bool{false, true},
+			want:         []Notification{offline, online},
+		},
+		"still offline": {
+			offlineAfter: 3,
+			responds:     []bool{false, false, false},
+			want:         []Notification{offline},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			r := newReceiver(t)
+			modem, heater := testDevices(t)
+			n, poll := testNotifier(t, []*kasatest.Device{modem, heater}, Webhook{URL: r.URL, Devices: []string{"heater"}})
+			n.offlineAfter = tc.offlineAfter
+			poll()
+			for _, responds := range tc.responds {
+				heater.SetFaults(kasatest.Faults{Drop: !responds})
+				poll()
+			}
+			got := byName(r.notifications(t), map[string]string{heater.Addr(): "heater"})
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("notifications mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNotifierPowerOncePerCrossing(t *testing.T) {
+	r := newReceiver(t)
+	modem, heater := testDevices(t)
+	_, poll := testNotifier(t, []*kasatest.Device{modem, heater}, Webhook{URL: r.URL, PowerAbove: 10})
+	var got []float64
+	for _, p := range []float64{5, 12, 15, 8, 11} {
+		modem.SetEmeter(kasatest.Emeter{Power: p})
+		poll()
+		for _, n := range r.notifications(t) {
+			got = append(got, n.Power)
+		}
+	}
+	if diff := cmp.Diff([]float64{12, 11}, got); diff != "" {
+		t.Errorf("power notifications mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,222 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// Defaults for Webhook delivery.
+const (
+	DefaultRetries = 3
+	DefaultBackoff = time.Second
+	DefaultTimeout = 10 * time.Second
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, keyed with the
+// webhook's secret, as "sha256=" followed by the hex digest.
+const SignatureHeader = "X-Kasa-Signature"
+
+// Webhook receives notifications of device changes. For example:
+//
+//	url: https://chat.example.com/hooks/abc123
+//	events: [offline, power]
+//	devices: [modem]
+//	power_above: 15
+//	template: '{"text": {{ printf "%v is %v" .Alias .Type | json }}}'
+//	secret: hunter2
+type Webhook struct {
+	// URL to which notifications are POSTed.
+	URL string `yaml:"url"`
+	// Events which are sent. If empty, all are.
+	Events []EventType `yaml:"events,omitempty"`
+	// Devices about which notifications are sent, by device ID, alias or
+	// ip:port. If empty, notifications are sent for every device.
+	Devices []string `yaml:"devices,omitempty"`
+	// PowerAbove is the threshold in watts over which a power event is sent.
+	// If zero, power is not checked.
+	PowerAbove float64 `yaml:"power_above,omitempty"`
+	// Template of the request body, using text/template with a Notification.
+	// The json function encodes a value as JSON. If empty, the Notification is
+	// sent as JSON.
+	Template string `yaml:"template,omitempty"`
+	// Headers added to each request.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Secret with which requests are signed in the SignatureHeader, if set.
+	Secret string `yaml:"secret,omitempty"`
+	// Retries of failed deliveries. If zero, DefaultRetries are made; if
+	// negative, none are.
+	Retries int `yaml:"retries,omitempty"`
+	// Backoff before the first retry, which doubles for each subsequent retry.
+	// If zero, DefaultBackoff is used.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// Timeout of each request. If zero, DefaultTimeout is used.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Validate the webhook configuration.
+func (w *Webhook) Validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("%w: missing url", ErrInvalidWebhook)
+	}
+	for _, e := range w.Events {
+		if !knownEvents[e] {
+			return fmt.Errorf("%w: %v: unknown event %q", ErrInvalidWebhook, w.URL, e)
+		}
+	}
+	if w.PowerAbove < 0 {
+		return fmt.Errorf("%w: %v: negative power_above", ErrInvalidWebhook, w.URL)
+	}
+	if _, err := w.template(); err != nil {
+		return fmt.Errorf("%w: %v: %v", ErrInvalidWebhook, w.URL, err)
+	}
+	return nil
+}
+
+func (w *Webhook) template() (*template.Template, error) {
+	if w.Template == "" {
+		return nil, nil
+	}
+	return template.New("body").Funcs(templateFuncs).Option("missingkey=error").Parse(w.Template)
+}
+
+func (w *Webhook) wants(n Notification) bool {
+	if len(w.Events) > 0 {
+		found := false
+		for _, e := range w.Events {
+			found = found || e == n.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(w.Devices) == 0 {
+		return true
+	}
+	for _, d := range w.Devices {
+		if d == n.DeviceID || d == n.Alias || d == n.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// body of the request notifying n.
+func (w *Webhook) body(n Notification) ([]byte, error) {
+	t, err := w.template()
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return json.Marshal(n)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, n); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Sign the body with the secret, as sent in the SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errPermanent marks a failed delivery which is not worth retrying.
+type errPermanent struct {
+	err error
+}
+
+func (e errPermanent) Error() string { return e.err.Error() }
+func (e errPermanent) Unwrap() error { return e.err }
+
+// deliver the notification, retrying with exponential backoff.
+func (w *Webhook) deliver(ctx context.Context, client *http.Client, n Notification) error {
+	body, err := w.body(n)
+	if err != nil {
+		return fmt.Errorf("webhook %v: %w", w.URL, err)
+	}
+	retries := w.Retries
+	switch {
+	case retries == 0:
+		retries = DefaultRetries
+	case retries < 0:
+		retries = 0
+	}
+	backoff := w.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, client, body)
+		var perm errPermanent
+		if err == nil || errors.As(err, &perm) || attempt >= retries {
+			break
+		}
+		t := time.NewTimer(backoff << attempt)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("webhook %v: %w", w.URL, ctx.Err())
+		case <-t.C:
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("webhook %v: %w", w.URL, err)
+	}
+	return nil
+}
+
+func (w *Webhook) post(ctx context.Context, client *http.Client, body []byte) error {
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errPermanent{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return errPermanent{fmt.Errorf("unexpected status %v", resp.Status)}
+}
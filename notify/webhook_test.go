@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testNotification = Notification{
+	Time:     at,
+	Type:     Relay,
+	DeviceID: "modem",
+	Alias:    `ADSL "Modem"`,
+	Address:  "10.24.6.14:9999",
+	Old:      "on",
+	New:      "off",
+}
+
+func TestWebhookBody(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		template string
+		want     string
+	}{
+		"default": {
+			want: `{"time":"2021-05-08T17:02:11Z","type":"relay","device_id":"modem","alias":"ADSL \"Modem\"","address":"10.24.6.14:9999","old":"on","new":"off"}`,
+		},
+		"template": {
+			template: `{"text": {{ printf "%v turned %v" .Alias .New | json }}}`,
+			want:     `{"text": "ADSL \"Modem\" turned off"}`,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			w := &Webhook{URL: "http://example.com", Template: tc.template}
+			got, err := w.body(testNotification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("body mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		hook    Webhook
+		wantErr bool
+	}{
+		"valid": {
+			hook: Webhook{URL: "http://example.com", Events: []EventType{Offline, Power}, PowerAbove: 10},
+		},
+		"missing url": {
+			hook:    Webhook{},
+			wantErr: true,
+		},
+		"unknown event": {
+			hook:    Webhook{URL: "http://example.com", Events: []EventType{"exploded"}},
+			wantErr: true,
+		},
+		"negative threshold": {
+			hook:    Webhook{URL: "http://example.com", PowerAbove: -1},
+			wantErr: true,
+		},
+		"bad template": {
+			hook:    Webhook{URL: "http://example.com", Template: "{{ .Alias "},
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			err := tc.hook.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate(): got %v, want error: %v", err, tc.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidWebhook) {
+				t.Errorf("Validate(): got %v, want ErrInvalidWebhook", err)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		statuses     []int
+		retries      int
+		wantRequests int
+		wantErr      bool
+	}{
+		"success": {
+			wantRequests: 1,
+		},
+		"retried": {
+			statuses:     []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+			retries:      2,
+			wantRequests: 3,
+		},
+		"retries exhausted": {
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			retries:      2,
+			wantRequests: 3,
+			wantErr:      true,
+		},
+		"not retried": {
+			statuses:     []int{http.StatusNotFound},
+			retries:      2,
+			wantRequests: 1,
+			wantErr:      true,
+		},
+		"retries disabled": {
+			statuses:     []int{http.StatusServiceUnavailable},
+			retries:      -1,
+			wantRequests: 1,
+			wantErr:      true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			r := newReceiver(t)
+			r.statuses = tc.statuses
+			w := &Webhook{
+				URL:     r.URL,
+				Secret:  "hunter2",
+				Headers: map[string]string{"X-Extra": "yes"},
+				Retries: tc.retries,
+				Backoff: time.Millisecond,
+			}
+			err := w.deliver(context.Background(), http.DefaultClient, testNotification)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("deliver(): got %v, want error: %v", err, tc.wantErr)
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if got := len(r.requests); got != tc.wantRequests {
+				t.Fatalf("got %v requests, want %v", got, tc.wantRequests)
+			}
+			req, body := r.requests[0], r.bodies[0]
+			for h, want := range map[string]string{
+				"Content-Type":  "application/json",
+				"X-Extra":       "yes",
+				SignatureHeader: Sign("hunter2", []byte(body)),
+			} {
+				if got := req.Header.Get(h); got != want {
+					t.Errorf("header %v: got %q, want %q", h, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"type":"relay"}' | openssl dgst -sha256 -hmac hunter2
+	want := "sha256=8a8e2dc18942d1bb24c15a82a14a69aa45d18eeab89578bea04a1d98cbce8e02"
+	if got := Sign("hunter2", []byte(`{"type":"relay"}`)); got != want {
+		t.Errorf("Sign(): got %q, want %q", got, want)
+	}
+}
+
+func TestWebhookDeliverCanceled(t *testing.T) {
+	r := newReceiver(t)
+	r.statuses = []int{http.StatusInternalServerError}
+	w := &Webhook{URL: r.URL, Backoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if err := w.deliver(ctx, http.DefaultClient, testNotification); !errors.Is(err, context.Canceled) {
+		t.Errorf("deliver(): got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,4 @@
+// Package kasapb is the generated protobuf and gRPC code for the Kasa service.
+package kasapb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kasa.proto
@@ -0,0 +1,1204 @@
+// Kasa service for listing and controlling Kasa devices on the local network.
+//
+// Generate kasa.pb.go and kasa_grpc.pb.go with go generate, which requires
+// protoc, protoc-gen-go and protoc-gen-go-grpc.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.17.3
+// source: kasa.proto
+
+package kasapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StateChange_Type int32
+
+const (
+	StateChange_TYPE_UNSPECIFIED        StateChange_Type = 0
+	StateChange_TYPE_DEVICE_APPEARED    StateChange_Type = 1
+	StateChange_TYPE_DEVICE_DISAPPEARED StateChange_Type = 2
+	StateChange_TYPE_ADDRESS_CHANGED    StateChange_Type = 3
+	StateChange_TYPE_ALIAS_CHANGED      StateChange_Type = 4
+	StateChange_TYPE_RELAY_CHANGED      StateChange_Type = 5
+	StateChange_TYPE_RSSI_DROPPED       StateChange_Type = 6
+)
+
+// Enum value maps for StateChange_Type.
+var (
+	StateChange_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_DEVICE_APPEARED",
+		2: "TYPE_DEVICE_DISAPPEARED",
+		3: "TYPE_ADDRESS_CHANGED",
+		4: "TYPE_ALIAS_CHANGED",
+		5: "TYPE_RELAY_CHANGED",
+		6: "TYPE_RSSI_DROPPED",
+	}
+	StateChange_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED":        0,
+		"TYPE_DEVICE_APPEARED":    1,
+		"TYPE_DEVICE_DISAPPEARED": 2,
+		"TYPE_ADDRESS_CHANGED":    3,
+		"TYPE_ALIAS_CHANGED":      4,
+		"TYPE_RELAY_CHANGED":      5,
+		"TYPE_RSSI_DROPPED":       6,
+	}
+)
+
+func (x StateChange_Type) Enum() *StateChange_Type {
+	p := new(StateChange_Type)
+	*p = x
+	return p
+}
+
+func (x StateChange_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StateChange_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_kasa_proto_enumTypes[0].Descriptor()
+}
+
+func (StateChange_Type) Type() protoreflect.EnumType {
+	return &file_kasa_proto_enumTypes[0]
+}
+
+func (x StateChange_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StateChange_Type.Descriptor instead.
+func (StateChange_Type) EnumDescriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{9, 0}
+}
+
+type ListDevicesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{0}
+}
+
+type ListDevicesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Devices []*SysInfo `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*SysInfo {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type GetSysInfoRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Device ID, or ip:port address, of the device.
+	Device string `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+}
+
+func (x *GetSysInfoRequest) Reset() {
+	*x = GetSysInfoRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetSysInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSysInfoRequest) ProtoMessage() {}
+
+func (x *GetSysInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSysInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetSysInfoRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetSysInfoRequest) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+type SetRelayStateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Device ID, or ip:port address, of the device.
+	Device string `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	On     bool   `protobuf:"varint,2,opt,name=on,proto3" json:"on,omitempty"`
+}
+
+func (x *SetRelayStateRequest) Reset() {
+	*x = SetRelayStateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SetRelayStateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetRelayStateRequest) ProtoMessage() {}
+
+func (x *SetRelayStateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetRelayStateRequest.ProtoReflect.Descriptor instead.
+func (*SetRelayStateRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SetRelayStateRequest) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *SetRelayStateRequest) GetOn() bool {
+	if x != nil {
+		return x.On
+	}
+	return false
+}
+
+type StreamStateChangesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Device IDs of the devices for which changes are streamed. If empty,
+	// changes to all devices are streamed.
+	Devices []string `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+}
+
+func (x *StreamStateChangesRequest) Reset() {
+	*x = StreamStateChangesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamStateChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamStateChangesRequest) ProtoMessage() {}
+
+func (x *StreamStateChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamStateChangesRequest.ProtoReflect.Descriptor instead.
+func (*StreamStateChangesRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamStateChangesRequest) GetDevices() []string {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type GetEnergyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Device ID, or ip:port address, of the device.
+	Device string `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+}
+
+func (x *GetEnergyRequest) Reset() {
+	*x = GetEnergyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetEnergyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetEnergyRequest) ProtoMessage() {}
+
+func (x *GetEnergyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetEnergyRequest.ProtoReflect.Descriptor instead.
+func (*GetEnergyRequest) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetEnergyRequest) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+type SysInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// ID by which the device is requested. This is the device ID, or the
+	// address of devices which do not report one.
+	Id              string    `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Address         string    `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	Alias           string    `protobuf:"bytes,3,opt,name=alias,proto3" json:"alias,omitempty"`
+	Model           string    `protobuf:"bytes,4,opt,name=model,proto3" json:"model,omitempty"`
+	DeviceId        string    `protobuf:"bytes,5,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	HardwareId      string    `protobuf:"bytes,6,opt,name=hardware_id,json=hardwareId,proto3" json:"hardware_id,omitempty"`
+	HardwareVersion string    `protobuf:"bytes,7,opt,name=hardware_version,json=hardwareVersion,proto3" json:"hardware_version,omitempty"`
+	SoftwareVersion string    `protobuf:"bytes,8,opt,name=software_version,json=softwareVersion,proto3" json:"software_version,omitempty"`
+	Mac             string    `protobuf:"bytes,9,opt,name=mac,proto3" json:"mac,omitempty"`
+	Feature         string    `protobuf:"bytes,10,opt,name=feature,proto3" json:"feature,omitempty"`
+	RelayOn         bool      `protobuf:"varint,11,opt,name=relay_on,json=relayOn,proto3" json:"relay_on,omitempty"`
+	OnTimeSeconds   int64     `protobuf:"varint,12,opt,name=on_time_seconds,json=onTimeSeconds,proto3" json:"on_time_seconds,omitempty"`
+	Rssi            int32     `protobuf:"varint,13,opt,name=rssi,proto3" json:"rssi,omitempty"`
+	LedOff          bool      `protobuf:"varint,14,opt,name=led_off,json=ledOff,proto3" json:"led_off,omitempty"`
+	Children        []*Outlet `protobuf:"bytes,15,rep,name=children,proto3" json:"children,omitempty"`
+	// Light state of smart bulbs.
+	Light *LightState `protobuf:"bytes,16,opt,name=light,proto3" json:"light,omitempty"`
+}
+
+func (x *SysInfo) Reset() {
+	*x = SysInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SysInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SysInfo) ProtoMessage() {}
+
+func (x *SysInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SysInfo.ProtoReflect.Descriptor instead.
+func (*SysInfo) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SysInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *SysInfo) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *SysInfo) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *SysInfo) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *SysInfo) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *SysInfo) GetHardwareId() string {
+	if x != nil {
+		return x.HardwareId
+	}
+	return ""
+}
+
+func (x *SysInfo) GetHardwareVersion() string {
+	if x != nil {
+		return x.HardwareVersion
+	}
+	return ""
+}
+
+func (x *SysInfo) GetSoftwareVersion() string {
+	if x != nil {
+		return x.SoftwareVersion
+	}
+	return ""
+}
+
+func (x *SysInfo) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *SysInfo) GetFeature() string {
+	if x != nil {
+		return x.Feature
+	}
+	return ""
+}
+
+func (x *SysInfo) GetRelayOn() bool {
+	if x != nil {
+		return x.RelayOn
+	}
+	return false
+}
+
+func (x *SysInfo) GetOnTimeSeconds() int64 {
+	if x != nil {
+		return x.OnTimeSeconds
+	}
+	return 0
+}
+
+func (x *SysInfo) GetRssi() int32 {
+	if x != nil {
+		return x.Rssi
+	}
+	return 0
+}
+
+func (x *SysInfo) GetLedOff() bool {
+	if x != nil {
+		return x.LedOff
+	}
+	return false
+}
+
+func (x *SysInfo) GetChildren() []*Outlet {
+	if x != nil {
+		return x.Children
+	}
+	return nil
+}
+
+func (x *SysInfo) GetLight() *LightState {
+	if x != nil {
+		return x.Light
+	}
+	return nil
+}
+
+// Outlet of a power strip.
+type Outlet struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id            string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Alias         string `protobuf:"bytes,2,opt,name=alias,proto3" json:"alias,omitempty"`
+	On            bool   `protobuf:"varint,3,opt,name=on,proto3" json:"on,omitempty"`
+	OnTimeSeconds int64  `protobuf:"varint,4,opt,name=on_time_seconds,json=onTimeSeconds,proto3" json:"on_time_seconds,omitempty"`
+}
+
+func (x *Outlet) Reset() {
+	*x = Outlet{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Outlet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Outlet) ProtoMessage() {}
+
+func (x *Outlet) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Outlet.ProtoReflect.Descriptor instead.
+func (*Outlet) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Outlet) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Outlet) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *Outlet) GetOn() bool {
+	if x != nil {
+		return x.On
+	}
+	return false
+}
+
+func (x *Outlet) GetOnTimeSeconds() int64 {
+	if x != nil {
+		return x.OnTimeSeconds
+	}
+	return 0
+}
+
+type LightState struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	On bool `protobuf:"varint,1,opt,name=on,proto3" json:"on,omitempty"`
+	// Brightness and saturation in percent, hue in degrees and color
+	// temperature in kelvin. Color temperature is zero when a color is set.
+	Brightness int32 `protobuf:"varint,2,opt,name=brightness,proto3" json:"brightness,omitempty"`
+	Hue        int32 `protobuf:"varint,3,opt,name=hue,proto3" json:"hue,omitempty"`
+	Saturation int32 `protobuf:"varint,4,opt,name=saturation,proto3" json:"saturation,omitempty"`
+	ColorTemp  int32 `protobuf:"varint,5,opt,name=color_temp,json=colorTemp,proto3" json:"color_temp,omitempty"`
+}
+
+func (x *LightState) Reset() {
+	*x = LightState{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LightState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LightState) ProtoMessage() {}
+
+func (x *LightState) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LightState.ProtoReflect.Descriptor instead.
+func (*LightState) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *LightState) GetOn() bool {
+	if x != nil {
+		return x.On
+	}
+	return false
+}
+
+func (x *LightState) GetBrightness() int32 {
+	if x != nil {
+		return x.Brightness
+	}
+	return 0
+}
+
+func (x *LightState) GetHue() int32 {
+	if x != nil {
+		return x.Hue
+	}
+	return 0
+}
+
+func (x *LightState) GetSaturation() int32 {
+	if x != nil {
+		return x.Saturation
+	}
+	return 0
+}
+
+func (x *LightState) GetColorTemp() int32 {
+	if x != nil {
+		return x.ColorTemp
+	}
+	return 0
+}
+
+type StateChange struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Type StateChange_Type       `protobuf:"varint,2,opt,name=type,proto3,enum=kasa.v1.StateChange_Type" json:"type,omitempty"`
+	Id   string                 `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`
+	// Previous and new values of the changed attribute, if any.
+	OldValue string `protobuf:"bytes,4,opt,name=old_value,json=oldValue,proto3" json:"old_value,omitempty"`
+	NewValue string `protobuf:"bytes,5,opt,name=new_value,json=newValue,proto3" json:"new_value,omitempty"`
+	// Device as of the change, or as last seen when it disappeared.
+	Device *SysInfo `protobuf:"bytes,6,opt,name=device,proto3" json:"device,omitempty"`
+}
+
+func (x *StateChange) Reset() {
+	*x = StateChange{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StateChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateChange) ProtoMessage() {}
+
+func (x *StateChange) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateChange.ProtoReflect.Descriptor instead.
+func (*StateChange) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StateChange) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *StateChange) GetType() StateChange_Type {
+	if x != nil {
+		return x.Type
+	}
+	return StateChange_TYPE_UNSPECIFIED
+}
+
+func (x *StateChange) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *StateChange) GetOldValue() string {
+	if x != nil {
+		return x.OldValue
+	}
+	return ""
+}
+
+func (x *StateChange) GetNewValue() string {
+	if x != nil {
+		return x.NewValue
+	}
+	return ""
+}
+
+func (x *StateChange) GetDevice() *SysInfo {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+type Energy struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	PowerWatts     float64 `protobuf:"fixed64,1,opt,name=power_watts,json=powerWatts,proto3" json:"power_watts,omitempty"`
+	VoltageVolts   float64 `protobuf:"fixed64,2,opt,name=voltage_volts,json=voltageVolts,proto3" json:"voltage_volts,omitempty"`
+	CurrentAmperes float64 `protobuf:"fixed64,3,opt,name=current_amperes,json=currentAmperes,proto3" json:"current_amperes,omitempty"`
+	TotalWattHours float64 `protobuf:"fixed64,4,opt,name=total_watt_hours,json=totalWattHours,proto3" json:"total_watt_hours,omitempty"`
+}
+
+func (x *Energy) Reset() {
+	*x = Energy{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_kasa_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Energy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Energy) ProtoMessage() {}
+
+func (x *Energy) ProtoReflect() protoreflect.Message {
+	mi := &file_kasa_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Energy.ProtoReflect.Descriptor instead.
+func (*Energy) Descriptor() ([]byte, []int) {
+	return file_kasa_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Energy) GetPowerWatts() float64 {
+	if x != nil {
+		return x.PowerWatts
+	}
+	return 0
+}
+
+func (x *Energy) GetVoltageVolts() float64 {
+	if x != nil {
+		return x.VoltageVolts
+	}
+	return 0
+}
+
+func (x *Energy) GetCurrentAmperes() float64 {
+	if x != nil {
+		return x.CurrentAmperes
+	}
+	return 0
+}
+
+func (x *Energy) GetTotalWattHours() float64 {
+	if x != nil {
+		return x.TotalWattHours
+	}
+	return 0
+}
+
+var File_kasa_proto protoreflect.FileDescriptor
+
+var file_kasa_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x6b, 0x61,
+	0x73, 0x61, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x14, 0x0a, 0x12, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x41, 0x0a, 0x13,
+	0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x2a, 0x0a, 0x07, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53,
+	0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x07, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x22,
+	0x2b, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x53, 0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x22, 0x3e, 0x0a, 0x14,
+	0x53, 0x65, 0x74, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x12, 0x0e, 0x0a, 0x02,
+	0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6e, 0x22, 0x35, 0x0a, 0x19,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6e, 0x67,
+	0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x64, 0x65, 0x76, 0x69,
+	0x63, 0x65, 0x73, 0x22, 0x2a, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x45, 0x6e, 0x65, 0x72, 0x67, 0x79,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x22,
+	0xe7, 0x03, 0x0a, 0x07, 0x53, 0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0e, 0x0a, 0x02, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x61,
+	0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64,
+	0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x6d,
+	0x6f, 0x64, 0x65, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6d, 0x6f, 0x64, 0x65,
+	0x6c, 0x12, 0x1b, 0x0a, 0x09, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x12, 0x1f,
+	0x0a, 0x0b, 0x68, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x68, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72, 0x65, 0x49, 0x64, 0x12,
+	0x29, 0x0a, 0x10, 0x68, 0x61, 0x72, 0x64, 0x77, 0x61, 0x72, 0x65, 0x5f, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x68, 0x61, 0x72, 0x64, 0x77,
+	0x61, 0x72, 0x65, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x29, 0x0a, 0x10, 0x73, 0x6f,
+	0x66, 0x74, 0x77, 0x61, 0x72, 0x65, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x73, 0x6f, 0x66, 0x74, 0x77, 0x61, 0x72, 0x65, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x63, 0x18, 0x09, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6d, 0x61, 0x63, 0x12, 0x18, 0x0a, 0x07, 0x66, 0x65, 0x61, 0x74, 0x75,
+	0x72, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72,
+	0x65, 0x12, 0x19, 0x0a, 0x08, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x5f, 0x6f, 0x6e, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x07, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x4f, 0x6e, 0x12, 0x26, 0x0a, 0x0f,
+	0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18,
+	0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x6f, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63,
+	0x6f, 0x6e, 0x64, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x73, 0x73, 0x69, 0x18, 0x0d, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x04, 0x72, 0x73, 0x73, 0x69, 0x12, 0x17, 0x0a, 0x07, 0x6c, 0x65, 0x64, 0x5f,
+	0x6f, 0x66, 0x66, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x6c, 0x65, 0x64, 0x4f, 0x66,
+	0x66, 0x12, 0x2b, 0x0a, 0x08, 0x63, 0x68, 0x69, 0x6c, 0x64, 0x72, 0x65, 0x6e, 0x18, 0x0f, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x4f, 0x75,
+	0x74, 0x6c, 0x65, 0x74, 0x52, 0x08, 0x63, 0x68, 0x69, 0x6c, 0x64, 0x72, 0x65, 0x6e, 0x12, 0x29,
+	0x0a, 0x05, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e,
+	0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x67, 0x68, 0x74, 0x53, 0x74, 0x61,
+	0x74, 0x65, 0x52, 0x05, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x22, 0x66, 0x0a, 0x06, 0x4f, 0x75, 0x74,
+	0x6c, 0x65, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x02, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x12, 0x0e, 0x0a, 0x02, 0x6f, 0x6e, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6e, 0x12, 0x26, 0x0a, 0x0f, 0x6f, 0x6e, 0x5f,
+	0x74, 0x69, 0x6d, 0x65, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0d, 0x6f, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64,
+	0x73, 0x22, 0x8d, 0x01, 0x0a, 0x0a, 0x4c, 0x69, 0x67, 0x68, 0x74, 0x53, 0x74, 0x61, 0x74, 0x65,
+	0x12, 0x0e, 0x0a, 0x02, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6e,
+	0x12, 0x1e, 0x0a, 0x0a, 0x62, 0x72, 0x69, 0x67, 0x68, 0x74, 0x6e, 0x65, 0x73, 0x73, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x62, 0x72, 0x69, 0x67, 0x68, 0x74, 0x6e, 0x65, 0x73, 0x73,
+	0x12, 0x10, 0x0a, 0x03, 0x68, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x68,
+	0x75, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x73, 0x61, 0x74, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x73, 0x61, 0x74, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x6f, 0x6c, 0x6f, 0x72, 0x5f, 0x74, 0x65, 0x6d, 0x70,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x63, 0x6f, 0x6c, 0x6f, 0x72, 0x54, 0x65, 0x6d,
+	0x70, 0x22, 0x97, 0x03, 0x0a, 0x0b, 0x53, 0x74, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6e, 0x67,
+	0x65, 0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69, 0x6d,
+	0x65, 0x12, 0x2d, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x19, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x43,
+	0x68, 0x61, 0x6e, 0x67, 0x65, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65,
+	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
+	0x12, 0x1b, 0x0a, 0x09, 0x6f, 0x6c, 0x64, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x08, 0x6f, 0x6c, 0x64, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x1b, 0x0a,
+	0x09, 0x6e, 0x65, 0x77, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x6e, 0x65, 0x77, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x28, 0x0a, 0x06, 0x64, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x6b, 0x61, 0x73,
+	0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x06, 0x64, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x22, 0xb4, 0x01, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a,
+	0x10, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45,
+	0x44, 0x10, 0x00, 0x12, 0x18, 0x0a, 0x14, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x44, 0x45, 0x56, 0x49,
+	0x43, 0x45, 0x5f, 0x41, 0x50, 0x50, 0x45, 0x41, 0x52, 0x45, 0x44, 0x10, 0x01, 0x12, 0x1b, 0x0a,
+	0x17, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x44, 0x45, 0x56, 0x49, 0x43, 0x45, 0x5f, 0x44, 0x49, 0x53,
+	0x41, 0x50, 0x50, 0x45, 0x41, 0x52, 0x45, 0x44, 0x10, 0x02, 0x12, 0x18, 0x0a, 0x14, 0x54, 0x59,
+	0x50, 0x45, 0x5f, 0x41, 0x44, 0x44, 0x52, 0x45, 0x53, 0x53, 0x5f, 0x43, 0x48, 0x41, 0x4e, 0x47,
+	0x45, 0x44, 0x10, 0x03, 0x12, 0x16, 0x0a, 0x12, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x41, 0x4c, 0x49,
+	0x41, 0x53, 0x5f, 0x43, 0x48, 0x41, 0x4e, 0x47, 0x45, 0x44, 0x10, 0x04, 0x12, 0x16, 0x0a, 0x12,
+	0x54, 0x59, 0x50, 0x45, 0x5f, 0x52, 0x45, 0x4c, 0x41, 0x59, 0x5f, 0x43, 0x48, 0x41, 0x4e, 0x47,
+	0x45, 0x44, 0x10, 0x05, 0x12, 0x15, 0x0a, 0x11, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x52, 0x53, 0x53,
+	0x49, 0x5f, 0x44, 0x52, 0x4f, 0x50, 0x50, 0x45, 0x44, 0x10, 0x06, 0x22, 0xa1, 0x01, 0x0a, 0x06,
+	0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x12, 0x1f, 0x0a, 0x0b, 0x70, 0x6f, 0x77, 0x65, 0x72, 0x5f,
+	0x77, 0x61, 0x74, 0x74, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x70, 0x6f, 0x77,
+	0x65, 0x72, 0x57, 0x61, 0x74, 0x74, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x76, 0x6f, 0x6c, 0x74, 0x61,
+	0x67, 0x65, 0x5f, 0x76, 0x6f, 0x6c, 0x74, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0c,
+	0x76, 0x6f, 0x6c, 0x74, 0x61, 0x67, 0x65, 0x56, 0x6f, 0x6c, 0x74, 0x73, 0x12, 0x27, 0x0a, 0x0f,
+	0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x5f, 0x61, 0x6d, 0x70, 0x65, 0x72, 0x65, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x41, 0x6d,
+	0x70, 0x65, 0x72, 0x65, 0x73, 0x12, 0x28, 0x0a, 0x10, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x77,
+	0x61, 0x74, 0x74, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x0e, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x57, 0x61, 0x74, 0x74, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x32,
+	0xd9, 0x02, 0x0a, 0x04, 0x4b, 0x61, 0x73, 0x61, 0x12, 0x48, 0x0a, 0x0b, 0x4c, 0x69, 0x73, 0x74,
+	0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x12, 0x1b, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76,
+	0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x4c,
+	0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x3a, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x53, 0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f,
+	0x12, 0x1a, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x79,
+	0x73, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x6b,
+	0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x40,
+	0x0a, 0x0d, 0x53, 0x65, 0x74, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12,
+	0x1d, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x52, 0x65, 0x6c,
+	0x61, 0x79, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10,
+	0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x79, 0x73, 0x49, 0x6e, 0x66, 0x6f,
+	0x12, 0x50, 0x0a, 0x12, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x43,
+	0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x12, 0x22, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31,
+	0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6e,
+	0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x6b, 0x61, 0x73,
+	0x61, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65,
+	0x30, 0x01, 0x12, 0x37, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x12,
+	0x19, 0x2e, 0x6b, 0x61, 0x73, 0x61, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x45, 0x6e, 0x65,
+	0x72, 0x67, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x6b, 0x61, 0x73,
+	0x61, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x42, 0x28, 0x5a, 0x26, 0x67,
+	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x66, 0x75, 0x6e, 0x6b, 0x68,
+	0x6f, 0x75, 0x73, 0x65, 0x72, 0x2f, 0x6b, 0x61, 0x73, 0x61, 0x2f, 0x72, 0x70, 0x63, 0x2f, 0x6b,
+	0x61, 0x73, 0x61, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_kasa_proto_rawDescOnce sync.Once
+	file_kasa_proto_rawDescData = file_kasa_proto_rawDesc
+)
+
+func file_kasa_proto_rawDescGZIP() []byte {
+	file_kasa_proto_rawDescOnce.Do(func() {
+		file_kasa_proto_rawDescData = protoimpl.X.CompressGZIP(file_kasa_proto_rawDescData)
+	})
+	return file_kasa_proto_rawDescData
+}
+
+var file_kasa_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_kasa_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_kasa_proto_goTypes = []interface{}{
+	(StateChange_Type)(0),             // 0: kasa.v1.StateChange.Type
+	(*ListDevicesRequest)(nil),        // 1: kasa.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),       // 2: kasa.v1.ListDevicesResponse
+	(*GetSysInfoRequest)(nil),         // 3: kasa.v1.GetSysInfoRequest
+	(*SetRelayStateRequest)(nil),      // 4: kasa.v1.SetRelayStateRequest
+	(*StreamStateChangesRequest)(nil), // 5: kasa.v1.StreamStateChangesRequest
+	(*GetEnergyRequest)(nil),          // 6: kasa.v1.GetEnergyRequest
+	(*SysInfo)(nil),                   // 7: kasa.v1.SysInfo
+	(*Outlet)(nil),                    // 8: kasa.v1.Outlet
+	(*LightState)(nil),                // 9: kasa.v1.LightState
+	(*StateChange)(nil),               // 10: kasa.v1.StateChange
+	(*Energy)(nil),                    // 11: kasa.v1.Energy
+	(*timestamppb.Timestamp)(nil),     // 12: google.protobuf.Timestamp
+}
+var file_kasa_proto_depIdxs = []int32{
+	7,  // 0: kasa.v1.ListDevicesResponse.devices:type_name -> kasa.v1.SysInfo
+	8,  // 1: kasa.v1.SysInfo.children:type_name -> kasa.v1.Outlet
+	9,  // 2: kasa.v1.SysInfo.light:type_name -> kasa.v1.LightState
+	12, // 3: kasa.v1.StateChange.time:type_name -> google.protobuf.Timestamp
+	0,  // 4: kasa.v1.StateChange.type:type_name -> kasa.v1.StateChange.Type
+	7,  // 5: kasa.v1.StateChange.device:type_name -> kasa.v1.SysInfo
+	1,  // 6: kasa.v1.Kasa.ListDevices:input_type -> kasa.v1.ListDevicesRequest
+	3,  // 7: kasa.v1.Kasa.GetSysInfo:input_type -> kasa.v1.GetSysInfoRequest
+	4,  // 8: kasa.v1.Kasa.SetRelayState:input_type -> kasa.v1.SetRelayStateRequest
+	5,  // 9: kasa.v1.Kasa.StreamStateChanges:input_type -> kasa.v1.StreamStateChangesRequest
+	6,  // 10: kasa.v1.Kasa.GetEnergy:input_type -> kasa.v1.GetEnergyRequest
+	2,  // 11: kasa.v1.Kasa.ListDevices:output_type -> kasa.v1.ListDevicesResponse
+	7,  // 12: kasa.v1.Kasa.GetSysInfo:output_type -> kasa.v1.SysInfo
+	7,  // 13: kasa.v1.Kasa.SetRelayState:output_type -> kasa.v1.SysInfo
+	10, // 14: kasa.v1.Kasa.StreamStateChanges:output_type -> kasa.v1.StateChange
+	11, // 15: kasa.v1.Kasa.GetEnergy:output_type -> kasa.v1.Energy
+	11, // [11:16] is the sub-list for method output_type
+	6,  // [6:11] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_kasa_proto_init() }
+func file_kasa_proto_init() {
+	if File_kasa_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_kasa_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListDevicesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListDevicesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetSysInfoRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SetRelayStateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamStateChangesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetEnergyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SysInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Outlet); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LightState); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StateChange); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_kasa_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Energy); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_kasa_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_kasa_proto_goTypes,
+		DependencyIndexes: file_kasa_proto_depIdxs,
+		EnumInfos:         file_kasa_proto_enumTypes,
+		MessageInfos:      file_kasa_proto_msgTypes,
+	}.Build()
+	File_kasa_proto = out.File
+	file_kasa_proto_rawDesc = nil
+	file_kasa_proto_goTypes = nil
+	file_kasa_proto_depIdxs = nil
+}
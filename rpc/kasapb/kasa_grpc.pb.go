@@ -0,0 +1,287 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package kasapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// KasaClient is the client API for Kasa service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KasaClient interface {
+	// ListDevices found by the most recent discovery poll.
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// GetSysInfo reads the current system information of a device.
+	GetSysInfo(ctx context.Context, in *GetSysInfoRequest, opts ...grpc.CallOption) (*SysInfo, error)
+	// SetRelayState of a plug or switch, returning its system information
+	// afterwards.
+	SetRelayState(ctx context.Context, in *SetRelayStateRequest, opts ...grpc.CallOption) (*SysInfo, error)
+	// StreamStateChanges seen between discovery polls. The stream begins with
+	// a DEVICE_APPEARED change for every device already known.
+	StreamStateChanges(ctx context.Context, in *StreamStateChangesRequest, opts ...grpc.CallOption) (Kasa_StreamStateChangesClient, error)
+	// GetEnergy reads the energy meter of a device.
+	GetEnergy(ctx context.Context, in *GetEnergyRequest, opts ...grpc.CallOption) (*Energy, error)
+}
+
+type kasaClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKasaClient(cc grpc.ClientConnInterface) KasaClient {
+	return &kasaClient{cc}
+}
+
+func (c *kasaClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, "/kasa.v1.Kasa/ListDevices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kasaClient) GetSysInfo(ctx context.Context, in *GetSysInfoRequest, opts ...grpc.CallOption) (*SysInfo, error) {
+	out := new(SysInfo)
+	err := c.cc.Invoke(ctx, "/kasa.v1.Kasa/GetSysInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kasaClient) SetRelayState(ctx context.Context, in *SetRelayStateRequest, opts ...grpc.CallOption) (*SysInfo, error) {
+	out := new(SysInfo)
+	err := c.cc.Invoke(ctx, "/kasa.v1.Kasa/SetRelayState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kasaClient) StreamStateChanges(ctx context.Context, in *StreamStateChangesRequest, opts ...grpc.CallOption) (Kasa_StreamStateChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Kasa_ServiceDesc.Streams[0], "/kasa.v1.Kasa/StreamStateChanges", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kasaStreamStateChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Kasa_StreamStateChangesClient interface {
+	Recv() (*StateChange, error)
+	grpc.ClientStream
+}
+
+type kasaStreamStateChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *kasaStreamStateChangesClient) Recv() (*StateChange, error) {
+	m := new(StateChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kasaClient) GetEnergy(ctx context.Context, in *GetEnergyRequest, opts ...grpc.CallOption) (*Energy, error) {
+	out := new(Energy)
+	err := c.cc.Invoke(ctx, "/kasa.v1.Kasa/GetEnergy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KasaServer is the server API for Kasa service.
+// All implementations must embed UnimplementedKasaServer
+// for forward compatibility
+type KasaServer interface {
+	// ListDevices found by the most recent discovery poll.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// GetSysInfo reads the current system information of a device.
+	GetSysInfo(context.Context, *GetSysInfoRequest) (*SysInfo, error)
+	// SetRelayState of a plug or switch, returning its system information
+	// afterwards.
+	SetRelayState(context.Context, *SetRelayStateRequest) (*SysInfo, error)
+	// StreamStateChanges seen between discovery polls. The stream begins with
+	// a DEVICE_APPEARED change for every device already known.
+	StreamStateChanges(*StreamStateChangesRequest, Kasa_StreamStateChangesServer) error
+	// GetEnergy reads the energy meter of a device.
+	GetEnergy(context.Context, *GetEnergyRequest) (*Energy, error)
+	mustEmbedUnimplementedKasaServer()
+}
+
+// UnimplementedKasaServer must be embedded to have forward compatible implementations.
+type UnimplementedKasaServer struct {
+}
+
+func (UnimplementedKasaServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedKasaServer) GetSysInfo(context.Context, *GetSysInfoRequest) (*SysInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSysInfo not implemented")
+}
+func (UnimplementedKasaServer) SetRelayState(context.Context, *SetRelayStateRequest) (*SysInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRelayState not implemented")
+}
+func (UnimplementedKasaServer) StreamStateChanges(*StreamStateChangesRequest, Kasa_StreamStateChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamStateChanges not implemented")
+}
+func (UnimplementedKasaServer) GetEnergy(context.Context, *GetEnergyRequest) (*Energy, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEnergy not implemented")
+}
+func (UnimplementedKasaServer) mustEmbedUnimplementedKasaServer() {}
+
+// UnsafeKasaServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KasaServer will
+// result in compilation errors.
+type UnsafeKasaServer interface {
+	mustEmbedUnimplementedKasaServer()
+}
+
+func RegisterKasaServer(s grpc.ServiceRegistrar, srv KasaServer) {
+	s.RegisterService(&Kasa_ServiceDesc, srv)
+}
+
+func _Kasa_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KasaServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kasa.v1.Kasa/ListDevices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KasaServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kasa_GetSysInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSysInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KasaServer).GetSysInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kasa.v1.Kasa/GetSysInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KasaServer).GetSysInfo(ctx, req.(*GetSysInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kasa_SetRelayState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRelayStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KasaServer).SetRelayState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kasa.v1.Kasa/SetRelayState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KasaServer).SetRelayState(ctx, req.(*SetRelayStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kasa_StreamStateChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamStateChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KasaServer).StreamStateChanges(m, &kasaStreamStateChangesServer{stream})
+}
+
+type Kasa_StreamStateChangesServer interface {
+	Send(*StateChange) error
+	grpc.ServerStream
+}
+
+type kasaStreamStateChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *kasaStreamStateChangesServer) Send(m *StateChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Kasa_GetEnergy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEnergyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KasaServer).GetEnergy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kasa.v1.Kasa/GetEnergy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KasaServer).GetEnergy(ctx, req.(*GetEnergyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Kasa_ServiceDesc is the grpc.ServiceDesc for Kasa service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Kasa_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kasa.v1.Kasa",
+	HandlerType: (*KasaServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDevices",
+			Handler:    _Kasa_ListDevices_Handler,
+		},
+		{
+			MethodName: "GetSysInfo",
+			Handler:    _Kasa_GetSysInfo_Handler,
+		},
+		{
+			MethodName: "SetRelayState",
+			Handler:    _Kasa_SetRelayState_Handler,
+		},
+		{
+			MethodName: "GetEnergy",
+			Handler:    _Kasa_GetEnergy_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamStateChanges",
+			Handler:       _Kasa_StreamStateChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kasa.proto",
+}
@@ -0,0 +1,290 @@
+// Package rpc implements the Kasa gRPC service defined in kasapb on top of the
+// kasa package.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/rpc/kasapb"
+)
+
+// DefaultTimeout of requests to devices, unless the RPC deadline is sooner.
+const DefaultTimeout = 5 * time.Second
+
+// streamBuffer is how many state changes may be waiting to be sent on a
+// stream before it is considered too slow, and ended.
+const streamBuffer = 64
+
+// Server implements kasapb.KasaServer.
+type Server struct {
+	kasapb.UnimplementedKasaServer
+
+	inv     *inventory.Inventory
+	laddr   *net.UDPAddr
+	timeout time.Duration
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type Option func(*Server)
+
+// WithLocalAddr from which requests are sent to devices.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(s *Server) {
+		s.laddr = laddr
+	}
+}
+
+// WithTimeout of each request to a device. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.timeout = timeout
+	}
+}
+
+// New Server for the devices found using discover.
+func New(discover inventory.DiscoverFunc, opts ...Option) *Server {
+	s := &Server{
+		inv:     inventory.New(discover, inventory.DiffOptions{}),
+		timeout: DefaultTimeout,
+		subs:    make(map[*subscriber]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run polls for devices every interval until the context is canceled, sending
+// changes to streams. Poll errors are passed to handleError, if it is not nil,
+// and do not stop polling.
+func (s *Server) Run(ctx context.Context, interval time.Duration, handleError func(error)) error {
+	return s.inv.Run(ctx, interval, func(events []inventory.Event, err error) {
+		if err != nil {
+			if handleError != nil {
+				handleError(err)
+			}
+			return
+		}
+		s.publish(events)
+	})
+}
+
+var errUnknownDevice = errors.New("unknown device")
+
+// resolve a device reference, which is the ID of a known device or an ip:port.
+func (s *Server) resolve(ref string) (*net.UDPAddr, error) {
+	if info, has := s.inv.Get(ref); has && info.RemoteAddress != nil {
+		return info.RemoteAddress, nil
+	}
+	if strings.Contains(ref, ":") {
+		if raddr, err := kasa.ParseAddr(ref); err == nil {
+			return raddr, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", errUnknownDevice, ref)
+}
+
+// call op on the device referred to by ref, returning any error as a status.
+func (s *Server) call(ctx context.Context, ref string, op func(ctx context.Context, raddr *net.UDPAddr) error) error {
+	raddr, err := s.resolve(ref)
+	if err != nil {
+		return statusErr(err)
+	}
+	return statusErr(op(ctx, raddr))
+}
+
+// Requests to devices, each bounded by the server's timeout unless the RPC
+// deadline is sooner.
+
+func (s *Server) getSysinfo(ctx context.Context, raddr *net.UDPAddr) (*kasa.SystemInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return kasa.GetDeviceSystemInformation(ctx, raddr, s.laddr)
+}
+
+func (s *Server) setRelayState(ctx context.Context, raddr *net.UDPAddr, state bool) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return kasa.SetRelayStateChecked(ctx, raddr, s.laddr, state)
+}
+
+func (s *Server) getEmeter(ctx context.Context, raddr *net.UDPAddr) (*kasa.EmeterRealtime, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return kasa.GetEmeterRealtime(ctx, raddr, s.laddr)
+}
+
+// statusErr converts an error to a gRPC status error.
+func statusErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var de *kasa.DeviceError
+	switch {
+	case errors.Is(err, errUnknownDevice):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &de):
+		switch de.Code {
+		case -1, -2: // module or member not supported
+			return status.Error(codes.Unimplemented, err.Error())
+		case -3:
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return status.Error(codes.Unknown, err.Error())
+	case errors.Is(err, kasa.ErrNoResponse):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, kasa.ErrMalformedResponse):
+		return status.Error(codes.Internal, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// ListDevices implements kasapb.KasaServer.
+func (s *Server) ListDevices(ctx context.Context, req *kasapb.ListDevicesRequest) (*kasapb.ListDevicesResponse, error) {
+	resp := &kasapb.ListDevicesResponse{}
+	for _, info := range s.inv.Devices() {
+		resp.Devices = append(resp.Devices, NewSysInfo(info))
+	}
+	return resp, nil
+}
+
+// GetSysInfo implements kasapb.KasaServer.
+func (s *Server) GetSysInfo(ctx context.Context, req *kasapb.GetSysInfoRequest) (*kasapb.SysInfo, error) {
+	var resp *kasapb.SysInfo
+	err := s.call(ctx, req.GetDevice(), func(ctx context.Context, raddr *net.UDPAddr) error {
+		info, err := s.getSysinfo(ctx, raddr)
+		if err != nil {
+			return err
+		}
+		resp = NewSysInfo(info)
+		return nil
+	})
+	return resp, err
+}
+
+// SetRelayState implements kasapb.KasaServer.
+func (s *Server) SetRelayState(ctx context.Context, req *kasapb.SetRelayStateRequest) (*kasapb.SysInfo, error) {
+	var resp *kasapb.SysInfo
+	err := s.call(ctx, req.GetDevice(), func(ctx context.Context, raddr *net.UDPAddr) error {
+		if err := s.setRelayState(ctx, raddr, req.GetOn()); err != nil {
+			return err
+		}
+		info, err := s.getSysinfo(ctx, raddr)
+		if err != nil {
+			return err
+		}
+		resp = NewSysInfo(info)
+		return nil
+	})
+	return resp, err
+}
+
+// GetEnergy implements kasapb.KasaServer.
+func (s *Server) GetEnergy(ctx context.Context, req *kasapb.GetEnergyRequest) (*kasapb.Energy, error) {
+	var resp *kasapb.Energy
+	err := s.call(ctx, req.GetDevice(), func(ctx context.Context, raddr *net.UDPAddr) error {
+		e, err := s.getEmeter(ctx, raddr)
+		if err != nil {
+			return err
+		}
+		resp = &kasapb.Energy{
+			PowerWatts:     e.Power,
+			VoltageVolts:   e.Voltage,
+			CurrentAmperes: e.Current,
+			TotalWattHours: e.Total,
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// NewSysInfo converts the device's system information to its protobuf form.
+func NewSysInfo(info *kasa.SystemInformation) *kasapb.SysInfo {
+	si := &kasapb.SysInfo{
+		Id:              inventory.Key(info),
+		Alias:           info.Alias,
+		Model:           info.Model,
+		DeviceId:        info.DeviceID,
+		HardwareId:      info.HardwareID,
+		HardwareVersion: info.HardwareVersion,
+		SoftwareVersion: info.SoftwareVersion,
+		Mac:             info.MAC,
+		Feature:         info.Feature,
+		RelayOn:         info.RelayState != 0,
+		OnTimeSeconds:   int64(info.OnTime),
+		Rssi:            int32(info.RSSI),
+		LedOff:          info.LEDOff != 0,
+	}
+	if info.RemoteAddress != nil {
+		si.Address = info.RemoteAddress.String()
+	}
+	for _, c := range info.Children {
+		si.Children = append(si.Children, &kasapb.Outlet{
+			Id:            c.ID,
+			Alias:         c.Alias,
+			On:            c.State != 0,
+			OnTimeSeconds: int64(c.OnTime),
+		})
+	}
+	if ls := info.LightState; ls != nil {
+		deref := func(i *int) int32 {
+			if i == nil {
+				return 0
+			}
+			return int32(*i)
+		}
+		si.Light = &kasapb.LightState{
+			On:         deref(ls.OnOff) != 0,
+			Brightness: deref(ls.Brightness),
+			Hue:        deref(ls.Hue),
+			Saturation: deref(ls.Saturation),
+			ColorTemp:  deref(ls.ColorTemp),
+		}
+	}
+	return si
+}
+
+var changeTypes = map[inventory.EventType]kasapb.StateChange_Type{
+	inventory.DeviceAppeared:    kasapb.StateChange_TYPE_DEVICE_APPEARED,
+	inventory.DeviceDisappeared: kasapb.StateChange_TYPE_DEVICE_DISAPPEARED,
+	inventory.AddressChanged:    kasapb.StateChange_TYPE_ADDRESS_CHANGED,
+	inventory.AliasChanged:      kasapb.StateChange_TYPE_ALIAS_CHANGED,
+	inventory.RelayChanged:      kasapb.StateChange_TYPE_RELAY_CHANGED,
+	inventory.RSSIDropped:       kasapb.StateChange_TYPE_RSSI_DROPPED,
+}
+
+// NewStateChange converts an inventory event to its protobuf form.
+func NewStateChange(e inventory.Event) *kasapb.StateChange {
+	sc := &kasapb.StateChange{
+		Time:     timestamppb.New(e.Time),
+		Type:     changeTypes[e.Type],
+		OldValue: e.Old,
+		NewValue: e.New,
+	}
+	if e.Info != nil {
+		sc.Id = inventory.Key(e.Info)
+		sc.Device = NewSysInfo(e.Info)
+	}
+	return sc
+}
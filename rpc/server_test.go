@@ -0,0 +1,284 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/kasatest"
+	"github.com/cfunkhouser/kasa/rpc/kasapb"
+)
+
+// testModem starts a plug without an energy meter.
+func testModem(t *testing.T, opts ...kasatest.Option) *kasatest.Device {
+	t.Helper()
+	return kasatest.Start(t, append([]kasatest.Option{kasatest.WithSysinfo(map[string]interface{}{
+		"err_code":    0,
+		"deviceId":    "modem",
+		"alias":       "ADSL Modem",
+		"model":       "HS110(US)",
+		"relay_state": 1,
+		"on_time":     3600,
+		"rssi":        -51,
+	})}, opts...)...)
+}
+
+// startServer serves s over an in-memory connection, returning a client.
+func startServer(t *testing.T, s *Server) kasapb.KasaClient {
+	t.Helper()
+	l := bufconn.Listen(1 << 16)
+	gs := grpc.NewServer()
+	kasapb.RegisterKasaServer(gs, s)
+	go gs.Serve(l)
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return l.Dial()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return kasapb.NewKasaClient(conn)
+}
+
+func testServer(t *testing.T, d *kasatest.Device) (*Server, kasapb.KasaClient) {
+	t.Helper()
+	s := New(kasatest.Discover(d), WithTimeout(kasatest.Timeout))
+	events, err := s.inv.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.publish(events)
+	return s, startServer(t, s)
+}
+
+// modemInfo started by testModem.
+func modemInfo(d *kasatest.Device) *kasapb.SysInfo {
+	return &kasapb.SysInfo{
+		Id:            "modem",
+		Address:       d.Addr(),
+		Alias:         "ADSL Modem",
+		Model:         "HS110(US)",
+		DeviceId:      "modem",
+		RelayOn:       true,
+		OnTimeSeconds: 3600,
+		Rssi:          -51,
+	}
+}
+
+// modemOff after its relay is switched off, which resets its on time.
+func modemOff(d *kasatest.Device) *kasapb.SysInfo {
+	off := modemInfo(d)
+	off.RelayOn = false
+	off.OnTimeSeconds = 0
+	return off
+}
+
+func TestListDevices(t *testing.T) {
+	d := testModem(t)
+	_, client := testServer(t, d)
+	got, err := client.ListDevices(context.Background(), &kasapb.ListDevicesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &kasapb.ListDevicesResponse{Devices: []*kasapb.SysInfo{modemInfo(d)}}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("ListDevices mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnaryCalls(t *testing.T) {
+	// A device which never responds.
+	gone := kasatest.Start(t, kasatest.WithFaults(kasatest.Faults{Drop: true}))
+
+	for tn, tc := range map[string]struct {
+		call     func(kasapb.KasaClient, *kasatest.Device) (interface{}, error)
+		want     func(*kasatest.Device) interface{}
+		wantCode codes.Code
+	}{
+		"get sysinfo by id": {
+			call: func(c kasapb.KasaClient, d *kasatest.Device) (interface{}, error) {
+				return c.GetSysInfo(context.Background(), &kasapb.GetSysInfoRequest{Device: "modem"})
+			},
+			want: func(d *kasatest.Device) interface{} { return modemInfo(d) },
+		},
+		"get sysinfo by address": {
+			call: func(c kasapb.KasaClient, d *kasatest.Device) (interface{}, error) {
+				return c.GetSysInfo(context.Background(), &kasapb.GetSysInfoRequest{Device: d.Addr()})
+			},
+			want: func(d *kasatest.Device) interface{} { return modemInfo(d) },
+		},
+		"get sysinfo of unknown device": {
+			call: func(c kasapb.KasaClient, d *kasatest.Device) (interface{}, error) {
+				return c.GetSysInfo(context.Background(), &kasapb.GetSysInfoRequest{Device: "nope"})
+			},
+			wantCode: codes.NotFound,
+		},
+		"get sysinfo without response": {
+			call: func(c kasapb.KasaClient, d *kasatest.Device) (interface{}, error) {
+				return c.GetSysInfo(context.Background(), &kasapb.GetSysInfoRequest{Device: gone.Addr()})
+			},
+			wantCode: codes.Unavailable,
+		},
+		"set relay state": {
+			call: func(c kasapb.KasaClient, d *kasatest.Device) (interface{}, error) {
+				return c.SetRelayState(context.Background(), &kasapb.SetRelayStateRequest{Device: "modem", On: false})
+			},
+			want: func(d *kasatest.Device) interface{} { return modemOff(d) },
+		},
+		"get energy without emeter": {
+			call: func(c kasapb.KasaClient, d *kasatest.Device) (interface{}, error) {
+				return c.GetEnergy(context.Background(), &kasapb.GetEnergyRequest{Device: "modem"})
+			},
+			wantCode: codes.Unimplemented,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			d := testModem(t)
+			_, client := testServer(t, d)
+			got, err := tc.call(client, d)
+			if code := status.Code(err); code != tc.wantCode {
+				t.Fatalf("got code %v (%v), want %v", code, err, tc.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want(d), got, protocmp.Transform()); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetEnergy(t *testing.T) {
+	d := testModem(t, kasatest.WithEmeter(kasatest.Emeter{Current: 0.25, Voltage: 120.5, Power: 7.5, Total: 1234}))
+	_, client := testServer(t, d)
+	got, err := client.GetEnergy(context.Background(), &kasapb.GetEnergyRequest{Device: "modem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &kasapb.Energy{PowerWatts: 7.5, VoltageVolts: 120.5, CurrentAmperes: 0.25, TotalWattHours: 1234}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("GetEnergy mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStreamStateChanges(t *testing.T) {
+	d := testModem(t)
+	s, client := testServer(t, d)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamStateChanges(ctx, &kasapb.StreamStateChangesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := func() *kasapb.StateChange {
+		t.Helper()
+		sc, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc.Time = nil
+		return sc
+	}
+	want := &kasapb.StateChange{Type: kasapb.StateChange_TYPE_DEVICE_APPEARED, Id: "modem", Device: modemInfo(d)}
+	if diff := cmp.Diff(want, recv(), protocmp.Transform()); diff != "" {
+		t.Errorf("initial change mismatch (-want +got):\n%s", diff)
+	}
+
+	// The stream subscribed before sending the initial changes, so sees the
+	// next poll.
+	d.Update(func(s map[string]interface{}) { s["relay_state"] = 0 })
+	events, err := s.inv.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.publish(events)
+
+	off := modemInfo(d)
+	off.RelayOn = false
+	want = &kasapb.StateChange{Type: kasapb.StateChange_TYPE_RELAY_CHANGED, Id: "modem", OldValue: "on", NewValue: "off", Device: off}
+	if diff := cmp.Diff(want, recv(), protocmp.Transform()); diff != "" {
+		t.Errorf("relay change mismatch (-want +got):\n%s", diff)
+	}
+
+	poll := func() {
+		t.Helper()
+		events, err := s.inv.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.publish(events)
+	}
+	// A single dropped response is not reported as the device disappearing
+	// and reappearing, so the next change seen is that of the relay.
+	d.SetFaults(kasatest.Faults{Drop: true})
+	poll()
+	d.SetFaults(kasatest.Faults{})
+	d.Update(func(s map[string]interface{}) { s["relay_state"] = 1 })
+	poll()
+	want = &kasapb.StateChange{Type: kasapb.StateChange_TYPE_RELAY_CHANGED, Id: "modem", OldValue: "off", NewValue: "on", Device: modemInfo(d)}
+	if diff := cmp.Diff(want, recv(), protocmp.Transform()); diff != "" {
+		t.Errorf("relay change after dropped response mismatch (-want +got):\n%s", diff)
+	}
+
+	// The device disappears once it has missed enough polls in a row.
+	d.SetFaults(kasatest.Faults{Drop: true})
+	for i := 0; i < inventory.DefaultMissedPolls; i++ {
+		poll()
+	}
+	want = &kasapb.StateChange{Type: kasapb.StateChange_TYPE_DEVICE_DISAPPEARED, Id: "modem", Device: modemInfo(d)}
+	if diff := cmp.Diff(want, recv(), protocmp.Transform()); diff != "" {
+		t.Errorf("disappearance mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStreamFiltersDevices(t *testing.T) {
+	_, client := testServer(t, testModem(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamStateChanges(ctx, &kasapb.StreamStateChangesRequest{Devices: []string{"lamp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The modem's initial change is filtered, so nothing arrives before the
+	// stream is canceled.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	if sc, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("got %v, %v; want cancellation", sc, err)
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	s := New(kasatest.Discover(testModem(t)))
+	sub := s.subscribe(nil)
+	events, err := s.inv.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= streamBuffer; i++ {
+		s.publish(events)
+	}
+	n := 0
+	for range sub.changes {
+		n++
+	}
+	if n != streamBuffer {
+		t.Errorf("got %v buffered changes, want %v", n, streamBuffer)
+	}
+	s.unsubscribe(sub)
+}
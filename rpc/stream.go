@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cfunkhouser/kasa/inventory"
+	"github.com/cfunkhouser/kasa/rpc/kasapb"
+)
+
+// subscriber to state changes, for a single stream.
+type subscriber struct {
+	// ids of the devices of interest, or nil for all devices.
+	ids map[string]bool
+	// changes to send. Closed if the stream falls too far behind.
+	changes chan *kasapb.StateChange
+}
+
+func (sub *subscriber) wants(id string) bool {
+	return sub.ids == nil || sub.ids[id]
+}
+
+func (s *Server) subscribe(ids []string) *subscriber {
+	sub := &subscriber{changes: make(chan *kasapb.StateChange, streamBuffer)}
+	if len(ids) > 0 {
+		sub.ids = make(map[string]bool)
+		for _, id := range ids {
+			sub.ids[id] = true
+		}
+	}
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	return sub
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, has := s.subs[sub]; has {
+		delete(s.subs, sub)
+		close(sub.changes)
+	}
+}
+
+// publish events to subscribers. A subscriber which can not keep up is dropped,
+// rather than blocking polling.
+func (s *Server) publish(events []inventory.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		sc := NewStateChange(e)
+		for sub := range s.subs {
+			if !sub.wants(sc.GetId()) {
+				continue
+			}
+			select {
+			case sub.changes <- sc:
+			default:
+				delete(s.subs, sub)
+				close(sub.changes)
+			}
+		}
+	}
+}
+
+// StreamStateChanges implements kasapb.KasaServer.
+func (s *Server) StreamStateChanges(req *kasapb.StreamStateChangesRequest, stream kasapb.Kasa_StreamStateChangesServer) error {
+	sub := s.subscribe(req.GetDevices())
+	defer s.unsubscribe(sub)
+
+	// Devices already known are sent first, so that clients need not also call
+	// ListDevices.
+	at := s.inv.LastPoll()
+	for _, info := range s.inv.Devices() {
+		sc := NewStateChange(inventory.Event{Time: at, Type: inventory.DeviceAppeared, Info: info})
+		if !sub.wants(sc.GetId()) {
+			continue
+		}
+		if err := stream.Send(sc); err != nil {
+			return err
+		}
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return statusErr(ctx.Err())
+		case sc, ok := <-sub.changes:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "stream fell too far behind state changes")
+			}
+			if err := stream.Send(sc); err != nil {
+				return err
+			}
+		}
+	}
+}
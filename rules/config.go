@@ -0,0 +1,134 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Actions a Rule may take.
+const (
+	ActionOn    = "on"
+	ActionOff   = "off"
+	ActionCycle = "cycle"
+)
+
+// DefaultCycleOff is how long a cycle action leaves the relay off, unless the
+// rule says otherwise.
+const DefaultCycleOff = 15 * time.Second
+
+// Condition on a device's state. Every condition which is set must hold.
+type Condition struct {
+	// PowerBelow and PowerAbove compare the power drawn by the device, in
+	// watts. The device must have an energy meter.
+	PowerBelow *float64 `yaml:"power_below,omitempty"`
+	PowerAbove *float64 `yaml:"power_above,omitempty"`
+	// Relay state of the device, on or off.
+	Relay string `yaml:"relay,omitempty"`
+	// OnFor holds once the relay has been on for at least this long, as
+	// reported by the device.
+	OnFor time.Duration `yaml:"on_for,omitempty"`
+}
+
+func (c Condition) needsPower() bool {
+	return c.PowerBelow != nil || c.PowerAbove != nil
+}
+
+// Rule taking an action when a condition on a device holds. For example:
+//
+//	name: modem-recovery
+//	device: modem
+//	when: {power_below: 1}
+//	for: 5m
+//	action: cycle
+//	cooldown: 30m
+type Rule struct {
+	Name string `yaml:"name"`
+	// Device to which the rule applies, by device ID, alias or ip:port.
+	Device string    `yaml:"device"`
+	When   Condition `yaml:"when"`
+	// For how long the condition must hold before the action is taken. The
+	// condition is checked at each poll.
+	For time.Duration `yaml:"for,omitempty"`
+	// Action taken: on, off or cycle.
+	Action string `yaml:"action"`
+	// CycleOff is how long a cycle action leaves the relay off. If zero,
+	// DefaultCycleOff is used.
+	CycleOff time.Duration `yaml:"cycle_off,omitempty"`
+	// Cooldown after the action is taken, during which the rule does not act
+	// again.
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+	// MaxPerHour limits how often the rule acts. Zero is unlimited.
+	MaxPerHour int `yaml:"max_per_hour,omitempty"`
+}
+
+// Config for an Engine. For example:
+//
+//	max_actions_per_hour: 10
+//	rules:
+//	  - name: modem-recovery
+//	    device: modem
+//	    when: {power_below: 1}
+//	    for: 5m
+//	    action: cycle
+//	    cooldown: 30m
+//	  - name: heater-timeout
+//	    device: heater
+//	    when: {on_for: 2h}
+//	    action: off
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+	// MaxActionsPerHour limits how often all rules together act. Zero is
+	// unlimited.
+	MaxActionsPerHour int `yaml:"max_actions_per_hour,omitempty"`
+}
+
+var ErrInvalidConfig = errors.New("invalid rules config")
+
+// Validate the configuration.
+func (c *Config) Validate() error {
+	if c.MaxActionsPerHour < 0 {
+		return fmt.Errorf("%w: negative max_actions_per_hour", ErrInvalidConfig)
+	}
+	names := make(map[string]bool)
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("%w: rule %v has no name", ErrInvalidConfig, i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("%w: rule %q defined more than once", ErrInvalidConfig, r.Name)
+		}
+		names[r.Name] = true
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", ErrInvalidConfig, r.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if r.Device == "" {
+		return errors.New("no device")
+	}
+	switch r.Action {
+	case ActionOn, ActionOff, ActionCycle:
+	default:
+		return fmt.Errorf("unknown action %q, possible values: on, off, cycle", r.Action)
+	}
+	w := r.When
+	switch w.Relay {
+	case "", "on", "off":
+	default:
+		return fmt.Errorf("unknown relay state %q, possible values: on, off", w.Relay)
+	}
+	if !w.needsPower() && w.Relay == "" && w.OnFor == 0 {
+		return errors.New("no conditions")
+	}
+	if r.For < 0 || r.CycleOff < 0 || r.Cooldown < 0 || w.OnFor < 0 {
+		return errors.New("negative duration")
+	}
+	if r.MaxPerHour < 0 {
+		return errors.New("negative max_per_hour")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Rule{Name: "modem", Device: "modem", When: Condition{PowerBelow: watts(1)}, Action: ActionCycle}
+	for tn, tc := range map[string]struct {
+		cfg     Config
+		change  func(r *Rule)
+		wantErr bool
+	}{
+		"valid": {
+			change: func(*Rule) {},
+		},
+		"missing name": {
+			change:  func(r *Rule) { r.Name = "" },
+			wantErr: true,
+		},
+		"missing device": {
+			change:  func(r *Rule) { r.Device = "" },
+			wantErr: true,
+		},
+		"unknown action": {
+			change:  func(r *Rule) { r.Action = "explode" },
+			wantErr: true,
+		},
+		"unknown relay state": {
+			change:  func(r *Rule) { r.When.Relay = "maybe" },
+			wantErr: true,
+		},
+		"no conditions": {
+			change:  func(r *Rule) { r.When = Condition{} },
+			wantErr: true,
+		},
+		"negative duration": {
+			change:  func(r *Rule) { r.Cooldown = -time.Minute },
+			wantErr: true,
+		},
+		"negative max per hour": {
+			change:  func(r *Rule) { r.MaxPerHour = -1 },
+			wantErr: true,
+		},
+		"negative global max per hour": {
+			cfg:     Config{MaxActionsPerHour: -1},
+			change:  func(*Rule) {},
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			r := valid
+			tc.change(&r)
+			tc.cfg.Rules = []Rule{r}
+			err := tc.cfg.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate(): got %v, want error: %v", err, tc.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Validate(): got %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+	dup := Config{Rules: []Rule{valid, valid}}
+	if err := dup.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Validate() of duplicate rules: got %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,346 @@
+// Package rules automates Kasa devices, turning relays on, off or cycling them
+// when conditions on polled system information and energy meter readings hold
+// for long enough.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/internal/relay"
+	"github.com/cfunkhouser/kasa/inventory"
+)
+
+// DefaultTimeout of each request made to a device.
+const DefaultTimeout = 5 * time.Second
+
+var (
+	// ErrRateLimited is reported when a rule would act, but has already acted
+	// as often as its own or the global limit allows in the past hour.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrNoEmeter is reported for power conditions on devices without an
+	// energy meter.
+	ErrNoEmeter = errors.New("device has no energy meter")
+)
+
+// Event describes an action taken by a rule.
+type Event struct {
+	Time     time.Time
+	Rule     string
+	Action   string
+	DeviceID string
+	Alias    string
+	Address  string
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%v %v (%v) %v: %v", e.Time.UTC().Format(time.RFC3339), e.Alias, e.Address, e.Rule, e.Action)
+}
+
+// ruleState tracks a rule across polls.
+type ruleState struct {
+	// since the condition has held continuously, or zero if it does not.
+	since time.Time
+	// last time the rule acted.
+	last time.Time
+	// acted are the times the rule acted in the past hour.
+	acted []time.Time
+	// busy while the rule's action is running.
+	busy bool
+	// limited is set once a rate limit has been reported, so that it is
+	// reported once rather than at every poll.
+	limited bool
+}
+
+// Engine polls devices, and acts on those matching its rules.
+type Engine struct {
+	inv         *inventory.Inventory
+	cfg         Config
+	laddr       *net.UDPAddr
+	timeout     time.Duration
+	handleError func(error)
+	handleEvent func(Event)
+	now         func() time.Time
+	// sleep is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration)
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	state []ruleState
+	// acted are the times any rule acted in the past hour.
+	acted []time.Time
+}
+
+type Option func(*Engine)
+
+// WithLocalAddr from which requests are sent to devices.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(e *Engine) {
+		e.laddr = laddr
+	}
+}
+
+// WithTimeout of each request made to a device. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.timeout = timeout
+	}
+}
+
+// WithErrorHandler called with errors polling devices, evaluating rules and
+// acting on devices, none of which stop the Engine.
+func WithErrorHandler(handle func(error)) Option {
+	return func(e *Engine) {
+		e.handleError = handle
+	}
+}
+
+// WithEventHandler called after each action successfully taken by a rule.
+func WithEventHandler(handle func(Event)) Option {
+	return func(e *Engine) {
+		e.handleEvent = handle
+	}
+}
+
+// New Engine applying the rules to devices found using discover. The config
+// should be valid.
+func New(discover inventory.DiscoverFunc, cfg Config, opts ...Option) *Engine {
+	e := &Engine{
+		inv:         inventory.New(discover, inventory.DiffOptions{}),
+		cfg:         cfg,
+		timeout:     DefaultTimeout,
+		handleError: func(error) {},
+		handleEvent: func(Event) {},
+		now:         time.Now,
+		sleep:       relay.Sleep,
+		state:       make([]ruleState, len(cfg.Rules)),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Run polls every interval until the context is canceled, evaluating the rules
+// after each poll. Actions run in the background, so a cycle does not delay
+// polling; Run waits for them to finish before returning.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) error {
+	err := e.inv.Run(ctx, interval, func(_ []inventory.Event, err error) {
+		if err != nil {
+			e.handleError(err)
+			return
+		}
+		e.evaluate(ctx)
+	})
+	e.wg.Wait()
+	return err
+}
+
+// matches reports whether the device is referred to by ref, which is a device
+// ID, alias or ip:port.
+func matches(info *kasa.SystemInformation, ref string) bool {
+	if ref == info.DeviceID || ref == info.Alias {
+		return true
+	}
+	return info.RemoteAddress != nil && ref == info.RemoteAddress.String()
+}
+
+func find(devices []*kasa.SystemInformation, ref string) *kasa.SystemInformation {
+	for _, info := range devices {
+		if matches(info, ref) {
+			return info
+		}
+	}
+	return nil
+}
+
+// withinHour returns the times no older than an hour before now.
+func withinHour(times []time.Time, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range times {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+// evaluate the rules against the most recent poll, starting the actions of
+// those which hold.
+func (e *Engine) evaluate(ctx context.Context) {
+	now := e.now()
+	devices := e.inv.Devices()
+	// Energy meters are read before taking the lock, so that a slow device
+	// does not hold up actions finishing.
+	power := e.readPower(ctx, devices)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.acted = withinHour(e.acted, now)
+	for i, r := range e.cfg.Rules {
+		st := &e.state[i]
+		st.acted = withinHour(st.acted, now)
+		if st.busy {
+			continue
+		}
+		info := find(devices, r.Device)
+		if info == nil || info.RemoteAddress == nil {
+			st.since = time.Time{}
+			continue
+		}
+		holds, err := holds(r.When, info, power)
+		if err != nil {
+			e.handleError(fmt.Errorf("rule %q: %w", r.Name, err))
+		}
+		if !holds {
+			st.since, st.limited = time.Time{}, false
+			continue
+		}
+		if st.since.IsZero() {
+			st.since = now
+		}
+		if now.Sub(st.since) < r.For {
+			continue
+		}
+		if !st.last.IsZero() && now.Sub(st.last) < r.Cooldown {
+			continue
+		}
+		if (r.MaxPerHour > 0 && len(st.acted) >= r.MaxPerHour) ||
+			(e.cfg.MaxActionsPerHour > 0 && len(e.acted) >= e.cfg.MaxActionsPerHour) {
+			if !st.limited {
+				st.limited = true
+				e.handleError(fmt.Errorf("rule %q: %v %q: %w", r.Name, r.Action, info.Alias, ErrRateLimited))
+			}
+			continue
+		}
+		st.since, st.last, st.busy, st.limited = time.Time{}, now, true, false
+		st.acted = append(st.acted, now)
+		e.acted = append(e.acted, now)
+		event := Event{
+			Time:     now,
+			Rule:     r.Name,
+			Action:   r.Action,
+			DeviceID: info.DeviceID,
+			Alias:    info.Alias,
+			Address:  info.RemoteAddress.String(),
+		}
+		e.wg.Add(1)
+		go func(i int, r Rule, raddr *net.UDPAddr) {
+			defer e.wg.Done()
+			err := e.act(ctx, r, raddr)
+			e.mu.Lock()
+			e.state[i].busy = false
+			e.mu.Unlock()
+			if err != nil {
+				e.handleError(fmt.Errorf("rule %q: %v %q: %w", r.Name, r.Action, event.Alias, err))
+				return
+			}
+			e.handleEvent(event)
+		}(i, r, info.RemoteAddress)
+	}
+}
+
+// reading of a device's energy meter.
+type reading struct {
+	power float64
+	err   error
+}
+
+// readPower of the devices whose power is needed by a rule, by device address.
+// Each energy meter is read at most once.
+func (e *Engine) readPower(ctx context.Context, devices []*kasa.SystemInformation) map[string]reading {
+	power := make(map[string]reading)
+	for _, r := range e.cfg.Rules {
+		info := find(devices, r.Device)
+		if info == nil || info.RemoteAddress == nil || !r.When.needsPower() || !hasEmeter(info) || !relayHolds(r.When, info) {
+			continue
+		}
+		addr := info.RemoteAddress.String()
+		if _, read := power[addr]; read {
+			continue
+		}
+		var p reading
+		m, err := e.getEmeter(ctx, info.RemoteAddress)
+		if err != nil {
+			p.err = err
+		} else {
+			p.power = m.Power
+		}
+		power[addr] = p
+	}
+	return power
+}
+
+func hasEmeter(info *kasa.SystemInformation) bool {
+	return strings.Contains(info.Feature, "ENE")
+}
+
+// relayHolds reports whether the relay conditions hold for the device.
+func relayHolds(c Condition, info *kasa.SystemInformation) bool {
+	on := info.RelayState == 1
+	if c.Relay != "" && (c.Relay == "on") != on {
+		return false
+	}
+	return c.OnFor == 0 || (on && time.Duration(info.OnTime)*time.Second >= c.OnFor)
+}
+
+// holds reports whether the condition holds for the device, given the power
+// readings by device address.
+func holds(c Condition, info *kasa.SystemInformation, power map[string]reading) (bool, error) {
+	if !relayHolds(c, info) {
+		return false, nil
+	}
+	if !c.needsPower() {
+		return true, nil
+	}
+	if !hasEmeter(info) {
+		return false, ErrNoEmeter
+	}
+	p := power[info.RemoteAddress.String()]
+	if p.err != nil {
+		return false, p.err
+	}
+	if c.PowerBelow != nil && !(p.power < *c.PowerBelow) {
+		return false, nil
+	}
+	if c.PowerAbove != nil && !(p.power > *c.PowerAbove) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (e *Engine) getEmeter(ctx context.Context, raddr *net.UDPAddr) (*kasa.EmeterRealtime, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return kasa.GetEmeterRealtime(ctx, raddr, e.laddr)
+}
+
+func (e *Engine) set(ctx context.Context, raddr *net.UDPAddr, state bool) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return kasa.SetRelayStateChecked(ctx, raddr, e.laddr, state)
+}
+
+// act on the device at raddr as the rule says.
+func (e *Engine) act(ctx context.Context, r Rule, raddr *net.UDPAddr) error {
+	switch r.Action {
+	case ActionOn:
+		return e.set(ctx, raddr, true)
+	case ActionOff:
+		return e.set(ctx, raddr, false)
+	}
+	off := r.CycleOff
+	if off == 0 {
+		off = DefaultCycleOff
+	}
+	// A device cycled off is turned back on even if the engine is stopping.
+	return relay.Cycle(ctx, off, func(ctx context.Context, on bool) error {
+		return e.set(ctx, raddr, on)
+	}, e.sleep)
+}
@@ -0,0 +1,394 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+// testDevices starts a modem with an energy meter, and a heater without one.
+func testDevices(t *testing.T) (modem, heater *kasatest.Device) {
+	t.Helper()
+	modem = kasatest.Start(t,
+		kasatest.WithDeviceID("modem"),
+		kasatest.WithAlias("ADSL Modem"),
+		kasatest.WithRelay(true),
+		kasatest.WithEmeter(kasatest.Emeter{Power: 9.5}))
+	heater = kasatest.Start(t, kasatest.WithDeviceID("heater"), kasatest.WithAlias("Heater"))
+	return modem, heater
+}
+
+// relayChanges returns a function reporting the relay changes requested of the
+// devices since it was last called, as "modem off" or "heater on".
+func relayChanges(modem, heater *kasatest.Device) func() []string {
+	seen := make(map[string]int)
+	return func() []string {
+		var changes []string
+		for _, d := range []struct {
+			name string
+			*kasatest.Device
+		}{{"modem", modem}, {"heater", heater}} {
+			all := d.RelayChanges()
+			for _, on := range all[seen[d.name]:] {
+				s := "off"
+				if on {
+					s = "on"
+				}
+				changes = append(changes, d.name+" "+s)
+			}
+			seen[d.name] = len(all)
+		}
+		return changes
+	}
+}
+
+func setPower(d *kasatest.Device, watts float64) {
+	d.SetEmeter(kasatest.Emeter{Power: watts})
+}
+
+func setRelay(d *kasatest.Device, on bool) {
+	d.Update(func(s map[string]interface{}) {
+		s["relay_state"] = 0
+		if on {
+			s["relay_state"] = 1
+		}
+	})
+}
+
+var at = time.Date(2021, time.May, 8, 17, 2, 11, 0, time.UTC)
+
+// testEngine whose clock is advanced by poll, which polls once, evaluates the
+// rules and waits for any actions.
+func testEngine(t *testing.T, devices []*kasatest.Device, cfg Config) (e *Engine, poll func(advance time.Duration), errs *[]error) {
+	t.Helper()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	now := at
+	errs = new([]error)
+	var mu sync.Mutex
+	e = New(kasatest.Discover(devices...), cfg, WithTimeout(kasatest.Timeout), WithErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		*errs = append(*errs, err)
+	}))
+	e.now = func() time.Time { return now }
+	e.sleep = func(context.Context, time.Duration) {}
+	poll = func(advance time.Duration) {
+		t.Helper()
+		now = now.Add(advance)
+		if _, err := e.inv.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		e.evaluate(context.Background())
+		e.wg.Wait()
+	}
+	return e, poll, errs
+}
+
+func watts(w float64) *float64 { return &w }
+
+func TestEngineConditions(t *testing.T) {
+	// The heater's address is only known once it starts, so is substituted for
+	// this in rules.
+	const heaterAddr = "$heater"
+	for tn, tc := range map[string]struct {
+		rule   Rule
+		change func(modem, heater *kasatest.Device)
+		want   []string
+	}{
+		"power below": {
+			rule:   Rule{Device: "modem", When: Condition{PowerBelow: watts(1)}, Action: ActionCycle},
+			change: func(modem, _ *kasatest.Device) { setPower(modem, 0.4) },
+			want:   []string{"modem off", "modem on"},
+		},
+		"power not below": {
+			rule:   Rule{Device: "modem", When: Condition{PowerBelow: watts(1)}, Action: ActionCycle},
+			change: func(modem, _ *kasatest.Device) { setPower(modem, 1) },
+		},
+		"power above": {
+			rule:   Rule{Device: "ADSL Modem", When: Condition{PowerAbove: watts(20)}, Action: ActionOff},
+			change: func(modem, _ *kasatest.Device) { setPower(modem, 21) },
+			want:   []string{"modem off"},
+		},
+		"relay off": {
+			rule: Rule{Device: heaterAddr, When: Condition{Relay: "off"}, Action: ActionOn},
+			want: []string{"heater on"},
+		},
+		"relay on": {
+			rule: Rule{Device: heaterAddr, When: Condition{Relay: "on"}, Action: ActionOff},
+		},
+		"on for": {
+			rule: Rule{Device: "heater", When: Condition{OnFor: 2 * time.Hour}, Action: ActionOff},
+			change: func(_, heater *kasatest.Device) {
+				heater.Update(func(s map[string]interface{}) {
+					s["relay_state"] = 1
+					s["on_time"] = 7200
+				})
+			},
+			want: []string{"heater off"},
+		},
+		"not on for long enough": {
+			rule: Rule{Device: "heater", When: Condition{OnFor: 2 * time.Hour}, Action: ActionOff},
+			change: func(_, heater *kasatest.Device) {
+				heater.Update(func(s map[string]interface{}) {
+					s["relay_state"] = 1
+					s["on_time"] = 7199
+				})
+			},
+		},
+		"all conditions": {
+			rule: Rule{Device: "modem", When: Condition{Relay: "on", PowerAbove: watts(5), PowerBelow: watts(10)}, Action: ActionOff},
+			want: []string{"modem off"},
+		},
+		"missing device": {
+			rule: Rule{Device: "kettle", When: Condition{Relay: "off"}, Action: ActionOn},
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			modem, heater := testDevices(t)
+			if tc.change != nil {
+				tc.change(modem, heater)
+			}
+			tc.rule.Name = "test"
+			if tc.rule.Device == heaterAddr {
+				tc.rule.Device = heater.Addr()
+			}
+			_, poll, errs := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{tc.rule}})
+			poll(0)
+			if diff := cmp.Diff(tc.want, relayChanges(modem, heater)()); diff != "" {
+				t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+			}
+			if len(*errs) > 0 {
+				t.Errorf("unexpected errors: %v", *errs)
+			}
+		})
+	}
+}
+
+func TestEngineFor(t *testing.T) {
+	modem, heater := testDevices(t)
+	changes := relayChanges(modem, heater)
+	_, poll, _ := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{{
+		Name:   "modem-recovery",
+		Device: "modem",
+		When:   Condition{PowerBelow: watts(1)},
+		For:    5 * time.Minute,
+		Action: ActionCycle,
+	}}})
+	var got []string
+	for i, p := range []float64{0, 0, 0, 3, 0, 0, 0, 0} {
+		setPower(modem, p)
+		poll(2 * time.Minute)
+		for _, c := range changes() {
+			got = append(got, fmt.Sprintf("%v: %v", i, c))
+		}
+	}
+	// Low at polls 0 through 2, reset by 3, then low again from 4 until it has
+	// been for 5 minutes at 7.
+	want := []string{"7: modem off", "7: modem on"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEngineCooldownAndRateLimits(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		cfg         Config
+		wantActions int
+		wantLimited bool
+	}{
+		"unlimited": {
+			cfg:         Config{Rules: []Rule{{Device: "heater", When: Condition{Relay: "on"}, Action: ActionOff}}},
+			wantActions: 12,
+		},
+		"cooldown": {
+			cfg:         Config{Rules: []Rule{{Device: "heater", When: Condition{Relay: "on"}, Action: ActionOff, Cooldown: 20 * time.Minute}}},
+			wantActions: 3,
+		},
+		"max per hour": {
+			cfg:         Config{Rules: []Rule{{Device: "heater", When: Condition{Relay: "on"}, Action: ActionOff, MaxPerHour: 2}}},
+			wantActions: 2,
+			wantLimited: true,
+		},
+		"global max per hour": {
+			cfg: Config{
+				MaxActionsPerHour: 4,
+				Rules:             []Rule{{Device: "heater", When: Condition{Relay: "on"}, Action: ActionOff}},
+			},
+			wantActions: 4,
+			wantLimited: true,
+		},
+	} {
+		tc := tc
+		t.Run(tn, func(t *testing.T) {
+			t.Parallel()
+			modem, heater := testDevices(t)
+			changes := relayChanges(modem, heater)
+			tc.cfg.Rules[0].Name = "heater-off"
+			_, poll, errs := testEngine(t, []*kasatest.Device{modem, heater}, tc.cfg)
+			actions := 0
+			// Something keeps turning the heater back on, every 5 minutes for
+			// an hour.
+			for i := 0; i < 12; i++ {
+				setRelay(heater, true)
+				poll(5 * time.Minute)
+				actions += len(changes())
+			}
+			if actions != tc.wantActions {
+				t.Errorf("got %v actions, want %v", actions, tc.wantActions)
+			}
+			var limited int
+			for _, err := range *errs {
+				if errors.Is(err, ErrRateLimited) {
+					limited++
+				}
+			}
+			if tc.wantLimited && limited != 1 {
+				t.Errorf("got %v rate limit errors, want 1: %v", limited, *errs)
+			}
+			if !tc.wantLimited && limited != 0 {
+				t.Errorf("unexpected rate limit errors: %v", *errs)
+			}
+		})
+	}
+}
+
+func TestEngineRateLimitWindow(t *testing.T) {
+	modem, heater := testDevices(t)
+	changes := relayChanges(modem, heater)
+	_, poll, _ := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{{
+		Name:       "heater-off",
+		Device:     "heater",
+		When:       Condition{Relay: "on"},
+		Action:     ActionOff,
+		MaxPerHour: 1,
+	}}})
+	var got []int
+	for i := 0; i < 8; i++ {
+		setRelay(heater, true)
+		poll(15 * time.Minute)
+		if len(changes()) > 0 {
+			got = append(got, i)
+		}
+	}
+	if diff := cmp.Diff([]int{0, 4}, got); diff != "" {
+		t.Errorf("polls acting mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEngineErrors(t *testing.T) {
+	modem, heater := testDevices(t)
+	e, _, errs := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{
+		{Name: "heater-power", Device: "heater", When: Condition{PowerAbove: watts(1)}, Action: ActionOff},
+		{Name: "heater-on", Device: "heater", When: Condition{Relay: "off"}, Action: ActionOn},
+	}})
+	var events []Event
+	e.handleEvent = func(ev Event) { events = append(events, ev) }
+	if _, err := e.inv.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The heater is found, then fails to switch on.
+	heater.SetFaults(kasatest.Faults{ErrorCode: -10})
+	e.evaluate(context.Background())
+	e.wg.Wait()
+	if len(*errs) != 2 || !errors.Is((*errs)[0], ErrNoEmeter) || !errors.Is((*errs)[1], kasa.ErrSetRelayStateFailed) {
+		t.Errorf("got errors %v, want ErrNoEmeter and ErrSetRelayStateFailed", *errs)
+	}
+	if len(events) > 0 {
+		t.Errorf("failed actions reported as events: %v", events)
+	}
+}
+
+func TestEngineCycleWithoutResponse(t *testing.T) {
+	modem, heater := testDevices(t)
+	e, _, errs := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{
+		{Name: "modem-restart", Device: "modem", When: Condition{Relay: "on"}, Action: ActionCycle},
+	}})
+	if _, err := e.inv.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The modem may have turned off without replying, so is turned back on.
+	modem.SetFaults(kasatest.Faults{Drop: true})
+	e.evaluate(context.Background())
+	e.wg.Wait()
+	if diff := cmp.Diff([]string{"modem off", "modem on"}, relayChanges(modem, heater)()); diff != "" {
+		t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+	}
+	if len(*errs) != 1 || !errors.Is((*errs)[0], kasa.ErrNoResponse) {
+		t.Errorf("got errors %v, want kasa.ErrNoResponse", *errs)
+	}
+}
+
+func TestEngineReadsPowerUnlocked(t *testing.T) {
+	modem, heater := testDevices(t)
+	e, _, _ := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{
+		{Name: "modem-recovery", Device: "modem", When: Condition{PowerBelow: watts(1)}, Action: ActionCycle},
+	}})
+	e.timeout = time.Second
+	if _, err := e.inv.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	modem.SetFaults(kasatest.Faults{Delay: 500 * time.Millisecond})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.evaluate(context.Background())
+	}()
+	// Once the modem has been asked for its power, the lock is free while it
+	// is slow to respond.
+	for len(modem.Requests()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	e.mu.Lock()
+	select {
+	case <-done:
+		t.Error("lock held while reading power")
+	default:
+	}
+	e.mu.Unlock()
+	<-done
+}
+
+func TestEngineEvents(t *testing.T) {
+	modem, heater := testDevices(t)
+	e, poll, _ := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{
+		{Name: "heater-on", Device: "heater", When: Condition{Relay: "off"}, Action: ActionOn},
+	}})
+	var events []Event
+	e.handleEvent = func(ev Event) { events = append(events, ev) }
+	poll(0)
+	want := []Event{{Time: at, Rule: "heater-on", Action: ActionOn, DeviceID: "heater", Alias: "Heater", Address: heater.Addr()}}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEngineCycleRestoresOnStop(t *testing.T) {
+	modem, heater := testDevices(t)
+	setPower(modem, 0)
+	e, _, _ := testEngine(t, []*kasatest.Device{modem, heater}, Config{Rules: []Rule{
+		{Name: "modem-recovery", Device: "modem", When: Condition{PowerBelow: watts(1)}, Action: ActionCycle},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	e.sleep = func(ctx context.Context, _ time.Duration) {
+		cancel()
+		<-ctx.Done()
+	}
+	if _, err := e.inv.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	e.evaluate(ctx)
+	e.wg.Wait()
+	if diff := cmp.Diff([]string{"modem off", "modem on"}, relayChanges(modem, heater)()); diff != "" {
+		t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+	}
+}
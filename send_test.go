@@ -0,0 +1,221 @@
+package kasa_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/kasatest"
+)
+
+func intp(v int) *int { return &v }
+
+// Each request waits a second for further replies, so tests against fake
+// devices run in parallel.
+
+func TestGetSystemInformation(t *testing.T) {
+	t.Parallel()
+	d := kasatest.Start(t, kasatest.WithAlias("Kettle"), kasatest.WithRelay(true),
+		kasatest.WithChildren(kasa.ChildInformation{ID: "00", Alias: "Desk", State: 1}))
+	infos, err := kasa.GetSystemInformation(context.Background(), d.UDPAddr(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("got %v responses, want 1", len(infos))
+	}
+	got := infos[0]
+	if got.Alias != "Kettle" || got.Model != "HS300(US)" || got.RemoteAddress.String() != d.Addr() {
+		t.Errorf("unexpected system information: %+v", got)
+	}
+	if diff := cmp.Diff([]kasa.ChildInformation{{ID: "00", Alias: "Desk", State: 1}}, got.Children); diff != "" {
+		t.Errorf("children mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetDeviceSystemInformation(t *testing.T) {
+	t.Parallel()
+	for tn, tc := range map[string]struct {
+		opts    []kasatest.Option
+		timeout time.Duration
+		wantErr error
+	}{
+		"success": {
+			opts: []kasatest.Option{kasatest.WithAlias("Kettle")},
+		},
+		"error code": {
+			opts:    []kasatest.Option{kasatest.WithFaults(kasatest.Faults{ErrorCode: -10, ErrorMessage: "busy"})},
+			wantErr: kasa.ErrGetSysinfoFailed,
+		},
+		"dropped": {
+			opts:    []kasatest.Option{kasatest.WithFaults(kasatest.Faults{Drop: true})},
+			timeout: 200 * time.Millisecond,
+			wantErr: kasa.ErrNoResponse,
+		},
+	} {
+		tc := tc
+		t.Run(tn, func(t *testing.T) {
+			t.Parallel()
+			d := kasatest.Start(t, tc.opts...)
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			info, err := kasa.GetDeviceSystemInformation(ctx, d.UDPAddr(), nil)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("GetDeviceSystemInformation(): got error %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && info.Alias != "Kettle" {
+				t.Errorf("GetDeviceSystemInformation(): got %+v", info)
+			}
+		})
+	}
+}
+
+func TestSetRelayStateChecked(t *testing.T) {
+	t.Parallel()
+	for tn, tc := range map[string]struct {
+		faults    kasatest.Faults
+		timeout   time.Duration
+		wantErr   error
+		wantCode  int
+		wantRelay bool
+	}{
+		"success": {
+			wantRelay: true,
+		},
+		"error code": {
+			faults:   kasatest.Faults{ErrorCode: -10, ErrorMessage: "device busy"},
+			wantErr:  kasa.ErrSetRelayStateFailed,
+			wantCode: -10,
+		},
+		"dropped": {
+			faults:  kasatest.Faults{Drop: true},
+			timeout: 200 * time.Millisecond,
+			wantErr: kasa.ErrNoResponse,
+		},
+		"delayed past deadline": {
+			faults:    kasatest.Faults{Delay: 500 * time.Millisecond},
+			timeout:   200 * time.Millisecond,
+			wantErr:   kasa.ErrNoResponse,
+			wantRelay: true,
+		},
+		"malformed": {
+			faults:    kasatest.Faults{Malformed: true},
+			wantErr:   kasa.ErrMalformedResponse,
+			wantRelay: true,
+		},
+	} {
+		tc := tc
+		t.Run(tn, func(t *testing.T) {
+			t.Parallel()
+			d := kasatest.Start(t, kasatest.WithFaults(tc.faults))
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			err := kasa.SetRelayStateChecked(ctx, d.UDPAddr(), nil, true)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("SetRelayStateChecked(): got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantCode != 0 {
+				var derr *kasa.DeviceError
+				if !errors.As(err, &derr) || derr.Code != tc.wantCode {
+					t.Errorf("SetRelayStateChecked(): got error %v, want code %v", err, tc.wantCode)
+				}
+			}
+			if tc.faults.Delay > 0 {
+				// Wait for the delayed request to take effect.
+				time.Sleep(tc.faults.Delay)
+			}
+			if got := d.Relay(); got != tc.wantRelay {
+				t.Errorf("relay: got %v, want %v", got, tc.wantRelay)
+			}
+		})
+	}
+}
+
+func TestSetRelayState(t *testing.T) {
+	t.Parallel()
+	d := kasatest.Start(t, kasatest.WithRelay(true))
+	if err := kasa.SetRelayState(context.Background(), d.UDPAddr(), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for d.Relay() {
+		if time.Now().After(deadline) {
+			t.Fatal("relay is still on")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []*kasa.APIMessage{
+		{System: map[string]interface{}{"set_relay_state": map[string]interface{}{"state": false}}},
+	}
+	if diff := cmp.Diff(want, d.Requests()); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetEmeterRealtime(t *testing.T) {
+	t.Parallel()
+	t.Run("emeter", func(t *testing.T) {
+		t.Parallel()
+		d := kasatest.Start(t, kasatest.WithEmeter(kasatest.Emeter{Current: 0.5, Voltage: 120, Power: 60, Total: 1234}))
+		got, err := kasa.GetEmeterRealtime(context.Background(), d.UDPAddr(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &kasa.EmeterRealtime{RemoteAddress: d.UDPAddr(), Current: 0.5, Voltage: 120, Power: 60, Total: 1234}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("readings mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("no emeter", func(t *testing.T) {
+		t.Parallel()
+		d := kasatest.Start(t)
+		_, err := kasa.GetEmeterRealtime(context.Background(), d.UDPAddr(), nil)
+		if !errors.Is(err, kasa.ErrGetRealtimeFailed) {
+			t.Errorf("GetEmeterRealtime(): got error %v, want %v", err, kasa.ErrGetRealtimeFailed)
+		}
+	})
+	t.Run("dropped", func(t *testing.T) {
+		t.Parallel()
+		d := kasatest.Start(t, kasatest.WithEmeter(kasatest.Emeter{Power: 60}), kasatest.WithFaults(kasatest.Faults{Drop: true}))
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := kasa.GetEmeterRealtime(ctx, d.UDPAddr(), nil)
+		if !errors.Is(err, kasa.ErrNoResponse) || errors.Is(err, kasa.ErrGetRealtimeFailed) {
+			t.Errorf("GetEmeterRealtime(): got error %v, want %v", err, kasa.ErrNoResponse)
+		}
+	})
+}
+
+func TestSetLightStateChecked(t *testing.T) {
+	t.Parallel()
+	t.Run("bulb", func(t *testing.T) {
+		t.Parallel()
+		d := kasatest.Start(t, kasatest.WithLight(kasa.LightState{OnOff: intp(1), Brightness: intp(40)}))
+		if err := kasa.SetLightStateChecked(context.Background(), d.UDPAddr(), nil, kasa.LightState{Brightness: intp(90)}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(kasa.LightState{OnOff: intp(1), Brightness: intp(90)}, d.Light()); diff != "" {
+			t.Errorf("light state mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("plug", func(t *testing.T) {
+		t.Parallel()
+		d := kasatest.Start(t)
+		err := kasa.SetLightStateChecked(context.Background(), d.UDPAddr(), nil, kasa.LightState{OnOff: intp(1)})
+		if !errors.Is(err, kasa.ErrSetLightStateFailed) {
+			t.Errorf("SetLightStateChecked(): got error %v, want %v", err, kasa.ErrSetLightStateFailed)
+		}
+	})
+}
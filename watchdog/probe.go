@@ -0,0 +1,92 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Probe checks connectivity, returning an error if it is lost.
+type Probe interface {
+	Probe(ctx context.Context) error
+	String() string
+}
+
+var ErrInvalidProbe = errors.New("invalid probe")
+
+// ParseProbe from a URL. tcp://host:port probes by opening a TCP connection,
+// and http:// or https:// URLs by making a GET request.
+func ParseProbe(s string) (Probe, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProbe, err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" || u.Port() == "" {
+			return nil, fmt.Errorf("%w: %q: want tcp://host:port", ErrInvalidProbe, s)
+		}
+		return &TCPProbe{Address: u.Host}, nil
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: %q: missing host", ErrInvalidProbe, s)
+		}
+		return &HTTPProbe{URL: s}, nil
+	}
+	return nil, fmt.Errorf("%w: %q: unsupported scheme, possible values: tcp, http, https", ErrInvalidProbe, s)
+}
+
+// TCPProbe succeeds if a TCP connection to Address can be opened.
+type TCPProbe struct {
+	Address string
+}
+
+func (p *TCPProbe) Probe(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *TCPProbe) String() string {
+	return "tcp://" + p.Address
+}
+
+// HTTPProbe succeeds if a GET request of URL responds with a status below 400.
+type HTTPProbe struct {
+	URL string
+	// Client making requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (p *HTTPProbe) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%v: unexpected status %v", p.URL, resp.Status)
+	}
+	return nil
+}
+
+func (p *HTTPProbe) String() string {
+	return p.URL
+}
@@ -0,0 +1,100 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseProbe(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		in      string
+		want    Probe
+		wantErr bool
+	}{
+		"tcp": {
+			in:   "tcp://1.1.1.1:53",
+			want: &TCPProbe{Address: "1.1.1.1:53"},
+		},
+		"http": {
+			in:   "http://10.24.6.1/status",
+			want: &HTTPProbe{URL: "http://10.24.6.1/status"},
+		},
+		"https": {
+			in:   "https://example.com",
+			want: &HTTPProbe{URL: "https://example.com"},
+		},
+		"tcp without port": {
+			in:      "tcp://1.1.1.1",
+			wantErr: true,
+		},
+		"http without host": {
+			in:      "http:///status",
+			wantErr: true,
+		},
+		"unsupported scheme": {
+			in:      "icmp://1.1.1.1",
+			wantErr: true,
+		},
+		"bare address": {
+			in:      "1.1.1.1:53",
+			wantErr: true,
+		},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			got, err := ParseProbe(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseProbe(%q): got error %v, want error: %v", tc.in, err, tc.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidProbe) {
+				t.Errorf("ParseProbe(%q): got %v, want ErrInvalidProbe", tc.in, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParseProbe(%q): mismatch (-want +got):\n%s", tc.in, diff)
+			}
+		})
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &TCPProbe{Address: l.Addr().String()}
+	if err := p.Probe(context.Background()); err != nil {
+		t.Errorf("Probe() of listening port: %v", err)
+	}
+	l.Close()
+	if err := p.Probe(context.Background()); err == nil {
+		t.Error("Probe() of closed port: want error, got nil")
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	for tn, tc := range map[string]struct {
+		status  int
+		wantErr bool
+	}{
+		"ok":          {status: http.StatusOK},
+		"no content":  {status: http.StatusNoContent},
+		"not found":   {status: http.StatusNotFound, wantErr: true},
+		"unavailable": {status: http.StatusServiceUnavailable, wantErr: true},
+	} {
+		t.Run(tn, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer s.Close()
+			p := &HTTPProbe{URL: s.URL, Client: s.Client()}
+			if err := p.Probe(context.Background()); (err != nil) != tc.wantErr {
+				t.Errorf("Probe(): got %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
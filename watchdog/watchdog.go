@@ -0,0 +1,293 @@
+// Package watchdog power-cycles a Kasa device, such as the plug of a modem,
+// when network connectivity is lost.
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cfunkhouser/kasa"
+	"github.com/cfunkhouser/kasa/internal/relay"
+)
+
+// Defaults for a Watchdog.
+const (
+	DefaultFailures         = 3
+	DefaultCycleOff         = 15 * time.Second
+	DefaultBackoff          = 5 * time.Minute
+	DefaultMaxCyclesPerHour = 3
+	DefaultProbeTimeout     = 5 * time.Second
+	// DefaultTimeout of each request made to the device.
+	DefaultTimeout = 5 * time.Second
+)
+
+// MaxBackoff between cycles, however many fail to restore connectivity.
+const MaxBackoff = time.Hour
+
+// ErrProbesFailed when every probe fails.
+var ErrProbesFailed = errors.New("all probes failed")
+
+// EventType of an Event.
+type EventType string
+
+// Events reported by a Watchdog.
+const (
+	// Down when probes have failed enough times in a row.
+	Down EventType = "down"
+	// Up when probes succeed again after being down.
+	Up EventType = "up"
+	// Cycled when the device has been turned off and on again.
+	Cycled EventType = "cycled"
+	// Limited when the device would be cycled, but has already been cycled
+	// the maximum number of times in the past hour.
+	Limited EventType = "limited"
+)
+
+// Event in the life of a Watchdog.
+type Event struct {
+	Time time.Time
+	Type EventType
+	// Device address.
+	Device string
+	// Err is the probe failure for Down events, and any failure to set the
+	// relay for Cycled events.
+	Err error
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%v %v %v", e.Time.UTC().Format(time.RFC3339), e.Device, e.Type)
+	if e.Err != nil {
+		s += fmt.Sprintf(": %v", e.Err)
+	}
+	return s
+}
+
+// Watchdog probes connectivity, and cycles a device when it is lost.
+type Watchdog struct {
+	raddr            *net.UDPAddr
+	laddr            *net.UDPAddr
+	probes           []Probe
+	failures         int
+	cycleOff         time.Duration
+	backoff          time.Duration
+	maxCyclesPerHour int
+	probeTimeout     time.Duration
+	handleEvent      func(Event)
+	now              func() time.Time
+
+	// Device operations are replaced in tests.
+	setRelayState func(ctx context.Context, raddr, laddr *net.UDPAddr, state bool) error
+	sleep         func(ctx context.Context, d time.Duration)
+
+	// failed probe rounds in a row since connectivity was last seen or the
+	// device was last cycled.
+	failed int
+	down   bool
+	// delay before the device may next be cycled, and when that is.
+	delay     time.Duration
+	nextCycle time.Time
+	// cycles in the past hour.
+	cycles  []time.Time
+	limited bool
+}
+
+type Option func(*Watchdog)
+
+// WithLocalAddr from which requests are sent to the device.
+func WithLocalAddr(laddr *net.UDPAddr) Option {
+	return func(w *Watchdog) {
+		w.laddr = laddr
+	}
+}
+
+// WithFailures is the number of failed probe rounds in a row after which the
+// device is cycled.
+func WithFailures(n int) Option {
+	return func(w *Watchdog) {
+		w.failures = n
+	}
+}
+
+// WithCycleOff is how long the device is left off when cycled.
+func WithCycleOff(d time.Duration) Option {
+	return func(w *Watchdog) {
+		w.cycleOff = d
+	}
+}
+
+// WithBackoff after cycling the device before it may be cycled again. The
+// backoff doubles with each cycle which does not restore connectivity, up to
+// MaxBackoff, and is reset once connectivity returns.
+func WithBackoff(d time.Duration) Option {
+	return func(w *Watchdog) {
+		w.backoff = d
+	}
+}
+
+// WithMaxCyclesPerHour limits how often the device is cycled. If zero, it is
+// never cycled; if negative, there is no limit.
+func WithMaxCyclesPerHour(n int) Option {
+	return func(w *Watchdog) {
+		w.maxCyclesPerHour = n
+	}
+}
+
+// WithProbeTimeout after which a probe fails.
+func WithProbeTimeout(d time.Duration) Option {
+	return func(w *Watchdog) {
+		w.probeTimeout = d
+	}
+}
+
+// WithEventHandler called with each Event.
+func WithEventHandler(handle func(Event)) Option {
+	return func(w *Watchdog) {
+		w.handleEvent = handle
+	}
+}
+
+// New Watchdog which cycles the device at raddr when every one of the probes
+// fails. There should be at least one probe.
+func New(raddr *net.UDPAddr, probes []Probe, opts ...Option) *Watchdog {
+	w := &Watchdog{
+		raddr:            raddr,
+		probes:           probes,
+		failures:         DefaultFailures,
+		cycleOff:         DefaultCycleOff,
+		backoff:          DefaultBackoff,
+		maxCyclesPerHour: DefaultMaxCyclesPerHour,
+		probeTimeout:     DefaultProbeTimeout,
+		handleEvent:      func(Event) {},
+		now:              time.Now,
+		setRelayState:    kasa.SetRelayStateChecked,
+		sleep:            relay.Sleep,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.failures < 1 {
+		w.failures = 1
+	}
+	return w
+}
+
+// Run probes every interval until the context is canceled. Probing pauses
+// while the device is cycled.
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		w.check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (w *Watchdog) event(typ EventType, err error) {
+	w.handleEvent(Event{Time: w.now(), Type: typ, Device: w.raddr.String(), Err: err})
+}
+
+// check connectivity once, and cycle the device if it has been lost for long
+// enough.
+func (w *Watchdog) check(ctx context.Context) {
+	err := w.probe(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		if w.down {
+			w.event(Up, nil)
+		}
+		w.failed, w.down, w.delay, w.limited = 0, false, 0, false
+		return
+	}
+	w.failed++
+	if w.failed < w.failures {
+		return
+	}
+	if !w.down {
+		w.down = true
+		w.event(Down, err)
+	}
+	now := w.now()
+	if now.Before(w.nextCycle) {
+		return
+	}
+	var recent []time.Time
+	for _, t := range w.cycles {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	w.cycles = recent
+	if w.maxCyclesPerHour >= 0 && len(w.cycles) >= w.maxCyclesPerHour {
+		if !w.limited {
+			w.limited = true
+			w.event(Limited, nil)
+		}
+		return
+	}
+	w.limited = false
+	// Failed cycles count towards the limits as well, so that an unreachable
+	// device is not retried at every probe.
+	w.cycles = append(w.cycles, now)
+	err = w.cycle(ctx)
+	switch {
+	case w.delay == 0:
+		w.delay = w.backoff
+	case w.delay < MaxBackoff:
+		w.delay *= 2
+		if w.delay > MaxBackoff {
+			w.delay = MaxBackoff
+		}
+	}
+	w.nextCycle = w.now().Add(w.delay)
+	w.failed = 0
+	w.event(Cycled, err)
+}
+
+// probe connectivity, which is lost only if every probe fails.
+func (w *Watchdog) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.probeTimeout)
+	defer cancel()
+	errs := make([]error, len(w.probes))
+	var wg sync.WaitGroup
+	for i, p := range w.probes {
+		wg.Add(1)
+		go func(i int, p Probe) {
+			defer wg.Done()
+			if err := p.Probe(ctx); err != nil {
+				errs[i] = fmt.Errorf("%v: %v", p, err)
+			}
+		}(i, p)
+	}
+	wg.Wait()
+	var failures []string
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, err.Error())
+	}
+	return fmt.Errorf("%w: %v", ErrProbesFailed, strings.Join(failures, "; "))
+}
+
+func (w *Watchdog) set(ctx context.Context, state bool) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	return w.setRelayState(ctx, w.raddr, w.laddr, state)
+}
+
+// cycle the device off and on, turning it back on even if the watchdog is
+// stopping.
+func (w *Watchdog) cycle(ctx context.Context) error {
+	return relay.Cycle(ctx, w.cycleOff, w.set, w.sleep)
+}
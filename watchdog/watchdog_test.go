@@ -0,0 +1,198 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeProbe fails while err is set.
+type fakeProbe struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (p *fakeProbe) Probe(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *fakeProbe) String() string { return "fake://" }
+
+func (p *fakeProbe) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+var (
+	at      = time.Date(2021, time.May, 8, 17, 2, 11, 0, time.UTC)
+	modem   = &net.UDPAddr{IP: net.ParseIP("10.24.6.14"), Port: 9999}
+	errDown = errors.New("network is unreachable")
+)
+
+// testWatchdog whose clock is advanced by check, which checks once. Returned
+// are the events reported, and the relay states set as "off" or "on".
+func testWatchdog(t *testing.T, probes []Probe, opts ...Option) (w *Watchdog, check func(advance time.Duration), events *[]Event, calls *[]string) {
+	t.Helper()
+	now := at
+	events, calls = new([]Event), new([]string)
+	opts = append(opts, WithEventHandler(func(e Event) {
+		*events = append(*events, e)
+	}))
+	w = New(modem, probes, opts...)
+	w.now = func() time.Time { return now }
+	w.sleep = func(context.Context, time.Duration) {}
+	w.setRelayState = func(_ context.Context, raddr, _ *net.UDPAddr, state bool) error {
+		if raddr != modem {
+			t.Errorf("setRelayState(): got device %v, want %v", raddr, modem)
+		}
+		*calls = append(*calls, map[bool]string{false: "off", true: "on"}[state])
+		return nil
+	}
+	check = func(advance time.Duration) {
+		now = now.Add(advance)
+		w.check(context.Background())
+	}
+	return w, check, events, calls
+}
+
+func eventTypes(events []Event) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, fmt.Sprintf("%v %v", e.Time.Sub(at), e.Type))
+	}
+	return types
+}
+
+func TestWatchdogThreshold(t *testing.T) {
+	p := &fakeProbe{err: errDown}
+	_, check, events, calls := testWatchdog(t, []Probe{p}, WithFailures(3))
+	check(0)
+	check(time.Minute)
+	if len(*calls) > 0 || len(*events) > 0 {
+		t.Fatalf("acted before the failure threshold: %v %v", *calls, *events)
+	}
+	check(time.Minute)
+	if diff := cmp.Diff([]string{"off", "on"}, *calls); diff != "" {
+		t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+	}
+	want := []Event{
+		{Time: at.Add(2 * time.Minute), Type: Down, Device: modem.String(), Err: ErrProbesFailed},
+		{Time: at.Add(2 * time.Minute), Type: Cycled, Device: modem.String()},
+	}
+	if diff := cmp.Diff(want, *events, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchdogAnyProbeSucceeds(t *testing.T) {
+	down, up := &fakeProbe{err: errDown}, &fakeProbe{}
+	_, check, events, calls := testWatchdog(t, []Probe{down, up}, WithFailures(1))
+	check(0)
+	if len(*calls) > 0 || len(*events) > 0 {
+		t.Fatalf("acted with a successful probe: %v %v", *calls, *events)
+	}
+	up.fail(errDown)
+	check(time.Minute)
+	if diff := cmp.Diff([]string{"off", "on"}, *calls); diff != "" {
+		t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchdogBackoff(t *testing.T) {
+	p := &fakeProbe{err: errDown}
+	_, check, events, _ := testWatchdog(t, []Probe{p},
+		WithFailures(1),
+		WithBackoff(10*time.Minute),
+		WithMaxCyclesPerHour(-1))
+	check(0)
+	for i := 0; i < 59; i++ {
+		check(time.Minute)
+	}
+	want := []string{"0s down", "0s cycled", "10m0s cycled", "30m0s cycled"}
+	if diff := cmp.Diff(want, eventTypes(*events)); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchdogMaxCyclesPerHour(t *testing.T) {
+	p := &fakeProbe{err: errDown}
+	_, check, events, _ := testWatchdog(t, []Probe{p},
+		WithFailures(1),
+		WithBackoff(5*time.Minute),
+		WithMaxCyclesPerHour(2))
+	check(0)
+	for i := 0; i < 12; i++ {
+		check(5 * time.Minute)
+	}
+	want := []string{"0s down", "0s cycled", "5m0s cycled", "15m0s limited", "1h0m0s cycled"}
+	if diff := cmp.Diff(want, eventTypes(*events)); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchdogRecovery(t *testing.T) {
+	p := &fakeProbe{err: errDown}
+	_, check, events, _ := testWatchdog(t, []Probe{p},
+		WithFailures(1),
+		WithBackoff(10*time.Minute),
+		WithMaxCyclesPerHour(-1))
+	check(0)
+	p.fail(nil)
+	check(time.Minute)
+	check(time.Minute)
+	p.fail(errDown)
+	for i := 0; i < 22; i++ {
+		check(time.Minute)
+	}
+	// The device is not cycled again within the backoff of the first cycle,
+	// but the backoff was reset by recovery so does not double.
+	want := []string{"0s down", "0s cycled", "1m0s up", "3m0s down", "10m0s cycled", "20m0s cycled"}
+	if diff := cmp.Diff(want, eventTypes(*events)); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatchdogCycleError(t *testing.T) {
+	p := &fakeProbe{err: errDown}
+	w, check, events, _ := testWatchdog(t, []Probe{p}, WithFailures(1))
+	w.setRelayState = func(context.Context, *net.UDPAddr, *net.UDPAddr, bool) error {
+		return errors.New("no response")
+	}
+	check(0)
+	if len(*events) != 2 || (*events)[1].Type != Cycled || (*events)[1].Err == nil {
+		t.Errorf("got events %v, want a failed cycle", *events)
+	}
+}
+
+func TestWatchdogLocalListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseProbe("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, check, events, calls := testWatchdog(t, []Probe{p}, WithFailures(2), WithProbeTimeout(time.Second))
+	check(0)
+	check(time.Minute)
+	if len(*calls) > 0 || len(*events) > 0 {
+		t.Fatalf("acted while listening: %v %v", *calls, *events)
+	}
+	l.Close()
+	check(time.Minute)
+	check(time.Minute)
+	if diff := cmp.Diff([]string{"off", "on"}, *calls); diff != "" {
+		t.Errorf("relay calls mismatch (-want +got):\n%s", diff)
+	}
+}